@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiKey is a configured key, stored only as its hash, and the principal it
+// authenticates as
+type apiKey struct {
+	hash      [sha256.Size]byte
+	principal string
+}
+
+// Principal is the caller a request is authenticated as. Profiles and tasks
+// are owned by principals; admins can see and change everything.
+type Principal struct {
+	ID    string
+	Admin bool
+}
+
+// principalKey is the gin context key holding the request's Principal
+const principalKey = "principal"
+
+var (
+	apiKeys         []apiKey
+	adminPrincipals map[string]bool
+	authDisabled    bool
+)
+
+// InitAuth loads the API keys allowed to call /api/v1. Keys come from
+// API_KEYS (comma-separated) and API_KEY_SHA256 (comma-separated hex SHA-256
+// digests, so the keys themselves needn't be stored). Each entry may be
+// prefixed with "principal:" to name its owner; unnamed keys get an ID
+// derived from their hash. Principals listed in ADMIN_PRINCIPALS see every
+// owner's data. AUTH_DISABLED=true leaves the API open for local development.
+func InitAuth() error {
+	authDisabled = getEnvBool("AUTH_DISABLED", false)
+	apiKeys = nil
+	adminPrincipals = make(map[string]bool)
+
+	for _, entry := range splitList(os.Getenv("API_KEYS")) {
+		principal, key := splitPrincipal(entry)
+		apiKeys = append(apiKeys, newAPIKey(principal, sha256.Sum256([]byte(key))))
+	}
+	for _, entry := range splitList(os.Getenv("API_KEY_SHA256")) {
+		principal, digest := splitPrincipal(entry)
+		decoded, err := hex.DecodeString(digest)
+		if err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("invalid API_KEY_SHA256 entry %q", digest)
+		}
+		var hash [sha256.Size]byte
+		copy(hash[:], decoded)
+		apiKeys = append(apiKeys, newAPIKey(principal, hash))
+	}
+	for _, principal := range splitList(os.Getenv("ADMIN_PRINCIPALS")) {
+		adminPrincipals[principal] = true
+	}
+
+	if authDisabled {
+		slog.Warn("AUTH_DISABLED is set; the API is open to anyone who can reach it")
+		return nil
+	}
+	if len(apiKeys) == 0 {
+		return fmt.Errorf("no API keys configured; set API_KEYS or API_KEY_SHA256, or AUTH_DISABLED=true for local development")
+	}
+
+	slog.Info("API key authentication enabled", "keys", len(apiKeys), "admins", len(adminPrincipals))
+	return nil
+}
+
+// splitPrincipal splits a "principal:secret" entry; the principal is optional
+func splitPrincipal(entry string) (principal, secret string) {
+	if principal, secret, ok := strings.Cut(entry, ":"); ok {
+		return strings.TrimSpace(principal), strings.TrimSpace(secret)
+	}
+	return "", entry
+}
+
+// newAPIKey pairs a key hash with its principal, naming unnamed keys after
+// the start of their hash so their IDs stay stable across restarts
+func newAPIKey(principal string, hash [sha256.Size]byte) apiKey {
+	if principal == "" {
+		principal = "key-" + hex.EncodeToString(hash[:4])
+	}
+	return apiKey{hash: hash, principal: principal}
+}
+
+// AuthMiddleware rejects requests without a valid API key, given either as
+// "Authorization: Bearer <key>" or in the X-API-Key header, and records the
+// authenticated Principal on the context
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authDisabled {
+			c.Set(principalKey, Principal{Admin: true})
+			c.Next()
+			return
+		}
+
+		key := requestAPIKey(c)
+		principal, ok := lookupAPIKey(key)
+		if key == "" || !ok {
+			requestLogger(c).Warn("Rejected unauthenticated request", "path", c.Request.URL.Path, "key_provided", key != "")
+			c.Header("WWW-Authenticate", `Bearer realm="api"`)
+			abortWithError(c, http.StatusUnauthorized, "Missing or invalid API key")
+			return
+		}
+
+		c.Set(principalKey, Principal{ID: principal, Admin: adminPrincipals[principal]})
+		c.Next()
+	}
+}
+
+// requestAPIKey returns the API key supplied with a request, if any
+func requestAPIKey(c *gin.Context) string {
+	if scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return strings.TrimSpace(c.GetHeader("X-API-Key"))
+}
+
+// lookupAPIKey returns the principal for a key. Every configured hash is
+// compared in constant time so timing doesn't reveal which is close.
+func lookupAPIKey(key string) (string, bool) {
+	hash := sha256.Sum256([]byte(key))
+	principal, found := "", false
+	for i := range apiKeys {
+		if subtle.ConstantTimeCompare(hash[:], apiKeys[i].hash[:]) == 1 {
+			principal, found = apiKeys[i].principal, true
+		}
+	}
+	return principal, found
+}
+
+// callerPrincipal returns the Principal the request was authenticated as
+func callerPrincipal(c *gin.Context) Principal {
+	if p, ok := c.Get(principalKey); ok {
+		return p.(Principal)
+	}
+	return Principal{}
+}
+
+// ownerScope returns the owner ID to restrict listings to, or "" for admins,
+// who see every owner's data
+func (p Principal) ownerScope() string {
+	if p.Admin {
+		return ""
+	}
+	return p.ID
+}
+
+// owns reports whether the principal may access data owned by ownerID
+func (p Principal) owns(ownerID string) bool {
+	return p.Admin || (p.ID != "" && p.ID == ownerID)
+}
+
+// splitList splits a comma-separated value, dropping blanks
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the service while its breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops calls to a failing service. It opens after maxFailures
+// consecutive failures, fails fast while open, and after openTimeout lets a
+// single probe call through (half-open) to decide whether to close again.
+type CircuitBreaker struct {
+	name        string
+	maxFailures int
+	openTimeout time.Duration
+	isFailure   func(error) bool // which errors count against the service
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker creates a closed breaker. isFailure decides which errors
+// count as service failures; other errors are passed through like successes.
+func NewCircuitBreaker(name string, maxFailures int, openTimeout time.Duration, isFailure func(error) bool) *CircuitBreaker {
+	if maxFailures < 1 {
+		maxFailures = 1
+	}
+	return &CircuitBreaker{
+		name:        name,
+		maxFailures: maxFailures,
+		openTimeout: openTimeout,
+		isFailure:   isFailure,
+		state:       breakerClosed,
+	}
+}
+
+// newCircuitBreakerFromEnv creates a breaker configured by
+// <PREFIX>_BREAKER_MAX_FAILURES and <PREFIX>_BREAKER_OPEN_TIMEOUT
+func newCircuitBreakerFromEnv(name, prefix string, isFailure func(error) bool) *CircuitBreaker {
+	prefix = strings.ToUpper(prefix)
+	return NewCircuitBreaker(name,
+		getEnvInt(prefix+"_BREAKER_MAX_FAILURES", 5),
+		getEnvDuration(prefix+"_BREAKER_OPEN_TIMEOUT", 30*time.Second),
+		isFailure,
+	)
+}
+
+// Execute runs fn unless the breaker is open, recording the outcome
+func (b *CircuitBreaker) Execute(fn func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+
+	err := fn()
+	// A cancelled caller says nothing about the service's health
+	b.record(err == nil || errors.Is(err, context.Canceled) || !b.isFailure(err))
+	return err
+}
+
+// State returns the breaker's current state
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && time.Since(b.openedAt) >= b.openTimeout {
+		return breakerHalfOpen
+	}
+	return b.state
+}
+
+// allow reports whether a call may proceed, moving an open breaker to
+// half-open once its timeout has passed
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return ErrCircuitOpen
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		// Only one probe at a time while half-open
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// record updates the breaker with the outcome of a call
+func (b *CircuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if success {
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.setState(breakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.maxFailures {
+		b.openedAt = time.Now()
+		if b.state != breakerOpen {
+			b.setState(breakerOpen)
+		}
+	}
+}
+
+// setState changes state and logs the transition. Callers must hold b.mu.
+func (b *CircuitBreaker) setState(state string) {
+	from := b.state
+	b.state = state
+
+	logger := slog.With("breaker", b.name, "from", from, "to", state)
+	if state == breakerOpen {
+		logger.Warn("Circuit breaker opened", "failures", b.failures, "open_timeout", b.openTimeout.String())
+	} else {
+		logger.Info("Circuit breaker state changed")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Helper functions to read typed values from environment variables
+func getEnvInt(key string, defaultVal int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultVal
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Warn("Invalid environment variable, using default", "key", key, "value", v, "default", defaultVal)
+		return defaultVal
+	}
+	return n
+}
+
+func getEnvInt64(key string, defaultVal int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultVal
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		slog.Warn("Invalid environment variable, using default", "key", key, "value", v, "default", defaultVal)
+		return defaultVal
+	}
+	return n
+}
+
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultVal
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Warn("Invalid environment variable, using default", "key", key, "value", v, "default", defaultVal)
+		return defaultVal
+	}
+	return d
+}
+
+func getEnvBool(key string, defaultVal bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultVal
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("Invalid environment variable, using default", "key", key, "value", v, "default", defaultVal)
+		return defaultVal
+	}
+	return b
+}
+
+func getEnvFloat(key string, defaultVal float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultVal
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		slog.Warn("Invalid environment variable, using default", "key", key, "value", v, "default", defaultVal)
+		return defaultVal
+	}
+	return f
+}
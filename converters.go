@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+)
+
+// maxSuggestedConverters caps the registry converters attached to one match
+const maxSuggestedConverters = 3
+
+// normalizeConverterRole maps Gemini's free-text recommended converter onto a
+// known role, defaulting to the producer
+func normalizeConverterRole(role string) string {
+	switch strings.NewReplacer("_", "-", " ", "-").Replace(normalizeKey(role)) {
+	case ConverterConsumer:
+		return ConverterConsumer
+	case ConverterThirdParty, "thirdparty":
+		return ConverterThirdParty
+	default:
+		return ConverterProducer
+	}
+}
+
+// converterLookup finds registry converters for one waste stream. The
+// registry is queried at most once, and only if a match needs a converter.
+type converterLookup struct {
+	output         Output
+	classification *WasteClassification
+
+	converters []*Converter
+	done       bool
+}
+
+// attach suggests converters for a match that needs third-party conversion
+func (l *converterLookup) attach(ctx context.Context, match *MatchRecommendation, producer *IndustryProfile) {
+	if !match.ConversionNeeded || match.RecommendedConverter != ConverterThirdParty {
+		return
+	}
+
+	if !l.done {
+		l.done = true
+		keys := []string{normalizeKey(l.output.Name), l.output.canonicalName()}
+		if l.classification.WasteType != "" {
+			keys = append(keys, normalizeKey(l.classification.WasteType))
+		}
+		for _, tag := range l.classification.Tags {
+			keys = append(keys, normalizeKey(tag))
+		}
+
+		converters, err := FindConverters(keys)
+		if err != nil {
+			loggerFromContext(ctx).Warn("Failed to look up converters", "waste", l.output.Name, "error", err)
+		}
+		l.converters = converters
+	}
+
+	match.ConverterIDs = rankConverters(l.converters, producer.Location, match.ConversionDescription)
+}
+
+// rankConverters returns the IDs of the best converters for a conversion:
+// those offering a process named in its description first, then the nearest
+// to the producer
+func rankConverters(converters []*Converter, producerLocation Location, description string) []string {
+	description = strings.ToLower(description)
+
+	type candidate struct {
+		id       string
+		relevant bool
+		distance float64
+	}
+	candidates := make([]candidate, len(converters))
+	for i, converter := range converters {
+		candidates[i] = candidate{id: converter.ID, distance: math.Inf(1)}
+		for _, conversionType := range converter.ConversionTypes {
+			if conversionType != "" && strings.Contains(description, strings.ToLower(conversionType)) {
+				candidates[i].relevant = true
+				break
+			}
+		}
+		if converter.Location != nil && !converter.Location.IsUnknown() && !producerLocation.IsUnknown() {
+			candidates[i].distance = calculateDistance(producerLocation, *converter.Location)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].relevant != candidates[j].relevant {
+			return candidates[i].relevant
+		}
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	var ids []string
+	for _, c := range candidates[:min(len(candidates), maxSuggestedConverters)] {
+		ids = append(ids, c.id)
+	}
+	return ids
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORSMiddleware allows browser requests from the origins in ALLOWED_ORIGINS
+// (comma-separated). The request's Origin is echoed back only when it is in
+// the list; other origins get no CORS headers, so browsers block them.
+// ALLOWED_ORIGINS=* allows any origin.
+func CORSMiddleware() gin.HandlerFunc {
+	allowAll := false
+	allowed := make(map[string]bool)
+	for _, origin := range splitList(os.Getenv("ALLOWED_ORIGINS")) {
+		if origin == "*" {
+			allowAll = true
+		}
+		allowed[origin] = true
+	}
+	if len(allowed) == 0 {
+		slog.Info("ALLOWED_ORIGINS not set; cross-origin browser requests are disabled")
+	}
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		c.Writer.Header().Add("Vary", "Origin")
+
+		if origin != "" && (allowAll || allowed[origin]) {
+			if allowAll {
+				c.Header("Access-Control-Allow-Origin", "*")
+			} else {
+				c.Header("Access-Control-Allow-Origin", origin)
+			}
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Request-ID, If-None-Match")
+			c.Header("Access-Control-Expose-Headers", "X-Request-ID, ETag")
+		}
+
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
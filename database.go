@@ -3,239 +3,1508 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
-var db *sql.DB
+var (
+	dbConn atomic.Pointer[sql.DB] // nil until OpenDB succeeds
+	dbMu   sync.Mutex             // serializes OpenDB and CloseDB
+)
+
+// currentDB returns the open database connection, or nil before OpenDB has
+// succeeded. Callers check checkDB first.
+func currentDB() *sql.DB {
+	return dbConn.Load()
+}
+
+// ErrVersionConflict is returned when saving a profile that was changed by
+// someone else since it was loaded
+var ErrVersionConflict = errors.New("profile was modified concurrently")
+
+// ErrNotInitialized is returned when the database, storage, or LLM client is
+// used before its Init function has succeeded
+var ErrNotInitialized = errors.New("not initialized")
+
+// checkDB returns an error wrapping ErrNotInitialized if there is no open
+// database connection
+func checkDB() error {
+	if currentDB() == nil {
+		return fmt.Errorf("database %w", ErrNotInitialized)
+	}
+	return nil
+}
+
+// InitDB initializes the database connection and applies pending migrations
+func InitDB() error {
+	if err := OpenDB(); err != nil {
+		return err
+	}
+
+	// Bring the schema up to date
+	return MigrateUp()
+}
+
+// OpenDB opens and verifies the database connection, sizing the pool from
+// DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS, DB_CONN_MAX_LIFETIME, and
+// DB_CONN_MAX_IDLE_TIME. Once it has connected, later calls do nothing until
+// CloseDB.
+func OpenDB() error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	if currentDB() != nil {
+		return nil
+	}
+
+	conn, err := openDB()
+	if err != nil {
+		return err
+	}
+	dbConn.Store(conn)
+	return nil
+}
+
+// openDB connects and verifies the connection, so the database handle is
+// only ever nil or usable
+func openDB() (*sql.DB, error) {
+	connStr := os.Getenv("DATABASE_URL")
+	if connStr == "" {
+		connStr = "host=localhost port=5432 user=postgres password=postgres dbname=industrial_symbiosis sslmode=disable"
+	}
+
+	conn, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	// A max of zero or less leaves open connections unlimited
+	maxOpen := getEnvInt("DB_MAX_OPEN_CONNS", 25)
+	maxIdle := getEnvInt("DB_MAX_IDLE_CONNS", 10)
+	if maxOpen > 0 {
+		maxIdle = min(maxIdle, maxOpen)
+	}
+	maxLifetime := getEnvDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute)
+	maxIdleTime := getEnvDuration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute)
+
+	conn.SetMaxOpenConns(maxOpen)
+	conn.SetMaxIdleConns(maxIdle)
+	conn.SetConnMaxLifetime(maxLifetime)
+	conn.SetConnMaxIdleTime(maxIdleTime)
+	slog.Info("Database connection pool configured",
+		"max_open_conns", maxOpen,
+		"max_idle_conns", maxIdle,
+		"conn_max_lifetime", maxLifetime.String(),
+		"conn_max_idle_time", maxIdleTime.String(),
+	)
+
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// CloseDB closes the database connection. The next OpenDB or InitDB
+// connects again.
+func CloseDB() error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	conn := dbConn.Swap(nil)
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx, so writes can run
+// standalone or as part of a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// SaveProfile saves an industry profile to the database. An existing profile
+// is only updated if it is still at profile.Version, otherwise
+// ErrVersionConflict is returned; on success profile.Version is updated.
+func SaveProfile(profile *IndustryProfile) error {
+	return saveProfile(currentDB(), profile)
+}
+
+func saveProfile(e execer, profile *IndustryProfile) error {
+	if err := profile.Location.Validate(); err != nil {
+		return err
+	}
+
+	locationJSON, _ := json.Marshal(profile.Location)
+	inputsJSON, _ := json.Marshal(profile.Inputs)
+	outputsJSON, _ := json.Marshal(profile.Outputs)
+
+	// An existing profile is only overwritten at the version it was loaded at
+	query := `
+		INSERT INTO industry_profiles (id, name, location, inputs, outputs, created_at, updated_at, owner_id, extraction_confidence, needs_review, version, language, document_language)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 1, $12, $13)
+		ON CONFLICT (id) DO UPDATE SET
+			name = $2, location = $3, inputs = $4, outputs = $5, updated_at = $7, extraction_confidence = $9, needs_review = $10,
+			language = $12, document_language = $13, version = industry_profiles.version + 1
+		WHERE industry_profiles.version = $11
+		RETURNING version
+	`
+
+	err := e.QueryRow(query, profile.ID, profile.Name, locationJSON, inputsJSON, outputsJSON, profile.CreatedAt, profile.UpdatedAt,
+		nullString(profile.OwnerID), profile.ExtractionConfidence, profile.NeedsReview, profile.Version,
+		defaultString(profile.Language, defaultLanguage()), nullString(profile.DocumentLanguage)).Scan(&profile.Version)
+	if err == sql.ErrNoRows {
+		return ErrVersionConflict
+	}
+	return err
+}
+
+// profileColumns lists the industry_profiles columns read by scanProfile
+const profileColumns = `id, name, location, inputs, outputs, created_at, updated_at, owner_id, extraction_confidence, needs_review, deleted_at, version, matched_at, language, document_language`
+
+// scanProfile scans a row selected with profileColumns into an IndustryProfile
+func scanProfile(row rowScanner, extra ...interface{}) (*IndustryProfile, error) {
+	var profile IndustryProfile
+	var locationJSON, inputsJSON, outputsJSON []byte
+	var ownerID, documentLanguage sql.NullString
+	var deletedAt, matchedAt sql.NullTime
+
+	dest := []interface{}{&profile.ID, &profile.Name, &locationJSON, &inputsJSON, &outputsJSON, &profile.CreatedAt, &profile.UpdatedAt, &ownerID,
+		&profile.ExtractionConfidence, &profile.NeedsReview, &deletedAt, &profile.Version, &matchedAt, &profile.Language, &documentLanguage}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	profile.OwnerID = ownerID.String
+	profile.DocumentLanguage = documentLanguage.String
+	if deletedAt.Valid {
+		profile.DeletedAt = &deletedAt.Time
+	}
+	if matchedAt.Valid {
+		profile.MatchedAt = &matchedAt.Time
+	}
+
+	json.Unmarshal(locationJSON, &profile.Location)
+	json.Unmarshal(inputsJSON, &profile.Inputs)
+	json.Unmarshal(outputsJSON, &profile.Outputs)
+
+	// Profiles saved before quantities were structured only have the raw text,
+	// those saved before canonicalization only the names as extracted, and
+	// those saved before states were normalized the states as extracted
+	parseProfileQuantities(&profile)
+	normalizeProfileStates(&profile)
+	fillCanonicalNames(&profile)
+
+	return &profile, nil
+}
+
+// GetProfile retrieves a profile by ID. Soft-deleted profiles are reported
+// as sql.ErrNoRows unless includeDeleted is set.
+func GetProfile(id string, includeDeleted bool) (*IndustryProfile, error) {
+	query := `SELECT ` + profileColumns + ` FROM industry_profiles WHERE id = $1 AND ($2 OR ` + notDeleted + `)`
+	return scanProfile(currentDB().QueryRow(query, id, includeDeleted))
+}
+
+// ownedBy is a condition on a query's $1 parameter restricting rows to one
+// owner, where an empty owner ID matches every row
+const ownedBy = `($1 = '' OR owner_id = $1)`
+
+// notDeleted is a condition excluding soft-deleted profiles
+const notDeleted = `deleted_at IS NULL`
+
+// ListAllProfiles retrieves a page of profiles, newest first, along with the
+// total count. Profiles created in the same instant are ordered by ID, so
+// pages neither repeat nor skip rows. A non-empty ownerID restricts the listing to that owner's profiles, and
+// soft-deleted profiles are left out unless includeDeleted is set.
+// A limit of zero or less returns every profile.
+func ListAllProfiles(ownerID string, includeDeleted bool, limit, offset int) ([]*IndustryProfile, int, error) {
+	var total int
+	if err := currentDB().QueryRow(`SELECT COUNT(*) FROM industry_profiles WHERE `+ownedBy+` AND ($2 OR `+notDeleted+`)`, ownerID, includeDeleted).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + profileColumns + ` FROM industry_profiles WHERE ` + ownedBy + ` AND ($4 OR ` + notDeleted + `) ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3`
+
+	rows, err := currentDB().Query(query, ownerID, sqlLimit(limit), offset, includeDeleted)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var profiles []*IndustryProfile
+	for rows.Next() {
+		profile, err := scanProfile(rows)
+		if err != nil {
+			continue
+		}
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, total, nil
+}
+
+// ListProfilesDueForRematch returns up to limit profiles whose matches may be
+// out of date, least recently matched first: those never matched, and those
+// whose last match run started before staleBefore and before another profile
+// was added or updated. Profiles awaiting review, or with a match run queued
+// or in progress since staleBefore, are skipped.
+func ListProfilesDueForRematch(staleBefore time.Time, limit int) ([]*IndustryProfile, error) {
+	query := `
+		SELECT ` + profileColumns + `
+		FROM industry_profiles p
+		LEFT JOIN LATERAL (
+			SELECT MAX(created_at) AS last_run,
+				COALESCE(BOOL_OR(status IN ('pending', 'processing') AND created_at > $1), FALSE) AS active
+			FROM tasks
+			WHERE profile_id = p.id AND type = 'match_generation'
+		) t ON TRUE
+		WHERE p.` + notDeleted + ` AND NOT p.needs_review AND NOT t.active
+			AND (t.last_run IS NULL OR (t.last_run < $1 AND EXISTS (
+				SELECT 1 FROM industry_profiles o
+				WHERE o.id <> p.id AND o.` + notDeleted + ` AND NOT o.needs_review AND o.updated_at > t.last_run
+			)))
+		ORDER BY t.last_run NULLS FIRST, p.id
+		LIMIT $2`
+
+	rows, err := currentDB().Query(query, staleBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []*IndustryProfile
+	for rows.Next() {
+		profile, err := scanProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, rows.Err()
+}
+
+// ListProfilesForReview retrieves a page of profiles flagged for review,
+// oldest first, along with the total count. Each carries the stored documents
+// of the upload it was extracted from. A non-empty ownerID restricts the
+// listing to that owner's profiles.
+func ListProfilesForReview(ownerID string, limit, offset int) ([]*ReviewProfile, int, error) {
+	var total int
+	if err := currentDB().QueryRow(`SELECT COUNT(*) FROM industry_profiles WHERE needs_review AND `+notDeleted+` AND `+ownedBy, ownerID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT ` + profileColumns + `, doc.file_url, doc.file_urls
+		FROM industry_profiles
+		LEFT JOIN LATERAL (
+			SELECT file_url, file_urls FROM tasks
+			WHERE tasks.profile_id = industry_profiles.id AND tasks.type = 'document_parse'
+			ORDER BY tasks.created_at DESC, tasks.id DESC
+			LIMIT 1
+		) doc ON TRUE
+		WHERE needs_review AND ` + notDeleted + ` AND ` + ownedBy + `
+		ORDER BY created_at, id
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := currentDB().Query(query, ownerID, sqlLimit(limit), offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var profiles []*ReviewProfile
+	for rows.Next() {
+		var fileURL sql.NullString
+		var fileURLsJSON []byte
+		profile, err := scanProfile(rows, &fileURL, &fileURLsJSON)
+		if err != nil {
+			continue
+		}
+
+		var documents []string
+		json.Unmarshal(fileURLsJSON, &documents)
+		if len(documents) == 0 && fileURL.Valid {
+			documents = []string{fileURL.String}
+		}
+		profiles = append(profiles, &ReviewProfile{IndustryProfile: profile, DocumentURLs: documents})
+	}
+
+	return profiles, total, nil
+}
+
+// ApproveProfile clears a profile's review flag. It returns sql.ErrNoRows if
+// no profile with the ID is awaiting review.
+func ApproveProfile(id string) error {
+	result, err := currentDB().Exec(`UPDATE industry_profiles SET needs_review = FALSE, updated_at = $2, version = version + 1 WHERE id = $1 AND needs_review AND `+notDeleted, id, time.Now())
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// StreamProfiles calls fn for each profile, oldest first, reading rows one at
+// a time so large exports aren't held in memory. A non-empty ownerID
+// restricts it to that owner's profiles. It stops at the first error from fn.
+func StreamProfiles(ownerID string, fn func(*IndustryProfile) error) error {
+	rows, err := currentDB().Query(`SELECT `+profileColumns+` FROM industry_profiles WHERE `+ownedBy+` AND `+notDeleted+` ORDER BY created_at, id`, ownerID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		profile, err := scanProfile(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(profile); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListProfilesInBounds retrieves profiles whose location falls inside a lat/lng bounding box.
+// When wrapLng is true the longitude bounds are ignored (the box crosses the antimeridian).
+// A non-empty ownerID restricts the results to that owner's profiles.
+func ListProfilesInBounds(ownerID string, minLat, maxLat, minLng, maxLng float64, wrapLng bool) ([]*IndustryProfile, error) {
+	query := `
+		SELECT ` + profileColumns + `
+		FROM industry_profiles
+		WHERE ` + ownedBy + ` AND ` + notDeleted + `
+		  AND (location->>'lat')::float BETWEEN $2 AND $3
+		  AND ($6 OR (location->>'lng')::float BETWEEN $4 AND $5)
+	`
+
+	rows, err := currentDB().Query(query, ownerID, minLat, maxLat, minLng, maxLng, wrapLng)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []*IndustryProfile
+	for rows.Next() {
+		profile, err := scanProfile(rows)
+		if err != nil {
+			continue
+		}
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
+
+// profileSearchVector is the full-text document for a profile: its name plus
+// the string values in its inputs and outputs. The GIN index uses the same expression.
+const profileSearchVector = `(setweight(to_tsvector('english', name), 'A') ||
+		jsonb_to_tsvector('english', inputs, '["string"]') ||
+		jsonb_to_tsvector('english', outputs, '["string"]'))`
+
+// SearchProfiles runs a ranked full-text search over profile names, inputs, and outputs.
+// Each search term matches as a prefix, so "alum" finds "aluminum dross".
+// A non-empty ownerID restricts the results to that owner's profiles.
+func SearchProfiles(q, ownerID string, limit, offset int) ([]*IndustryProfile, error) {
+	tsQuery := buildPrefixQuery(q)
+	if tsQuery == "" {
+		return nil, nil
+	}
+
+	query := `
+		SELECT ` + profileColumns + `
+		FROM industry_profiles
+		WHERE ` + ownedBy + ` AND ` + notDeleted + ` AND ` + profileSearchVector + ` @@ to_tsquery('english', $2)
+		ORDER BY ts_rank(` + profileSearchVector + `, to_tsquery('english', $2)) DESC, created_at DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	rows, err := currentDB().Query(query, ownerID, tsQuery, sqlLimit(limit), offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []*IndustryProfile
+	for rows.Next() {
+		profile, err := scanProfile(rows)
+		if err != nil {
+			continue
+		}
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
+
+// buildPrefixQuery turns free text into a tsquery ANDing each word as a prefix match
+func buildPrefixQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = strings.ToLower(w) + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// SaveProfileEdit saves a profile after an incremental edit, as SaveProfile,
+// and in the same transaction deletes the pending matches the edit made
+// stale: those for the waste stream stale.Output, or with stale.Inputs, those
+// offering the profile waste as a consumer. Confirmed and rejected matches are
+// kept as a record of decisions made.
+func SaveProfileEdit(profile *IndustryProfile, stale matchScope) error {
+	tx, err := currentDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := saveProfile(tx, profile); err != nil {
+		return err
+	}
+
+	if stale.Output != "" {
+		_, err = tx.Exec(`DELETE FROM match_recommendations WHERE producer_id = $1 AND waste_id = $2 AND status = $3`,
+			profile.ID, stale.Output, MatchStatusPending)
+	} else if stale.Inputs {
+		_, err = tx.Exec(`DELETE FROM match_recommendations WHERE candidate_id = $1 AND status = $2`,
+			profile.ID, MatchStatusPending)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteProfile soft-deletes a profile, hiding it from the API and matching
+// while keeping the row for audit history. Its pending matches are removed
+// in the same transaction; confirmed and rejected ones are kept as a record
+// of decisions made. It returns sql.ErrNoRows if no live profile has the ID.
+func DeleteProfile(id string) error {
+	tx, err := currentDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`UPDATE industry_profiles SET deleted_at = $2, updated_at = $2, version = version + 1 WHERE id = $1 AND `+notDeleted, id, now)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec(`DELETE FROM match_recommendations WHERE (producer_id = $1 OR candidate_id = $1) AND status = $2`, id, MatchStatusPending); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SaveMatch upserts a match. If the same producer, candidate, and waste stream
+// already have a match, its assessment (score, reasoning, conversion) is
+// refreshed while its ID, creation time, and review status are kept; match is
+// updated to reflect the stored row.
+func SaveMatch(match *MatchRecommendation) error {
+	return saveMatch(currentDB(), match)
+}
+
+func saveMatch(e execer, match *MatchRecommendation) error {
+	query := `
+		INSERT INTO match_recommendations 
+		(id, waste_id, producer_id, candidate_id, conversion_needed, conversion_description, 
+		 recommended_converter, score, reasoning, estimated_cost, created_at, confirmed, confirmed_at, status, converter_ids,
+		 cost_low, cost_high, cost_currency, consumer_reasoning, beyond_max_distance, waste_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
+		ON CONFLICT (producer_id, candidate_id, waste_id) DO UPDATE SET
+			conversion_needed = EXCLUDED.conversion_needed,
+			conversion_description = EXCLUDED.conversion_description,
+			recommended_converter = EXCLUDED.recommended_converter,
+			score = EXCLUDED.score,
+			reasoning = EXCLUDED.reasoning,
+			estimated_cost = EXCLUDED.estimated_cost,
+			converter_ids = EXCLUDED.converter_ids,
+			cost_low = EXCLUDED.cost_low,
+			cost_high = EXCLUDED.cost_high,
+			cost_currency = EXCLUDED.cost_currency,
+			consumer_reasoning = EXCLUDED.consumer_reasoning,
+			beyond_max_distance = EXCLUDED.beyond_max_distance,
+			waste_key = EXCLUDED.waste_key
+		RETURNING id, created_at, confirmed, confirmed_at, status
+	`
+
+	var converterIDsJSON []byte
+	if len(match.ConverterIDs) > 0 {
+		converterIDsJSON, _ = json.Marshal(match.ConverterIDs)
+	}
+
+	var costLow, costHigh sql.NullFloat64
+	var costCurrency sql.NullString
+	if r := match.EstimatedCostRange; r != nil {
+		costLow = sql.NullFloat64{Float64: r.Low, Valid: true}
+		costHigh = sql.NullFloat64{Float64: r.High, Valid: true}
+		costCurrency = sql.NullString{String: r.Currency, Valid: true}
+	}
+
+	wasteKey := match.wasteKey
+	if wasteKey == "" {
+		wasteKey = normalizeKey(match.WasteID)
+	}
+
+	var confirmedAt sql.NullTime
+	err := e.QueryRow(query, match.ID, match.WasteID, match.ProducerID, match.CandidateID,
+		match.ConversionNeeded, match.ConversionDescription, match.RecommendedConverter,
+		match.Score, match.Reasoning, match.EstimatedCost, match.CreatedAt, match.Confirmed, match.ConfirmedAt,
+		match.Status, converterIDsJSON, costLow, costHigh, costCurrency, match.ConsumerReasoning, match.BeyondMaxDistance, wasteKey).Scan(&match.ID, &match.CreatedAt, &match.Confirmed, &confirmedAt, &match.Status)
+	if err != nil {
+		return err
+	}
+	if confirmedAt.Valid {
+		match.ConfirmedAt = &confirmedAt.Time
+	}
+
+	if r := match.llmResponse; r != nil {
+		r.CreatedAt = time.Now()
+		_, err = e.Exec(`
+			INSERT INTO match_llm_responses (match_id, response, truncated, created_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (match_id) DO UPDATE SET
+				response = EXCLUDED.response,
+				truncated = EXCLUDED.truncated,
+				created_at = EXCLUDED.created_at
+		`, match.ID, r.Response, r.Truncated, r.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to save LLM response: %w", err)
+		}
+		return nil
+	}
+
+	// A response from an earlier run no longer describes the match
+	if _, err := e.Exec(`DELETE FROM match_llm_responses WHERE match_id = $1`, match.ID); err != nil {
+		return fmt.Errorf("failed to delete stale LLM response: %w", err)
+	}
+	return nil
+}
+
+// matchColumns lists the match_recommendations columns (aliased as m) read by scanMatch
+const matchColumns = `m.id, m.waste_id, m.producer_id, m.candidate_id, m.conversion_needed, m.conversion_description,
+		       m.recommended_converter, m.score, m.reasoning, m.estimated_cost, m.created_at, m.confirmed, m.confirmed_at, m.status, m.converter_ids,
+		       m.cost_low, m.cost_high, m.cost_currency, m.consumer_reasoning, m.beyond_max_distance`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanMatch scans a row selected with matchColumns into a MatchRecommendation
+func scanMatch(row rowScanner, extra ...interface{}) (*MatchRecommendation, error) {
+	var match MatchRecommendation
+	var converterIDsJSON []byte
+	var costLow, costHigh sql.NullFloat64
+	var costCurrency sql.NullString
+	dest := []interface{}{&match.ID, &match.WasteID, &match.ProducerID, &match.CandidateID,
+		&match.ConversionNeeded, &match.ConversionDescription, &match.RecommendedConverter,
+		&match.Score, &match.Reasoning, &match.EstimatedCost, &match.CreatedAt,
+		&match.Confirmed, &match.ConfirmedAt, &match.Status, &converterIDsJSON,
+		&costLow, &costHigh, &costCurrency, &match.ConsumerReasoning, &match.BeyondMaxDistance}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	if len(converterIDsJSON) > 0 {
+		json.Unmarshal(converterIDsJSON, &match.ConverterIDs)
+	}
+	if costLow.Valid && costHigh.Valid && costCurrency.Valid {
+		match.EstimatedCostRange = &CostRange{Low: costLow.Float64, High: costHigh.Float64, Currency: costCurrency.String}
+	}
+	return &match, nil
+}
+
+// FlagDistantMatches sets beyond_max_distance on matches whose profiles are
+// further apart than maxKm and clears it on the rest, so matches made before
+// the cap, or before a profile moved, stand out without being deleted. A
+// non-empty profileID limits it to that profile's matches. It returns how many
+// matches are flagged. Only rows whose flag changes are written; with no cap,
+// distances aren't computed and any flags left from an earlier cap are cleared.
+func FlagDistantMatches(profileID string, maxKm float64) (int, error) {
+	if maxKm <= 0 {
+		_, err := currentDB().Exec(`
+			UPDATE match_recommendations SET beyond_max_distance = FALSE
+			WHERE beyond_max_distance AND ($1 = '' OR producer_id = $1 OR candidate_id = $1)
+		`, profileID)
+		return 0, err
+	}
+
+	rows, err := currentDB().Query(`
+		SELECT m.id, p.location, c.location
+		FROM match_recommendations m
+		JOIN industry_profiles p ON p.id = m.producer_id
+		JOIN industry_profiles c ON c.id = m.candidate_id
+		WHERE $1 = '' OR m.producer_id = $1 OR m.candidate_id = $1
+	`, profileID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	distant := []string{}
+	for rows.Next() {
+		var id string
+		var producerJSON, candidateJSON []byte
+		if err := rows.Scan(&id, &producerJSON, &candidateJSON); err != nil {
+			return 0, err
+		}
+		var producer, candidate Location
+		json.Unmarshal(producerJSON, &producer)
+		json.Unmarshal(candidateJSON, &candidate)
+		if beyondMatchDistance(producer, candidate, maxKm) {
+			distant = append(distant, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	_, err = currentDB().Exec(`
+		UPDATE match_recommendations SET beyond_max_distance = (id = ANY($2))
+		WHERE ($1 = '' OR producer_id = $1 OR candidate_id = $1)
+			AND beyond_max_distance IS DISTINCT FROM (id = ANY($2))
+	`, profileID, pq.Array(distant))
+	if err != nil {
+		return 0, err
+	}
+	return len(distant), nil
+}
+
+// RekeyMatchIDs gives every match whose ID isn't the one MatchID derives for
+// it, such as a match made before IDs were derived, its derived ID, moving
+// its audit entries along and recording the rewrite in match_id_rekeys. It
+// returns how many matches were re-keyed.
+func RekeyMatchIDs() (int, error) {
+	rows, err := currentDB().Query(`SELECT id, producer_id, candidate_id, waste_id FROM match_recommendations`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var oldIDs, newIDs []string
+	for rows.Next() {
+		var id, producerID, candidateID, wasteID string
+		if err := rows.Scan(&id, &producerID, &candidateID, &wasteID); err != nil {
+			return 0, err
+		}
+		if derived := MatchID(producerID, candidateID, wasteID); id != derived {
+			oldIDs = append(oldIDs, id)
+			newIDs = append(newIDs, derived)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(oldIDs) == 0 {
+		return 0, nil
+	}
+
+	tx, err := currentDB().Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Stored model responses follow through their foreign key's ON UPDATE CASCADE
+	statements := []string{
+		`INSERT INTO match_id_rekeys (old_id, new_id, rekeyed_at)
+			SELECT o, n, NOW() FROM unnest($1::text[], $2::text[]) AS r(o, n)
+			ON CONFLICT (old_id) DO NOTHING`,
+		`UPDATE match_recommendations m SET id = r.n
+			FROM unnest($1::text[], $2::text[]) AS r(o, n) WHERE m.id = r.o`,
+		`UPDATE match_audit a SET match_id = r.n
+			FROM unnest($1::text[], $2::text[]) AS r(o, n) WHERE a.match_id = r.o`,
+	}
+	rekeyed := 0
+	for i, statement := range statements {
+		result, err := tx.Exec(statement, pq.Array(oldIDs), pq.Array(newIDs))
+		if err != nil {
+			return 0, err
+		}
+		if i == 1 {
+			n, _ := result.RowsAffected()
+			rekeyed = int(n)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return rekeyed, nil
+}
+
+// SaveMatchResults commits the outcome of a match generation run in one
+// transaction: the profile (if its output tags changed), the new matches, the
+// finished task, and, unless matchedAt is zero, the task's profile's match
+// watermark. Either all of it is saved or none of it.
+func SaveMatchResults(profile *IndustryProfile, matches []*MatchRecommendation, task *Task, matchedAt time.Time) error {
+	tx, err := currentDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// If the profile was edited meanwhile its tags are left to the run the
+	// edit queued, rather than overwriting the edit
+	if profile != nil {
+		if err := saveProfile(tx, profile); err != nil && err != ErrVersionConflict {
+			return fmt.Errorf("failed to save profile: %w", err)
+		}
+	}
+
+	for _, match := range matches {
+		if err := saveMatch(tx, match); err != nil {
+			return fmt.Errorf("failed to save match %s: %w", match.ID, err)
+		}
+	}
+
+	if task != nil {
+		if err := saveTask(tx, task); err != nil {
+			return fmt.Errorf("failed to save task: %w", err)
+		}
+	}
+
+	// The watermark only moves forward, in case runs finish out of order.
+	// It isn't an edit, so neither version nor updated_at change.
+	if task != nil && task.ProfileID != "" && !matchedAt.IsZero() {
+		_, err := tx.Exec(`UPDATE industry_profiles SET matched_at = $2 WHERE id = $1 AND (matched_at IS NULL OR matched_at < $2)`,
+			task.ProfileID, matchedAt)
+		if err != nil {
+			return fmt.Errorf("failed to save match watermark: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if task != nil {
+		taskEvents.Publish(task)
+	}
+	return nil
+}
+
+// GetMatch retrieves a match by ID along with the producer and candidate names
+// and owners
+func GetMatch(id string) (*MatchDetail, error) {
+	query := `
+		SELECT ` + matchColumns + `, p.name, c.name, p.owner_id, c.owner_id
+		FROM match_recommendations m
+		JOIN industry_profiles p ON p.id = m.producer_id
+		JOIN industry_profiles c ON c.id = m.candidate_id
+		WHERE m.id = $1
+	`
+
+	var detail MatchDetail
+	var producerOwner, candidateOwner sql.NullString
+	match, err := scanMatch(currentDB().QueryRow(query, id), &detail.ProducerName, &detail.CandidateName, &producerOwner, &candidateOwner)
+	if err != nil {
+		return nil, err
+	}
+	detail.MatchRecommendation = match
+	detail.producerOwnerID = producerOwner.String
+	detail.candidateOwnerID = candidateOwner.String
+
+	if len(match.ConverterIDs) > 0 {
+		detail.Converters, err = GetConvertersByIDs(match.ConverterIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load converters: %w", err)
+		}
+	}
+
+	return &detail, nil
+}
+
+// StreamMatches calls fn for each match along with its profile names, oldest
+// first, reading rows one at a time. A non-empty ownerID restricts it to
+// matches involving that owner's profiles. It stops at the first error from fn.
+func StreamMatches(ownerID string, fn func(*MatchDetail) error) error {
+	rows, err := currentDB().Query(`
+		SELECT `+matchColumns+`, p.name, c.name
+		FROM match_recommendations m
+		JOIN industry_profiles p ON p.id = m.producer_id
+		JOIN industry_profiles c ON c.id = m.candidate_id
+		WHERE ($1 = '' OR p.owner_id = $1 OR c.owner_id = $1)
+		ORDER BY m.created_at, m.id
+	`, ownerID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var detail MatchDetail
+		match, err := scanMatch(rows, &detail.ProducerName, &detail.CandidateName)
+		if err != nil {
+			return err
+		}
+		detail.MatchRecommendation = match
+		if err := fn(&detail); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetSymbiosisGraph builds the graph of pending and confirmed matches
+// passing filter, best first, with each profile they link as a node.
+// Rejected matches and soft-deleted profiles are left out. A non-empty
+// ownerID restricts it to matches involving that owner's profiles.
+func GetSymbiosisGraph(ownerID string, filter MatchFilter) (*SymbiosisGraph, error) {
+	where, args := filter.where([]string{
+		"($1 = '' OR p.owner_id = $1 OR c.owner_id = $1)",
+		"m.status <> 'rejected'",
+		"p." + notDeleted,
+		"c." + notDeleted,
+	}, []interface{}{ownerID})
+
+	rows, err := currentDB().Query(`
+		SELECT m.id, m.producer_id, p.name, m.candidate_id, c.name, m.waste_id, m.score, m.confirmed
+		FROM match_recommendations m
+		JOIN industry_profiles p ON p.id = m.producer_id
+		JOIN industry_profiles c ON c.id = m.candidate_id
+		WHERE `+where+`
+		ORDER BY m.score DESC, m.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	graph := &SymbiosisGraph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	seen := make(map[string]bool)
+	addNode := func(id, name string) {
+		if !seen[id] {
+			seen[id] = true
+			graph.Nodes = append(graph.Nodes, GraphNode{ID: id, Name: name})
+		}
+	}
+
+	for rows.Next() {
+		var edge GraphEdge
+		var producerName, candidateName string
+		if err := rows.Scan(&edge.ID, &edge.Source, &producerName, &edge.Target, &candidateName,
+			&edge.Waste, &edge.Weight, &edge.Confirmed); err != nil {
+			return nil, err
+		}
+		addNode(edge.Source, producerName)
+		addNode(edge.Target, candidateName)
+		graph.Edges = append(graph.Edges, edge)
+	}
+	return graph, rows.Err()
+}
+
+// MatchFilter holds optional filters for listing matches
+type MatchFilter struct {
+	Status           string
+	MinScore         float64 // matches scoring below this are excluded
+	ConversionNeeded *bool   // nil for either
+}
+
+// where builds the WHERE clause and arguments for a filter, after the given leading conditions
+func (f MatchFilter) where(conditions []string, args []interface{}) (string, []interface{}) {
+	if f.Status != "" {
+		args = append(args, f.Status)
+		conditions = append(conditions, fmt.Sprintf("m.status = $%d", len(args)))
+	}
+	if f.MinScore > 0 {
+		args = append(args, f.MinScore)
+		conditions = append(conditions, fmt.Sprintf("m.score >= $%d", len(args)))
+	}
+	if f.ConversionNeeded != nil {
+		args = append(args, *f.ConversionNeeded)
+		conditions = append(conditions, fmt.Sprintf("m.conversion_needed = $%d", len(args)))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// GetMatchesByProfile retrieves a page of matches for a profile's waste
+// streams, best first with ties ordered by ID, along with the total count.
+// A limit of zero or less returns every match.
+func GetMatchesByProfile(profileID string, filter MatchFilter, limit, offset int) ([]*MatchRecommendation, int, error) {
+	return listProfileMatches("m.producer_id = $1", profileID, filter, limit, offset)
+}
+
+// GetIncomingMatchesByProfile retrieves a page of matches offering other
+// profiles' waste to a profile as the consumer, as GetMatchesByProfile
+func GetIncomingMatchesByProfile(profileID string, filter MatchFilter, limit, offset int) ([]*MatchRecommendation, int, error) {
+	return listProfileMatches("m.candidate_id = $1", profileID, filter, limit, offset)
+}
+
+// listProfileMatches lists matches by a condition on profileID, as $1
+func listProfileMatches(condition, profileID string, filter MatchFilter, limit, offset int) ([]*MatchRecommendation, int, error) {
+	where, args := filter.where([]string{condition}, []interface{}{profileID})
 
-// InitDB initializes the database connection
-func InitDB() error {
-	connStr := os.Getenv("DATABASE_URL")
-	if connStr == "" {
-		connStr = "host=localhost port=5432 user=postgres password=postgres dbname=industrial_symbiosis sslmode=disable"
+	var total int
+	if err := currentDB().QueryRow(`SELECT COUNT(*) FROM match_recommendations m WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
 	}
 
-	var err error
-	db, err = sql.Open("postgres", connStr)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM match_recommendations m
+		WHERE %s 
+		ORDER BY m.score DESC, m.id
+		LIMIT $%d OFFSET $%d
+	`, matchColumns, where, len(args)+1, len(args)+2)
+
+	rows, err := currentDB().Query(query, append(args, sqlLimit(limit), offset)...)
 	if err != nil {
-		return err
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var matches []*MatchRecommendation
+	for rows.Next() {
+		match, err := scanMatch(rows)
+		if err != nil {
+			continue
+		}
+		matches = append(matches, match)
+	}
+
+	return matches, total, nil
+}
+
+// sqlLimit converts a limit to a LIMIT parameter, where NULL means no limit
+func sqlLimit(limit int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(limit), Valid: limit > 0}
+}
+
+// UpdateMatchConfirmation confirms a match on behalf of actor, recording it
+// in the audit log. It returns sql.ErrNoRows if no match has the ID.
+func UpdateMatchConfirmation(matchID, actor string) error {
+	now := time.Now()
+	query := `UPDATE match_recommendations SET confirmed = TRUE, confirmed_at = $2, status = 'confirmed' WHERE id = $1`
+	return updateMatchStatus(matchID, MatchAuditConfirmed, actor, now, query, now)
+}
+
+// UnconfirmMatch returns a confirmed match to pending review on behalf of
+// actor, recording it in the audit log. It returns sql.ErrNoRows if no
+// confirmed match has the ID.
+func UnconfirmMatch(matchID, actor string) error {
+	query := `UPDATE match_recommendations SET status = 'pending', confirmed = FALSE, confirmed_at = NULL WHERE id = $1 AND status = 'confirmed'`
+	return updateMatchStatus(matchID, MatchAuditUnconfirmed, actor, time.Now(), query)
+}
+
+// DeletePendingMatches removes every match still awaiting review, leaving
+// confirmed and rejected ones in place. It returns how many were deleted.
+func DeletePendingMatches() (int64, error) {
+	result, err := currentDB().Exec(`DELETE FROM match_recommendations WHERE status = 'pending'`)
+	if err != nil {
+		return 0, err
 	}
+	return result.RowsAffected()
+}
+
+// RejectMatch marks a match as rejected on behalf of actor, clearing any
+// prior confirmation and recording it in the audit log. It returns
+// sql.ErrNoRows if no match has the ID.
+func RejectMatch(matchID, actor string) error {
+	query := `UPDATE match_recommendations SET status = 'rejected', confirmed = FALSE, confirmed_at = NULL WHERE id = $1`
+	return updateMatchStatus(matchID, MatchAuditRejected, actor, time.Now(), query)
+}
+
+// DeleteMatch removes a match, along with its stored LLM response, on behalf
+// of actor. The deletion is recorded in the audit log, which outlives the
+// match. It returns sql.ErrNoRows if no match has the ID.
+func DeleteMatch(matchID, actor string) error {
+	return updateMatchStatus(matchID, MatchAuditDeleted, actor, time.Now(), `DELETE FROM match_recommendations WHERE id = $1`)
+}
 
-	if err = db.Ping(); err != nil {
+// updateMatchStatus runs query, an update of one match whose $1 is the match
+// ID followed by args, and adds an audit entry for it in the same
+// transaction, so every recorded decision took effect and vice versa. It
+// returns sql.ErrNoRows if the update changed nothing.
+func updateMatchStatus(matchID, action, actor string, at time.Time, query string, args ...interface{}) error {
+	tx, err := currentDB().Begin()
+	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	// Create tables
-	if err = createTables(); err != nil {
+	result, err := tx.Exec(query, append([]interface{}{matchID}, args...)...)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
 		return err
 	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
 
-	return nil
+	_, err = tx.Exec(`INSERT INTO match_audit (match_id, action, actor, created_at) VALUES ($1, $2, $3, $4)`,
+		matchID, action, nullString(actor), at)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+
+	return tx.Commit()
 }
 
-func createTables() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS industry_profiles (
-		id VARCHAR(36) PRIMARY KEY,
-		name VARCHAR(255) NOT NULL,
-		location JSONB NOT NULL,
-		inputs JSONB NOT NULL,
-		outputs JSONB NOT NULL,
-		created_at TIMESTAMP NOT NULL,
-		updated_at TIMESTAMP NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS match_recommendations (
-		id VARCHAR(36) PRIMARY KEY,
-		waste_id VARCHAR(255) NOT NULL,
-		producer_id VARCHAR(36) NOT NULL,
-		candidate_id VARCHAR(36) NOT NULL,
-		conversion_needed BOOLEAN NOT NULL,
-		conversion_description TEXT,
-		recommended_converter VARCHAR(50),
-		score FLOAT NOT NULL,
-		reasoning TEXT,
-		estimated_cost TEXT,
-		created_at TIMESTAMP NOT NULL,
-		confirmed BOOLEAN DEFAULT FALSE,
-		confirmed_at TIMESTAMP,
-		FOREIGN KEY (producer_id) REFERENCES industry_profiles(id),
-		FOREIGN KEY (candidate_id) REFERENCES industry_profiles(id)
-	);
-
-	CREATE TABLE IF NOT EXISTS tasks (
-		id VARCHAR(36) PRIMARY KEY,
-		status VARCHAR(50) NOT NULL,
-		type VARCHAR(50) NOT NULL,
-		file_url TEXT,
-		profile_id VARCHAR(36),
-		error TEXT,
-		result JSONB,
-		created_at TIMESTAMP NOT NULL,
-		completed_at TIMESTAMP,
-		FOREIGN KEY (profile_id) REFERENCES industry_profiles(id)
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);
-	CREATE INDEX IF NOT EXISTS idx_matches_producer ON match_recommendations(producer_id);
-	CREATE INDEX IF NOT EXISTS idx_matches_candidate ON match_recommendations(candidate_id);
-	`
+// GetMatchHistory returns a match's audit entries, oldest first
+func GetMatchHistory(matchID string) ([]*MatchAuditEntry, error) {
+	rows, err := currentDB().Query(`SELECT id, match_id, action, actor, created_at FROM match_audit WHERE match_id = $1 ORDER BY created_at, id`, matchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	_, err := db.Exec(schema)
-	return err
+	history := []*MatchAuditEntry{}
+	for rows.Next() {
+		var entry MatchAuditEntry
+		var actor sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.MatchID, &entry.Action, &actor, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entry.Actor = actor.String
+		history = append(history, &entry)
+	}
+	return history, rows.Err()
 }
 
-// SaveProfile saves an industry profile to the database
-func SaveProfile(profile *IndustryProfile) error {
-	locationJSON, _ := json.Marshal(profile.Location)
-	inputsJSON, _ := json.Marshal(profile.Inputs)
-	outputsJSON, _ := json.Marshal(profile.Outputs)
+// GetMatchLLMResponse returns the raw model response stored for a match, or
+// sql.ErrNoRows if none was
+func GetMatchLLMResponse(matchID string) (*LLMResponse, error) {
+	var r LLMResponse
+	err := currentDB().QueryRow(`SELECT response, truncated, created_at FROM match_llm_responses WHERE match_id = $1`, matchID).
+		Scan(&r.Response, &r.Truncated, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// SaveConverter adds a converter to the registry
+func SaveConverter(converter *Converter) error {
+	wasteTypesJSON, _ := json.Marshal(converter.WasteTypes)
+	conversionTypesJSON, _ := json.Marshal(converter.ConversionTypes)
+	var locationJSON []byte
+	if converter.Location != nil {
+		locationJSON, _ = json.Marshal(converter.Location)
+	}
 
 	query := `
-		INSERT INTO industry_profiles (id, name, location, inputs, outputs, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		ON CONFLICT (id) DO UPDATE SET
-			name = $2, location = $3, inputs = $4, outputs = $5, updated_at = $7
+		INSERT INTO converters (id, name, waste_types, conversion_types, location, contact, website, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 	`
 
-	_, err := db.Exec(query, profile.ID, profile.Name, locationJSON, inputsJSON, outputsJSON, profile.CreatedAt, profile.UpdatedAt)
+	_, err := currentDB().Exec(query, converter.ID, converter.Name, wasteTypesJSON, conversionTypesJSON, locationJSON,
+		nullString(converter.Contact), nullString(converter.Website), converter.CreatedAt)
 	return err
 }
 
-// GetProfile retrieves a profile by ID
-func GetProfile(id string) (*IndustryProfile, error) {
-	query := `SELECT id, name, location, inputs, outputs, created_at, updated_at FROM industry_profiles WHERE id = $1`
+// converterColumns lists the converters columns read by scanConverter
+const converterColumns = `id, name, waste_types, conversion_types, location, contact, website, created_at`
 
-	var profile IndustryProfile
-	var locationJSON, inputsJSON, outputsJSON []byte
+// scanConverter scans a row selected with converterColumns into a Converter
+func scanConverter(row rowScanner) (*Converter, error) {
+	var converter Converter
+	var wasteTypesJSON, conversionTypesJSON, locationJSON []byte
+	var contact, website sql.NullString
 
-	err := db.QueryRow(query, id).Scan(&profile.ID, &profile.Name, &locationJSON, &inputsJSON, &outputsJSON, &profile.CreatedAt, &profile.UpdatedAt)
+	err := row.Scan(&converter.ID, &converter.Name, &wasteTypesJSON, &conversionTypesJSON, &locationJSON,
+		&contact, &website, &converter.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 
-	json.Unmarshal(locationJSON, &profile.Location)
-	json.Unmarshal(inputsJSON, &profile.Inputs)
-	json.Unmarshal(outputsJSON, &profile.Outputs)
+	json.Unmarshal(wasteTypesJSON, &converter.WasteTypes)
+	json.Unmarshal(conversionTypesJSON, &converter.ConversionTypes)
+	if len(locationJSON) > 0 {
+		json.Unmarshal(locationJSON, &converter.Location)
+	}
+	converter.Contact = contact.String
+	converter.Website = website.String
 
-	return &profile, nil
+	return &converter, nil
 }
 
-// ListAllProfiles retrieves all profiles
-func ListAllProfiles() ([]*IndustryProfile, error) {
-	query := `SELECT id, name, location, inputs, outputs, created_at, updated_at FROM industry_profiles ORDER BY created_at DESC`
-
-	rows, err := db.Query(query)
+// queryConverters runs a query selecting converterColumns
+func queryConverters(query string, args ...interface{}) ([]*Converter, error) {
+	rows, err := currentDB().Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var profiles []*IndustryProfile
+	converters := []*Converter{}
 	for rows.Next() {
-		var profile IndustryProfile
-		var locationJSON, inputsJSON, outputsJSON []byte
-
-		err := rows.Scan(&profile.ID, &profile.Name, &locationJSON, &inputsJSON, &outputsJSON, &profile.CreatedAt, &profile.UpdatedAt)
+		converter, err := scanConverter(rows)
 		if err != nil {
 			continue
 		}
-
-		json.Unmarshal(locationJSON, &profile.Location)
-		json.Unmarshal(inputsJSON, &profile.Inputs)
-		json.Unmarshal(outputsJSON, &profile.Outputs)
-
-		profiles = append(profiles, &profile)
+		converters = append(converters, converter)
 	}
 
-	return profiles, nil
+	return converters, rows.Err()
 }
 
-// SaveMatch saves a match recommendation
-func SaveMatch(match *MatchRecommendation) error {
+// ListConverters retrieves a page of the converter registry, optionally only
+// converters accepting the given waste type
+func ListConverters(wasteType string, limit, offset int) ([]*Converter, error) {
 	query := `
-		INSERT INTO match_recommendations 
-		(id, waste_id, producer_id, candidate_id, conversion_needed, conversion_description, 
-		 recommended_converter, score, reasoning, estimated_cost, created_at, confirmed, confirmed_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		SELECT ` + converterColumns + `
+		FROM converters
+		WHERE ($1 = '' OR waste_types ? $1)
+		ORDER BY name, id
+		LIMIT $2 OFFSET $3
 	`
+	return queryConverters(query, normalizeKey(wasteType), sqlLimit(limit), offset)
+}
 
-	_, err := db.Exec(query, match.ID, match.WasteID, match.ProducerID, match.CandidateID,
-		match.ConversionNeeded, match.ConversionDescription, match.RecommendedConverter,
-		match.Score, match.Reasoning, match.EstimatedCost, match.CreatedAt, match.Confirmed, match.ConfirmedAt)
-	return err
+// FindConverters retrieves converters accepting any of the given waste keys
+// (normalized names, types, or tags)
+func FindConverters(keys []string) ([]*Converter, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	query := `SELECT ` + converterColumns + ` FROM converters WHERE waste_types ?| $1`
+	return queryConverters(query, pq.Array(keys))
+}
+
+// GetConvertersByIDs retrieves converters in the order of ids, skipping any
+// that no longer exist
+func GetConvertersByIDs(ids []string) ([]*Converter, error) {
+	found, err := queryConverters(`SELECT `+converterColumns+` FROM converters WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*Converter, len(found))
+	for _, converter := range found {
+		byID[converter.ID] = converter
+	}
+	converters := make([]*Converter, 0, len(ids))
+	for _, id := range ids {
+		if converter, ok := byID[id]; ok {
+			converters = append(converters, converter)
+		}
+	}
+	return converters, nil
 }
 
-// GetMatchesByProfile retrieves all matches for a profile
-func GetMatchesByProfile(profileID string) ([]*MatchRecommendation, error) {
+// ListMaterials returns the distinct materials named in the inputs or outputs
+// of ownerID's profiles (every profile if ownerID is empty), by canonical name
+// where known, with how many profiles name each, most common first. A
+// non-empty prefix keeps names starting with it; a limit of zero or less
+// returns every name.
+func ListMaterials(ownerID string, outputs bool, prefix string, limit int) ([]MaterialCount, error) {
+	// Column names can't be parameters; this is one of two constants
+	column := "inputs"
+	if outputs {
+		column = "outputs"
+	}
+
+	// Inputs saved before they were structured are bare strings
 	query := `
-		SELECT id, waste_id, producer_id, candidate_id, conversion_needed, conversion_description,
-		       recommended_converter, score, reasoning, estimated_cost, created_at, confirmed, confirmed_at
-		FROM match_recommendations 
-		WHERE producer_id = $1 
-		ORDER BY score DESC
+		SELECT name, COUNT(DISTINCT id) AS profiles
+		FROM (
+			SELECT p.id, lower(regexp_replace(btrim(
+				CASE WHEN jsonb_typeof(item) = 'string' THEN item #>> '{}'
+				ELSE COALESCE(NULLIF(item->>'canonical_name', ''), item->>'name') END
+			), '\s+', ' ', 'g')) AS name
+			FROM industry_profiles p,
+				jsonb_array_elements(CASE WHEN jsonb_typeof(p.` + column + `) = 'array' THEN p.` + column + ` ELSE '[]' END) item
+			WHERE p.` + notDeleted + ` AND ` + ownedBy + `
+		) materials
+		WHERE name <> '' AND starts_with(name, $2)
+		GROUP BY name
+		ORDER BY profiles DESC, name
+		LIMIT $3
 	`
 
-	rows, err := db.Query(query, profileID)
+	rows, err := currentDB().Query(query, ownerID, normalizeKey(prefix), sqlLimit(limit))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var matches []*MatchRecommendation
+	materials := []MaterialCount{}
 	for rows.Next() {
-		var match MatchRecommendation
-		err := rows.Scan(&match.ID, &match.WasteID, &match.ProducerID, &match.CandidateID,
-			&match.ConversionNeeded, &match.ConversionDescription, &match.RecommendedConverter,
-			&match.Score, &match.Reasoning, &match.EstimatedCost, &match.CreatedAt,
-			&match.Confirmed, &match.ConfirmedAt)
-		if err != nil {
+		var m MaterialCount
+		if err := rows.Scan(&m.Name, &m.Profiles); err != nil {
+			return nil, err
+		}
+		materials = append(materials, m)
+	}
+	return materials, rows.Err()
+}
+
+// GetSymbiosisStats aggregates profile and match counts. A non-empty ownerID
+// restricts them to that owner's profiles and the matches they produce.
+func GetSymbiosisStats(ownerID string, topWasteTypes int) (*SymbiosisStats, error) {
+	stats := &SymbiosisStats{TopWasteTypes: []WasteTypeCount{}}
+
+	err := currentDB().QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN jsonb_typeof(outputs) = 'array' THEN jsonb_array_length(outputs) ELSE 0 END), 0)
+		FROM industry_profiles
+		WHERE `+ownedBy+` AND `+notDeleted, ownerID).Scan(&stats.Profiles, &stats.WasteStreams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count profiles: %w", err)
+	}
+
+	// Matches are counted under their producer's owner
+	m := &stats.Matches
+	err = currentDB().QueryRow(`
+		SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE m.status = 'pending'),
+			COUNT(*) FILTER (WHERE m.status = 'confirmed'),
+			COUNT(*) FILTER (WHERE m.status = 'rejected'),
+			COALESCE(AVG(m.score), 0),
+			COALESCE(AVG(m.score) FILTER (WHERE m.status = 'confirmed'), 0)
+		FROM match_recommendations m
+		JOIN industry_profiles p ON p.id = m.producer_id
+		WHERE ($1 = '' OR p.owner_id = $1)
+	`, ownerID).Scan(&m.Total, &m.Pending, &m.Confirmed, &m.Rejected, &m.AverageScore, &m.AverageConfirmedScore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count matches: %w", err)
+	}
+
+	// Waste types come from the classification cache, keyed by the waste
+	// stream's canonical name as stored on the match
+	rows, err := currentDB().Query(`
+		SELECT COALESCE(wc.waste_type, 'unclassified') AS waste_type,
+			COUNT(*) AS matches,
+			COUNT(*) FILTER (WHERE m.status = 'confirmed')
+		FROM match_recommendations m
+		JOIN industry_profiles p ON p.id = m.producer_id
+		LEFT JOIN LATERAL (
+			SELECT waste_type FROM waste_classifications
+			WHERE waste_name = m.waste_key
+			ORDER BY classified_at DESC
+			LIMIT 1
+		) wc ON TRUE
+		WHERE ($1 = '' OR p.owner_id = $1)
+		GROUP BY 1
+		ORDER BY matches DESC, waste_type
+		LIMIT $2
+	`, ownerID, topWasteTypes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count waste types: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var wt WasteTypeCount
+		if err := rows.Scan(&wt.WasteType, &wt.Matches, &wt.Confirmed); err != nil {
+			return nil, err
+		}
+		stats.TopWasteTypes = append(stats.TopWasteTypes, wt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	diversion, err := confirmedDiversion(ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to estimate diversion: %w", err)
+	}
+	stats.Diversion = *diversion
+
+	investment, err := confirmedInvestment(ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to total conversion costs: %w", err)
+	}
+	stats.Investment = *investment
+
+	return stats, nil
+}
+
+// confirmedInvestment totals the estimated conversion cost ranges of
+// confirmed matches by currency
+func confirmedInvestment(ownerID string) (*InvestmentStats, error) {
+	rows, err := currentDB().Query(`
+		SELECT m.cost_currency, COALESCE(SUM(m.cost_low), 0), COALESCE(SUM(m.cost_high), 0), COUNT(*)
+		FROM match_recommendations m
+		JOIN industry_profiles p ON p.id = m.producer_id
+		WHERE ($1 = '' OR p.owner_id = $1) AND m.status = 'confirmed'
+		GROUP BY m.cost_currency
+		ORDER BY m.cost_currency
+	`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	investment := &InvestmentStats{ByCurrency: []CostTotal{}}
+	for rows.Next() {
+		var currency sql.NullString
+		var total CostTotal
+		if err := rows.Scan(&currency, &total.Low, &total.High, &total.Matches); err != nil {
+			return nil, err
+		}
+		if !currency.Valid {
+			investment.UnpricedMatches = total.Matches
 			continue
 		}
-		matches = append(matches, &match)
+		total.Currency = currency.String
+		investment.ByCurrency = append(investment.ByCurrency, total)
 	}
 
-	return matches, nil
+	return investment, rows.Err()
 }
 
-// UpdateMatchConfirmation updates the confirmation status of a match
-func UpdateMatchConfirmation(matchID string) error {
-	now := time.Now()
-	query := `UPDATE match_recommendations SET confirmed = TRUE, confirmed_at = $1 WHERE id = $2`
-	_, err := db.Exec(query, now, matchID)
+// confirmedDiversion sums the daily quantities of waste streams that have at
+// least one confirmed match, counting each stream once however many
+// consumers it is matched with
+func confirmedDiversion(ownerID string) (*DiversionStats, error) {
+	rows, err := currentDB().Query(`
+		SELECT p.outputs, array_agg(DISTINCT m.waste_id)
+		FROM match_recommendations m
+		JOIN industry_profiles p ON p.id = m.producer_id
+		WHERE ($1 = '' OR p.owner_id = $1) AND m.status = 'confirmed'
+		GROUP BY p.id, p.outputs
+	`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	diversion := &DiversionStats{PerDay: make(map[string]float64)}
+	for rows.Next() {
+		var outputsJSON []byte
+		var wasteIDs pq.StringArray
+		if err := rows.Scan(&outputsJSON, &wasteIDs); err != nil {
+			return nil, err
+		}
+
+		var outputs []Output
+		json.Unmarshal(outputsJSON, &outputs)
+		parseOutputQuantities(outputs)
+
+		for _, wasteID := range wasteIDs {
+			diversion.WasteStreams++
+			i := slices.IndexFunc(outputs, func(o Output) bool { return o.Name == wasteID })
+			if i < 0 || outputs[i].Amount == nil {
+				diversion.UnquantifiedStreams++
+				continue
+			}
+			rate, dimension, ok := outputs[i].Amount.dailyRate()
+			if !ok {
+				diversion.UnquantifiedStreams++
+				continue
+			}
+			diversion.PerDay[baseUnits[dimension]] += rate
+		}
+	}
+
+	return diversion, rows.Err()
+}
+
+// GetCachedClassification retrieves a waste classification no older than maxAge.
+// It returns sql.ErrNoRows when there is no fresh cached entry.
+func GetCachedClassification(wasteName, state string, maxAge time.Duration) (*WasteClassification, error) {
+	query := `
+		SELECT waste_type, tags, potential_uses FROM waste_classifications
+		WHERE waste_name = $1 AND state = $2 AND classified_at > $3
+	`
+
+	var classification WasteClassification
+	var wasteType sql.NullString
+	var tagsJSON, usesJSON []byte
+
+	err := currentDB().QueryRow(query, wasteName, state, time.Now().Add(-maxAge)).Scan(&wasteType, &tagsJSON, &usesJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	classification.WasteType = wasteType.String
+	json.Unmarshal(tagsJSON, &classification.Tags)
+	json.Unmarshal(usesJSON, &classification.PotentialUses)
+
+	return &classification, nil
+}
+
+// SaveClassification caches a waste classification
+func SaveClassification(wasteName, state string, classification *WasteClassification) error {
+	tagsJSON, _ := json.Marshal(classification.Tags)
+	usesJSON, _ := json.Marshal(classification.PotentialUses)
+
+	query := `
+		INSERT INTO waste_classifications (waste_name, state, waste_type, tags, potential_uses, classified_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (waste_name, state) DO UPDATE SET
+			waste_type = $3, tags = $4, potential_uses = $5, classified_at = $6
+	`
+
+	_, err := currentDB().Exec(query, wasteName, state, classification.WasteType, tagsJSON, usesJSON, time.Now())
 	return err
 }
 
-// SaveTask saves a task
+// SaveTask saves a task and publishes the update to its subscribers
 func SaveTask(task *Task) error {
+	if err := saveTask(currentDB(), task); err != nil {
+		return err
+	}
+	taskEvents.Publish(task)
+	return nil
+}
+
+func saveTask(e execer, task *Task) error {
+	if task == nil {
+		return fmt.Errorf("cannot save nil task")
+	}
+
 	resultJSON, _ := json.Marshal(task.Result)
+	fileURLsJSON, _ := json.Marshal(task.FileURLs)
 
 	query := `
-		INSERT INTO tasks (id, status, type, file_url, profile_id, error, result, created_at, completed_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO tasks (id, status, type, file_url, profile_id, error, result, created_at, completed_at, file_urls, owner_id, callback_url, language)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 		ON CONFLICT (id) DO UPDATE SET
-			status = $2, error = $6, result = $7, completed_at = $9
+			status = $2, profile_id = $5, error = $6, result = $7, completed_at = $9
 	`
 
-	_, err := db.Exec(query, task.ID, task.Status, task.Type, task.FileURL, task.ProfileID,
-		task.Error, resultJSON, task.CreatedAt, task.CompletedAt)
+	_, err := e.Exec(query, task.ID, task.Status, task.Type, task.FileURL, nullString(task.ProfileID),
+		task.Error, resultJSON, task.CreatedAt, task.CompletedAt, fileURLsJSON, nullString(task.OwnerID), nullString(task.CallbackURL), nullString(task.Language))
 	return err
 }
 
-// GetTask retrieves a task by ID
-func GetTask(id string) (*Task, error) {
-	query := `SELECT id, status, type, file_url, profile_id, error, result, created_at, completed_at FROM tasks WHERE id = $1`
+// nullString maps an empty string to NULL, e.g. for optional foreign keys
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// taskColumns lists the tasks columns read by scanTask
+const taskColumns = `id, status, type, file_url, profile_id, error, result, created_at, completed_at, file_urls, owner_id, callback_url, language`
 
+// scanTask scans a row selected with taskColumns into a Task
+func scanTask(row rowScanner) (*Task, error) {
 	var task Task
-	var resultJSON []byte
-	var fileURL, profileID, errorMsg sql.NullString
+	var resultJSON, fileURLsJSON []byte
+	var fileURL, profileID, errorMsg, ownerID, callbackURL, language sql.NullString
 	var completedAt sql.NullTime
 
-	err := db.QueryRow(query, id).Scan(&task.ID, &task.Status, &task.Type, &fileURL, &profileID,
-		&errorMsg, &resultJSON, &task.CreatedAt, &completedAt)
+	err := row.Scan(&task.ID, &task.Status, &task.Type, &fileURL, &profileID,
+		&errorMsg, &resultJSON, &task.CreatedAt, &completedAt, &fileURLsJSON, &ownerID, &callbackURL, &language)
 	if err != nil {
 		return nil, err
 	}
@@ -249,12 +1518,240 @@ func GetTask(id string) (*Task, error) {
 	if errorMsg.Valid {
 		task.Error = errorMsg.String
 	}
+	task.OwnerID = ownerID.String
+	task.CallbackURL = callbackURL.String
+	task.Language = language.String
 	if completedAt.Valid {
 		task.CompletedAt = &completedAt.Time
 	}
 	if len(resultJSON) > 0 {
 		json.Unmarshal(resultJSON, &task.Result)
 	}
+	if len(fileURLsJSON) > 0 {
+		json.Unmarshal(fileURLsJSON, &task.FileURLs)
+	}
 
 	return &task, nil
 }
+
+// GetTask retrieves a task by ID
+func GetTask(id string) (*Task, error) {
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE id = $1`
+	return scanTask(currentDB().QueryRow(query, id))
+}
+
+// FindUploadTask returns the newest document_parse task of ownerID for
+// exactly the files in fileURLs, in any order, unless it failed or its profile
+// has since been deleted. It returns sql.ErrNoRows if there is none.
+func FindUploadTask(ownerID string, fileURLs []string) (*Task, error) {
+	fileURLsJSON, _ := json.Marshal(fileURLs)
+
+	query := `
+		SELECT ` + taskColumns + ` FROM (
+			SELECT *, CASE WHEN jsonb_typeof(file_urls) = 'array' AND jsonb_array_length(file_urls) > 0
+				THEN file_urls ELSE jsonb_build_array(file_url) END AS files
+			FROM tasks
+			WHERE type = 'document_parse' AND status <> 'failed' AND owner_id IS NOT DISTINCT FROM $1
+		) t
+		WHERE files @> $2::jsonb AND files <@ $2::jsonb
+			AND NOT EXISTS (
+				SELECT 1 FROM industry_profiles p WHERE p.id = t.profile_id AND p.deleted_at IS NOT NULL
+			)
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	return scanTask(currentDB().QueryRow(query, nullString(ownerID), string(fileURLsJSON)))
+}
+
+// taskFilesReleasable holds for a document_parse task t, joined to its profile
+// p, whose files need not be kept: the task failed, or its profile was
+// deleted, before $1
+const taskFilesReleasable = `COALESCE((t.status = 'failed' AND COALESCE(t.completed_at, t.created_at) < $1) OR p.deleted_at < $1, FALSE)`
+
+// ListReleasableUploadTasks returns up to limit document_parse tasks, oldest
+// first, whose files can be released because the task failed, or its profile
+// was deleted, before releasedBefore. Tasks already released are skipped.
+func ListReleasableUploadTasks(releasedBefore time.Time, limit int) ([]*Task, error) {
+	query := `
+		SELECT ` + taskColumns + ` FROM tasks
+		WHERE id IN (
+			SELECT t.id FROM tasks t
+			LEFT JOIN industry_profiles p ON p.id = t.profile_id
+			WHERE t.type = 'document_parse' AND t.files_deleted_at IS NULL AND ` + taskFilesReleasable + `
+			ORDER BY t.created_at, t.id
+			LIMIT $2
+		)
+		ORDER BY created_at, id`
+
+	rows, err := currentDB().Query(query, releasedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []*Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+// UploadInUse reports whether a stored file belongs to any document_parse task
+// whose files can't be released (see ListReleasableUploadTasks), including a
+// released task that has since been retried. Uploads are stored by content,
+// so several tasks can share a file.
+func UploadInUse(fileURL string, releasedBefore time.Time) (bool, error) {
+	return uploadInUse(currentDB(), fileURL, releasedBefore)
+}
+
+func uploadInUse(e execer, fileURL string, releasedBefore time.Time) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM tasks t
+			LEFT JOIN industry_profiles p ON p.id = t.profile_id
+			WHERE t.type = 'document_parse' AND NOT ` + taskFilesReleasable + `
+				AND (t.file_url = $2 OR t.file_urls ? $2)
+		)`
+
+	var inUse bool
+	err := e.QueryRow(query, releasedBefore, fileURL).Scan(&inUse)
+	return inUse, err
+}
+
+// uploadLockClass is the first key of the Postgres advisory locks guarding
+// stored files; the second is the hash of the file's URL. Tasks claiming a
+// file hold the lock shared and the cleanup job holds it exclusively, so a
+// file can't be deleted between an upload or retry finding it stored and its
+// task being saved.
+const uploadLockClass = 72839
+
+// ErrUploadGone is returned by SaveUploadTask when one of the task's files is
+// no longer stored, e.g. because the cleanup job just deleted it
+var ErrUploadGone = errors.New("uploaded file is no longer available")
+
+// errUploadLocked is returned by DeleteUnusedUpload when a task is claiming
+// the file at that moment
+var errUploadLocked = errors.New("upload is being claimed by a task")
+
+// SaveUploadTask saves a document_parse task after checking that each of its
+// files is still stored, holding the files' upload locks so the cleanup job
+// can't delete one before the task is committed. It returns ErrUploadGone if
+// a file is missing, in which case nothing is saved.
+func SaveUploadTask(task *Task, fileURLs []string) error {
+	tx, err := currentDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, fileURL := range fileURLs {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock_shared($1, hashtext($2))`, uploadLockClass, fileURL); err != nil {
+			return fmt.Errorf("failed to lock upload: %w", err)
+		}
+		exists, err := FileExists(fileURL)
+		if err != nil {
+			return fmt.Errorf("failed to check upload: %w", err)
+		}
+		if !exists {
+			return ErrUploadGone
+		}
+	}
+
+	if err := saveTask(tx, task); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	taskEvents.Publish(task)
+	return nil
+}
+
+// DeleteUnusedUpload deletes a stored file unless UploadInUse, checking under
+// the file's upload lock so no task can claim it in between. It reports
+// whether the file is still in use, and returns errUploadLocked without
+// waiting if a task is claiming it at that moment.
+func DeleteUnusedUpload(fileURL string, releasedBefore time.Time) (inUse bool, err error) {
+	tx, err := currentDB().Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRow(`SELECT pg_try_advisory_xact_lock($1, hashtext($2))`, uploadLockClass, fileURL).Scan(&locked); err != nil {
+		return false, fmt.Errorf("failed to lock upload: %w", err)
+	}
+	if !locked {
+		return false, errUploadLocked
+	}
+
+	if inUse, err := uploadInUse(tx, fileURL, releasedBefore); err != nil || inUse {
+		return inUse, err
+	}
+	if err := DeleteFile(fileURL); err != nil {
+		return false, err
+	}
+	return false, tx.Commit()
+}
+
+// MarkTaskFilesDeleted records that the cleanup job has released a task's
+// files, so ListReleasableUploadTasks stops returning it
+func MarkTaskFilesDeleted(taskID string) error {
+	_, err := currentDB().Exec(`UPDATE tasks SET files_deleted_at = $2 WHERE id = $1`, taskID, time.Now())
+	return err
+}
+
+// ListTasks retrieves a page of tasks, newest first with ties ordered by ID,
+// optionally filtered by status, type, and owner, along with the total count
+// of matching tasks
+func ListTasks(status, taskType, ownerID string, limit, offset int) ([]*Task, int, error) {
+	var conditions []string
+	var args []interface{}
+	if ownerID != "" {
+		args = append(args, ownerID)
+		conditions = append(conditions, fmt.Sprintf("owner_id = $%d", len(args)))
+	}
+	if status != "" {
+		args = append(args, status)
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if taskType != "" {
+		args = append(args, taskType)
+		conditions = append(conditions, fmt.Sprintf("type = $%d", len(args)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := currentDB().QueryRow(`SELECT COUNT(*) FROM tasks `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM tasks %s ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d`,
+		taskColumns, where, len(args)+1, len(args)+2)
+
+	rows, err := currentDB().Query(query, append(args, sqlLimit(limit), offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var tasks []*Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+
+	return tasks, total, nil
+}
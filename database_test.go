@@ -0,0 +1,333 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// openTestDB connects to the database in TEST_DATABASE_URL with an empty
+// schema, skipping the test when it isn't set. The database is wiped, so
+// point it at a scratch database, e.g.
+//
+//	TEST_DATABASE_URL="host=localhost user=postgres password=postgres dbname=symbiosis_test sslmode=disable" go test ./...
+func openTestDB(t *testing.T) {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	t.Setenv("DATABASE_URL", url)
+	if err := OpenDB(); err != nil {
+		t.Fatalf("failed to connect to TEST_DATABASE_URL: %v", err)
+	}
+	t.Cleanup(func() { CloseDB() })
+
+	if _, err := currentDB().Exec(`DROP SCHEMA public CASCADE; CREATE SCHEMA public`); err != nil {
+		t.Fatalf("failed to reset the test database: %v", err)
+	}
+}
+
+// TestSaveMatchResultsIdempotent runs match generation's save twice over the
+// same producer, candidate, and waste streams, as a retry or re-match does,
+// and checks that each combination still has one row whose review status and
+// creation time survived
+func TestSaveMatchResultsIdempotent(t *testing.T) {
+	openTestDB(t)
+	if err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	producer := NewIndustryProfile("Acme Steel", Location{Lat: 51.5, Lng: -0.1}, nil,
+		[]Output{{Name: "steel slag", State: "solid"}, {Name: "mill scale", State: "solid"}})
+	candidate := NewIndustryProfile("Cement Works", Location{Lat: 51.6, Lng: -0.2},
+		[]Input{{Name: "steel slag"}, {Name: "mill scale"}}, nil)
+	for _, profile := range []*IndustryProfile{producer, candidate} {
+		if err := SaveProfile(profile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each run builds fresh matches; random IDs stand in for rows saved
+	// before IDs were derived from the combination
+	run := func(score float64) []*MatchRecommendation {
+		t.Helper()
+		var matches []*MatchRecommendation
+		for _, waste := range producer.Outputs {
+			match := NewMatchRecommendation(waste.Name, producer.ID, candidate.ID)
+			match.ID = uuid.New().String()
+			match.Score = score
+			match.Reasoning = fmt.Sprintf("scored %.1f", score)
+			matches = append(matches, match)
+		}
+		if err := SaveMatchResults(nil, matches, nil, time.Time{}); err != nil {
+			t.Fatalf("SaveMatchResults: %v", err)
+		}
+		return matches
+	}
+
+	first := run(0.6)
+	if err := UpdateMatchConfirmation(first[0].ID, "reviewer"); err != nil {
+		t.Fatal(err)
+	}
+	second := run(0.9)
+
+	var rows int
+	if err := currentDB().QueryRow(`SELECT COUNT(*) FROM match_recommendations`).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != len(producer.Outputs) {
+		t.Fatalf("%d match rows after two runs, want %d", rows, len(producer.Outputs))
+	}
+
+	for i, match := range second {
+		if match.ID != first[i].ID {
+			t.Errorf("%s: second run got ID %s, want the existing %s", match.WasteID, match.ID, first[i].ID)
+		}
+		if !match.CreatedAt.Equal(first[i].CreatedAt) {
+			t.Errorf("%s: created_at changed from %v to %v", match.WasteID, first[i].CreatedAt, match.CreatedAt)
+		}
+
+		stored, err := GetMatch(match.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Score != 0.9 || stored.Reasoning != "scored 0.9" {
+			t.Errorf("%s: score %v, reasoning %q; want the second run's", match.WasteID, stored.Score, stored.Reasoning)
+		}
+		wantStatus := MatchStatusPending
+		if i == 0 {
+			wantStatus = MatchStatusConfirmed
+		}
+		if stored.Status != wantStatus {
+			t.Errorf("%s: status %q, want %q", match.WasteID, stored.Status, wantStatus)
+		}
+	}
+}
+
+// TestSaveMatchReplacesLLMResponse checks that re-saving a match without a
+// model response drops the one stored by an earlier run
+func TestSaveMatchReplacesLLMResponse(t *testing.T) {
+	openTestDB(t)
+	if err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	producer := NewIndustryProfile("Acme Steel", Location{}, nil, []Output{{Name: "steel slag"}})
+	candidate := NewIndustryProfile("Cement Works", Location{}, []Input{{Name: "steel slag"}}, nil)
+	for _, profile := range []*IndustryProfile{producer, candidate} {
+		if err := SaveProfile(profile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	match := NewMatchRecommendation("steel slag", producer.ID, candidate.ID)
+	match.llmResponse = &LLMResponse{Response: `{"explanation":"good fit"}`}
+	if err := SaveMatch(match); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := GetMatchLLMResponse(match.ID); err != nil || r.Response != `{"explanation":"good fit"}` {
+		t.Fatalf("GetMatchLLMResponse = %+v, %v; want the saved response", r, err)
+	}
+
+	rerun := NewMatchRecommendation("steel slag", producer.ID, candidate.ID)
+	if err := SaveMatch(rerun); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := GetMatchLLMResponse(match.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetMatchLLMResponse after a re-save without one = %+v, %v; want sql.ErrNoRows", r, err)
+	}
+}
+
+// TestRekeyMatchIDs gives a match with a random ID its derived one, moving
+// its stored response and audit entries along, and checks that rolling back
+// the match_id_rekeys migration restores the random ID
+func TestRekeyMatchIDs(t *testing.T) {
+	openTestDB(t)
+	if err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	producer := NewIndustryProfile("Acme Steel", Location{}, nil, []Output{{Name: "steel slag"}})
+	candidate := NewIndustryProfile("Cement Works", Location{}, []Input{{Name: "steel slag"}}, nil)
+	for _, profile := range []*IndustryProfile{producer, candidate} {
+		if err := SaveProfile(profile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	randomMatchIDs = true
+	t.Cleanup(func() { randomMatchIDs = false })
+	match := NewMatchRecommendation("steel slag", producer.ID, candidate.ID)
+	match.llmResponse = &LLMResponse{Response: `{"explanation":"good fit"}`}
+	if err := SaveMatch(match); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := currentDB().Exec(`INSERT INTO match_audit (match_id, action, actor, created_at) VALUES ($1, 'confirm', 'tester', NOW())`, match.ID); err != nil {
+		t.Fatal(err)
+	}
+	randomMatchIDs = false
+
+	if rekeyed, err := RekeyMatchIDs(); err != nil || rekeyed != 1 {
+		t.Fatalf("RekeyMatchIDs = %d, %v; want 1 match re-keyed", rekeyed, err)
+	}
+	if rekeyed, err := RekeyMatchIDs(); err != nil || rekeyed != 0 {
+		t.Errorf("second RekeyMatchIDs = %d, %v; want nothing left to re-key", rekeyed, err)
+	}
+
+	id := MatchID(producer.ID, candidate.ID, "steel slag")
+	if _, err := GetMatch(id); err != nil {
+		t.Errorf("GetMatch(derived ID) = %v; want the re-keyed match", err)
+	}
+	if r, err := GetMatchLLMResponse(id); err != nil || r.Response != `{"explanation":"good fit"}` {
+		t.Errorf("GetMatchLLMResponse(derived ID) = %+v, %v; want the response moved along", r, err)
+	}
+	var audits int
+	if err := currentDB().QueryRow(`SELECT COUNT(*) FROM match_audit WHERE match_id = $1`, id).Scan(&audits); err != nil || audits != 1 {
+		t.Errorf("audit entries under the derived ID = %d, %v; want 1", audits, err)
+	}
+
+	if err := MigrateDown(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetMatch(match.ID); err != nil {
+		t.Errorf("GetMatch(random ID) after rolling back = %v; want the old ID restored", err)
+	}
+	if r, err := GetMatchLLMResponse(match.ID); err != nil || r.Response != `{"explanation":"good fit"}` {
+		t.Errorf("GetMatchLLMResponse(random ID) after rolling back = %+v, %v; want the response restored", r, err)
+	}
+}
+
+// TestFlagDistantMatches flags a match made before a distance cap, and clears
+// the flag once the cap is lifted
+func TestFlagDistantMatches(t *testing.T) {
+	openTestDB(t)
+	if err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	london := NewIndustryProfile("Acme Steel", Location{Lat: 51.5074, Lng: -0.1278}, nil, []Output{{Name: "steel slag"}})
+	paris := NewIndustryProfile("Cement Works", Location{Lat: 48.8566, Lng: 2.3522}, []Input{{Name: "steel slag"}}, nil)
+	for _, profile := range []*IndustryProfile{london, paris} {
+		if err := SaveProfile(profile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	match := NewMatchRecommendation("steel slag", london.ID, paris.ID)
+	if err := SaveMatch(match); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range []struct {
+		maxKm   float64
+		flagged int
+		beyond  bool
+	}{
+		{100, 1, true},
+		{100, 1, true}, // nothing changes on a second pass
+		{500, 0, false},
+		{100, 1, true},
+		{0, 0, false},
+	} {
+		flagged, err := FlagDistantMatches("", step.maxKm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, err := GetMatch(match.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flagged != step.flagged || stored.BeyondMaxDistance != step.beyond {
+			t.Errorf("cap %.0f km: flagged %d, beyond_max_distance %v; want %d, %v",
+				step.maxKm, flagged, stored.BeyondMaxDistance, step.flagged, step.beyond)
+		}
+	}
+}
+
+// TestDeleteUnusedUpload checks that the cleanup job leaves a file alone while
+// a task is claiming it or still uses it, and that a task can't be saved for
+// a file the job has deleted
+func TestDeleteUnusedUpload(t *testing.T) {
+	openTestDB(t)
+	if err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	fileURL := filepath.Join(t.TempDir(), "slag-report.pdf")
+	if err := os.WriteFile(fileURL, []byte("%PDF-1.4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	failedAt := now.Add(-48 * time.Hour)
+	releasedBefore := now.Add(-24 * time.Hour)
+	failed := func() *Task {
+		task := NewTask("document_parse")
+		task.Status, task.FileURL, task.CreatedAt, task.CompletedAt = "failed", fileURL, failedAt, &failedAt
+		return task
+	}
+	fileExists := func() bool {
+		_, err := os.Stat(fileURL)
+		return err == nil
+	}
+
+	if err := SaveTask(failed()); err != nil {
+		t.Fatal(err)
+	}
+
+	// An upload or retry holding the file's lock
+	tx, err := currentDB().Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock_shared($1, hashtext($2))`, uploadLockClass, fileURL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DeleteUnusedUpload(fileURL, releasedBefore); !errors.Is(err, errUploadLocked) {
+		t.Errorf("DeleteUnusedUpload while locked: error = %v, want errUploadLocked", err)
+	}
+	tx.Rollback()
+	if !fileExists() {
+		t.Fatal("file deleted while a task was claiming it")
+	}
+
+	// A new task sharing the file
+	retried := failed()
+	retried.Status, retried.CompletedAt = "pending", nil
+	if err := SaveUploadTask(retried, []string{fileURL}); err != nil {
+		t.Fatal(err)
+	}
+	if inUse, err := DeleteUnusedUpload(fileURL, releasedBefore); err != nil || !inUse {
+		t.Errorf("DeleteUnusedUpload with a pending task = %v, %v; want in use", inUse, err)
+	}
+	if !fileExists() {
+		t.Fatal("file deleted while a pending task used it")
+	}
+
+	// Once that task fails too, the file goes
+	retried.Status, retried.CompletedAt = "failed", &failedAt
+	if err := SaveTask(retried); err != nil {
+		t.Fatal(err)
+	}
+	if inUse, err := DeleteUnusedUpload(fileURL, releasedBefore); err != nil || inUse {
+		t.Fatalf("DeleteUnusedUpload = %v, %v; want deleted", inUse, err)
+	}
+	if fileExists() {
+		t.Fatal("unused file was not deleted")
+	}
+
+	late := NewTask("document_parse")
+	late.FileURL = fileURL
+	if err := SaveUploadTask(late, []string{fileURL}); !errors.Is(err, ErrUploadGone) {
+		t.Errorf("SaveUploadTask for a deleted file: error = %v, want ErrUploadGone", err)
+	}
+	if _, err := GetTask(late.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetTask after ErrUploadGone: error = %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportFlushRows is how many CSV rows are buffered before flushing to the client
+const exportFlushRows = 100
+
+var profileCSVHeader = []string{
+	"id", "name", "lat", "lng", "owner_id", "input_count", "inputs", "output_count", "outputs", "created_at", "updated_at",
+}
+
+var matchCSVHeader = []string{
+	"id", "waste_id", "producer_id", "producer_name", "candidate_id", "candidate_name", "status", "score",
+	"conversion_needed", "conversion_description", "recommended_converter", "estimated_cost",
+	"cost_low", "cost_high", "cost_currency", "reasoning", "consumer_reasoning",
+	"converter_ids", "created_at", "confirmed_at",
+}
+
+// ExportProfilesCSV streams the caller's profiles as CSV, with inputs and
+// outputs flattened into "; "-separated columns
+func ExportProfilesCSV(c *gin.Context) {
+	streamCSV(c, "profiles.csv", profileCSVHeader, func(write func([]string) error) error {
+		return StreamProfiles(callerPrincipal(c).ownerScope(), func(p *IndustryProfile) error {
+			inputs := make([]string, len(p.Inputs))
+			for i, input := range p.Inputs {
+				inputs[i] = input.String()
+			}
+			outputs := make([]string, len(p.Outputs))
+			for i, output := range p.Outputs {
+				outputs[i] = output.String()
+			}
+
+			return write([]string{
+				p.ID,
+				p.Name,
+				strconv.FormatFloat(p.Location.Lat, 'f', -1, 64),
+				strconv.FormatFloat(p.Location.Lng, 'f', -1, 64),
+				p.OwnerID,
+				strconv.Itoa(len(p.Inputs)),
+				strings.Join(inputs, "; "),
+				strconv.Itoa(len(p.Outputs)),
+				strings.Join(outputs, "; "),
+				p.CreatedAt.Format(time.RFC3339),
+				p.UpdatedAt.Format(time.RFC3339),
+			})
+		})
+	})
+}
+
+// ExportMatchesCSV streams the matches involving the caller's profiles as CSV
+func ExportMatchesCSV(c *gin.Context) {
+	streamCSV(c, "matches.csv", matchCSVHeader, func(write func([]string) error) error {
+		return StreamMatches(callerPrincipal(c).ownerScope(), func(m *MatchDetail) error {
+			confirmedAt := ""
+			if m.ConfirmedAt != nil {
+				confirmedAt = m.ConfirmedAt.Format(time.RFC3339)
+			}
+			var costLow, costHigh, costCurrency string
+			if r := m.EstimatedCostRange; r != nil {
+				costLow = strconv.FormatFloat(r.Low, 'f', -1, 64)
+				costHigh = strconv.FormatFloat(r.High, 'f', -1, 64)
+				costCurrency = r.Currency
+			}
+
+			return write([]string{
+				m.ID,
+				m.WasteID,
+				m.ProducerID,
+				m.ProducerName,
+				m.CandidateID,
+				m.CandidateName,
+				m.Status,
+				strconv.FormatFloat(m.Score, 'f', 4, 64),
+				strconv.FormatBool(m.ConversionNeeded),
+				m.ConversionDescription,
+				m.RecommendedConverter,
+				m.EstimatedCost,
+				costLow,
+				costHigh,
+				costCurrency,
+				m.Reasoning,
+				m.ConsumerReasoning,
+				strings.Join(m.ConverterIDs, "; "),
+				m.CreatedAt.Format(time.RFC3339),
+				confirmedAt,
+			})
+		})
+	})
+}
+
+// streamCSV writes a CSV attachment, calling produce to write the rows. Rows
+// are flushed as they go, so once the first is sent an error can only be
+// logged and the download ends early.
+func streamCSV(c *gin.Context, filename string, header []string, produce func(write func([]string) error) error) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	rows := 0
+	write := func(record []string) error {
+		for i, field := range record {
+			record[i] = csvSafe(field)
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushRows == 0 {
+			w.Flush()
+			c.Writer.Flush()
+		}
+		return w.Error()
+	}
+
+	err := w.Write(header)
+	if err == nil {
+		err = produce(write)
+	}
+	w.Flush()
+	if err == nil {
+		err = w.Error()
+	}
+	if err != nil {
+		requestLogger(c).Error("CSV export failed", "file", filename, "rows", rows, "error", err)
+	}
+}
+
+// csvSafe stops spreadsheets from evaluating a field as a formula by
+// prefixing fields that start with a formula character with a quote.
+// Numbers such as negative coordinates are left alone.
+func csvSafe(field string) string {
+	if field == "" || !strings.ContainsRune("=+-@\t\r", rune(field[0])) {
+		return field
+	}
+	if _, err := strconv.ParseFloat(field, 64); err == nil {
+		return field
+	}
+	return "'" + field
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// GeminiProvider calls Google's Gemini generateContent API
+type GeminiProvider struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+// NewGeminiProvider creates a Gemini provider sending requests through
+// httpClient, so tests can substitute a client or point baseURL at a fake
+// server
+func NewGeminiProvider(apiKey string, httpClient *http.Client) *GeminiProvider {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	return &GeminiProvider{
+		apiKey:  apiKey,
+		baseURL: "https://generativelanguage.googleapis.com/v1beta",
+		client:  httpClient,
+	}
+}
+
+func (g *GeminiProvider) Name() string { return "gemini" }
+
+func (g *GeminiProvider) DefaultModel() string { return "gemini-1.5-flash" }
+
+// Generate makes a single API call to Gemini
+func (g *GeminiProvider) Generate(ctx context.Context, model, prompt string, schema map[string]interface{}, params GenerationParams) (string, error) {
+	endpoint := fmt.Sprintf("%s/models/%s:generateContent?key=%s", g.baseURL, model, g.apiKey)
+
+	generationConfig := map[string]interface{}{
+		"temperature":     params.Temperature,
+		"topP":            params.TopP,
+		"maxOutputTokens": params.MaxOutputTokens,
+	}
+	if params.TopK > 0 {
+		generationConfig["topK"] = params.TopK
+	}
+	if schema != nil {
+		generationConfig["responseMimeType"] = "application/json"
+		generationConfig["responseSchema"] = schema
+	}
+
+	requestBody := map[string]interface{}{
+		"contents": []map[string]interface{}{
+			{
+				"parts": []map[string]string{
+					{"text": prompt},
+				},
+			},
+		},
+		"generationConfig": generationConfig,
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call Gemini API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", &LLMAPIError{
+			Provider:   "Gemini",
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var response struct {
+		Candidates []struct {
+			Content struct {
+				Parts []struct {
+					Text *string `json:"text"`
+				} `json:"parts"`
+			} `json:"content"`
+			FinishReason string `json:"finishReason"`
+		} `json:"candidates"`
+		PromptFeedback struct {
+			BlockReason string `json:"blockReason"`
+		} `json:"promptFeedback"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	// A blocked prompt comes back with a block reason and no candidates
+	if reason := response.PromptFeedback.BlockReason; reason != "" {
+		return "", fmt.Errorf("%w: prompt blocked (%s)", ErrContentBlocked, reason)
+	}
+	if len(response.Candidates) == 0 {
+		return "", fmt.Errorf("%w: no candidates returned", ErrContentBlocked)
+	}
+
+	// Extract text from Gemini response structure
+	candidate := response.Candidates[0]
+	if parts := candidate.Content.Parts; len(parts) > 0 && parts[0].Text != nil {
+		return *parts[0].Text, nil
+	}
+
+	// A candidate without content stopped for a reason other than finishing,
+	// such as SAFETY or RECITATION
+	if reason := candidate.FinishReason; reason != "" && reason != "STOP" {
+		return "", fmt.Errorf("%w: finish reason %s", ErrContentBlocked, reason)
+	}
+
+	return "", fmt.Errorf("unexpected response format from Gemini API")
+}
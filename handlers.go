@@ -1,67 +1,261 @@
 package main
 
 import (
+	"bytes"
+	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
-// HandleUpload handles file upload and initiates processing
+// HandleUpload handles file upload and initiates processing. Several documents
+// describing one company can be sent together under the "files" key.
 func HandleUpload(c *gin.Context) {
-	file, err := c.FormFile("file")
+	// Cap the whole request body, leaving headroom for multipart headers
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadBytes+(1<<20))
+
+	form, err := c.MultipartForm()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondError(c, http.StatusRequestEntityTooLarge, uploadTooLargeMessage())
+			return
+		}
+		respondError(c, http.StatusBadRequest, "No file uploaded")
 		return
 	}
 
-	// Validate file type
-	ext := filepath.Ext(file.Filename)
-	if ext != ".pdf" && ext != ".docx" && ext != ".txt" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported file type. Use PDF, DOCX, or TXT"})
+	fileHeaders := form.File["files"]
+	if len(fileHeaders) == 0 {
+		fileHeaders = form.File["file"]
+	}
+	if len(fileHeaders) == 0 {
+		respondError(c, http.StatusBadRequest, "No file uploaded")
 		return
 	}
 
-	// Open file
-	src, err := file.Open()
+	// Optional URL to notify when processing finishes
+	callbackURL := strings.TrimSpace(c.PostForm("callback_url"))
+	if callbackURL != "" {
+		if err := validateCallbackURL(c.Request.Context(), callbackURL); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// Language to extract names and write match reasoning in
+	lang, err := uploadLanguage(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		respondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
-	defer src.Close()
 
-	// Generate unique filename
-	filename := fmt.Sprintf("%s%s", uuid.New().String(), ext)
+	// Validate file types and sizes
+	for _, file := range fileHeaders {
+		if file.Size > maxUploadBytes {
+			respondError(c, http.StatusRequestEntityTooLarge, uploadTooLargeMessage())
+			return
+		}
+
+		ext := filepath.Ext(file.Filename)
+		if ext != ".pdf" && ext != ".docx" && ext != ".txt" {
+			respondError(c, http.StatusBadRequest, "Unsupported file type. Use PDF, DOCX, or TXT")
+			return
+		}
+
+		if err := validateFileContent(file, ext); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 
 	// Upload to storage
-	fileURL, err := UploadFile(src, filename, file.Header.Get("Content-Type"), file.Size)
-	if err != nil {
-		log.Printf("Failed to upload file: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
+	var uploads []UploadedFile
+	for _, file := range fileHeaders {
+		upload, err := saveUploadedFile(file)
+		if errors.Is(err, ErrFileTooLarge) {
+			respondError(c, http.StatusRequestEntityTooLarge, uploadTooLargeMessage())
+			return
+		}
+		if err != nil {
+			requestLogger(c).Error("Failed to upload file", "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to upload file")
+			return
+		}
+		uploads = append(uploads, upload)
+	}
+
+	// The same documents uploaded again: point at the task that already
+	// processed them rather than parsing them twice
+	if task := existingUploadTask(c, uploads); task != nil {
+		requestLogger(c).Info("Duplicate upload, reusing task", "task_id", task.ID)
+		presignTaskFiles(requestLogger(c), task)
+		response := gin.H{
+			"task_id":   task.ID,
+			"file_url":  task.FileURL,
+			"status":    task.Status,
+			"duplicate": true,
+		}
+		if len(task.FileURLs) > 0 {
+			response["file_urls"] = task.FileURLs
+		}
+		if task.ProfileID != "" {
+			response["profile_id"] = task.ProfileID
+		}
+		respondData(c, http.StatusOK, response)
 		return
 	}
 
-	// Create task
+	// Create task, owned by the caller so the resulting profile is too
 	task := NewTask("document_parse")
-	task.FileURL = fileURL
+	task.OwnerID = callerPrincipal(c).ID
+	task.CallbackURL = callbackURL
+	task.Language = lang
+	task.FileURL = uploads[0].URL
+	if len(uploads) > 1 {
+		for _, upload := range uploads {
+			task.FileURLs = append(task.FileURLs, upload.URL)
+		}
+	}
 
-	if err := SaveTask(task); err != nil {
-		log.Printf("Failed to save task: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create task"})
+	// An identical earlier upload may be deleted by the cleanup job while
+	// this one is in flight; if so, store the files again
+	err = SaveUploadTask(task, uploadURLs(uploads))
+	if errors.Is(err, ErrUploadGone) {
+		requestLogger(c).Info("Stored upload was cleaned up meanwhile, storing it again", "task_id", task.ID)
+		for i, file := range fileHeaders {
+			if uploads[i], err = saveUploadedFile(file); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = SaveUploadTask(task, uploadURLs(uploads))
+		}
+	}
+	if err != nil {
+		requestLogger(c).Error("Failed to save task", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to create task")
 		return
 	}
 
 	// Process asynchronously
-	go ProcessDocument(task.ID, fileURL, filename)
+	ctx := asyncContext(c)
+	workerPool.Submit(func() { ProcessDocument(ctx, task.ID, uploads) })
+
+	// Expose signed download URLs rather than the storage paths
+	downloadURLs := make([]string, len(uploads))
+	for i, upload := range uploads {
+		downloadURLs[i], err = GeneratePresignedURL(upload.URL)
+		if err != nil {
+			requestLogger(c).Error("Failed to generate file URL", "error", err)
+		}
+	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"task_id":  task.ID,
-		"file_url": fileURL,
+		"file_url": downloadURLs[0],
 		"status":   "pending",
-	})
+	}
+	if len(uploads) > 1 {
+		response["file_urls"] = downloadURLs
+	}
+
+	respondData(c, http.StatusOK, response)
+}
+
+// validateFileContent sniffs the start of a file and checks it matches the claimed extension
+func validateFileContent(file *multipart.FileHeader, ext string) error {
+	src, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to read %s", file.Filename)
+	}
+	defer src.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return fmt.Errorf("failed to read %s", file.Filename)
+	}
+	head = head[:n]
+
+	contentType := http.DetectContentType(head)
+	valid := false
+	switch ext {
+	case ".pdf":
+		valid = contentType == "application/pdf"
+	case ".docx":
+		// DOCX is a ZIP container
+		valid = bytes.HasPrefix(head, []byte("PK\x03\x04"))
+	case ".txt":
+		valid = strings.HasPrefix(contentType, "text/")
+	}
+
+	if !valid {
+		return fmt.Errorf("file content of %s does not match its %s extension", file.Filename, ext)
+	}
+	return nil
+}
+
+// uploadTooLargeMessage describes the upload size limit
+func uploadTooLargeMessage() string {
+	return fmt.Sprintf("File too large. Maximum upload size is %d bytes", maxUploadBytes)
+}
+
+// saveUploadedFile stores one multipart file under the hash of its content
+func saveUploadedFile(file *multipart.FileHeader) (UploadedFile, error) {
+	src, err := file.Open()
+	if err != nil {
+		return UploadedFile{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+
+	fileURL, existed, err := UploadFile(src, filepath.Ext(file.Filename), file.Header.Get("Content-Type"), file.Size)
+	if err != nil {
+		return UploadedFile{}, err
+	}
+
+	return UploadedFile{URL: fileURL, Filename: path.Base(fileURL), Existed: existed}, nil
+}
+
+// uploadURLs returns the stored URLs of uploaded files
+func uploadURLs(uploads []UploadedFile) []string {
+	fileURLs := make([]string, len(uploads))
+	for i, upload := range uploads {
+		fileURLs[i] = upload.URL
+	}
+	return fileURLs
+}
+
+// existingUploadTask returns the caller's task that already processed these
+// exact files, or nil if any file is new or there is no such task
+func existingUploadTask(c *gin.Context, uploads []UploadedFile) *Task {
+	fileURLs := make([]string, len(uploads))
+	for i, upload := range uploads {
+		if !upload.Existed {
+			return nil
+		}
+		fileURLs[i] = upload.URL
+	}
+
+	task, err := FindUploadTask(callerPrincipal(c).ID, fileURLs)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			requestLogger(c).Error("Failed to look up task for duplicate upload", "error", err)
+		}
+		return nil
+	}
+	return task
 }
 
 // GetTaskStatus returns the status of a task
@@ -69,72 +263,1089 @@ func GetTaskStatus(c *gin.Context) {
 	taskID := c.Param("task_id")
 
 	task, err := GetTask(taskID)
+	if err != nil || !callerPrincipal(c).owns(task.OwnerID) {
+		respondError(c, http.StatusNotFound, "Task not found")
+		return
+	}
+
+	presignTaskFiles(requestLogger(c), task)
+
+	respondData(c, http.StatusOK, task)
+}
+
+// taskEventHeartbeat is how often an idle task event stream sends a comment
+// line, keeping proxies from closing the connection
+const taskEventHeartbeat = 15 * time.Second
+
+// StreamTaskEvents streams a task's status as Server-Sent Events: its current
+// state, then each update, until it completes or fails
+func StreamTaskEvents(c *gin.Context) {
+	taskID := c.Param("task_id")
+
+	// Subscribe before loading the task so no update in between is missed
+	updates, unsubscribe := taskEvents.Subscribe(taskID)
+	defer unsubscribe()
+
+	task, err := GetTask(taskID)
+	if err != nil || !callerPrincipal(c).owns(task.OwnerID) {
+		respondError(c, http.StatusNotFound, "Task not found")
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	// Streams outlive WRITE_TIMEOUT by design; the heartbeat detects dead clients
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		requestLogger(c).Warn("Failed to lift write deadline for task event stream", "error", err)
+	}
+
+	heartbeat := time.NewTicker(taskEventHeartbeat)
+	defer heartbeat.Stop()
+
+	if sendTaskEvent(c, task) {
+		return
+	}
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				// Server shutting down
+				return
+			}
+			if sendTaskEvent(c, update) {
+				return
+			}
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": keepalive\n\n")
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// sendTaskEvent writes a task as a "status" event, reporting whether the task has finished
+func sendTaskEvent(c *gin.Context, task *Task) (finished bool) {
+	presignTaskFiles(requestLogger(c), task)
+	c.SSEvent("status", task)
+	c.Writer.Flush()
+	return task.Status == "completed" || task.Status == "failed"
+}
+
+// RetryTask re-runs document processing for a failed document_parse task
+func RetryTask(c *gin.Context) {
+	taskID := c.Param("task_id")
+
+	task, err := GetTask(taskID)
+	if err != nil || !callerPrincipal(c).owns(task.OwnerID) {
+		respondError(c, http.StatusNotFound, "Task not found")
+		return
+	}
+
+	if task.Type != "document_parse" {
+		respondError(c, http.StatusBadRequest, "Only document_parse tasks can be retried")
+		return
+	}
+	if task.Status != "failed" {
+		respondError(c, http.StatusConflict, "Only failed tasks can be retried")
+		return
+	}
+
+	fileURLs := task.FileURLs
+	if len(fileURLs) == 0 {
+		fileURLs = []string{task.FileURL}
+	}
+
+	var files []UploadedFile
+	for _, fileURL := range fileURLs {
+		if fileURL == "" {
+			respondError(c, http.StatusGone, "Uploaded file is no longer available; please re-upload")
+			return
+		}
+		files = append(files, UploadedFile{URL: fileURL, Filename: filepath.Base(fileURL)})
+	}
+
+	// Saved only once the files are confirmed stored, under their upload
+	// locks, so the cleanup job can't delete them as the task is revived
+	task.Status = "pending"
+	task.Error = ""
+	task.Result = nil
+	task.CompletedAt = nil
+	err = SaveUploadTask(task, fileURLs)
+	if errors.Is(err, ErrUploadGone) {
+		respondError(c, http.StatusGone, "Uploaded file is no longer available; please re-upload")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("Failed to save task", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to retry task")
+		return
+	}
+
+	// Process asynchronously
+	ctx := asyncContext(c)
+	workerPool.Submit(func() { ProcessDocument(ctx, task.ID, files) })
+
+	respondData(c, http.StatusOK, gin.H{
+		"task_id": task.ID,
+		"status":  "pending",
+	})
+}
+
+// presignTaskFiles replaces a task's storage paths with signed download URLs
+func presignTaskFiles(logger *slog.Logger, task *Task) {
+	if task.FileURL != "" {
+		downloadURL, err := GeneratePresignedURL(task.FileURL)
+		if err != nil {
+			logger.Error("Failed to generate file URL", "error", err)
+		}
+		task.FileURL = downloadURL
+	}
+	for i, fileURL := range task.FileURLs {
+		downloadURL, err := GeneratePresignedURL(fileURL)
+		if err != nil {
+			logger.Error("Failed to generate file URL", "error", err)
+		}
+		task.FileURLs[i] = downloadURL
+	}
+}
+
+// ListTasksHandler lists tasks, optionally filtered by status and type
+func ListTasksHandler(c *gin.Context) {
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tasks, total, err := ListTasks(c.Query("status"), c.Query("type"), callerPrincipal(c).ownerScope(), limit, offset)
+	if err != nil {
+		requestLogger(c).Error("Failed to list tasks", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to retrieve tasks")
+		return
+	}
+
+	for _, task := range tasks {
+		presignTaskFiles(requestLogger(c), task)
+	}
+
+	respondList(c, tasks, gin.H{
+		"count":  len(tasks),
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// ServeFile streams an uploaded file after validating its signed URL
+func ServeFile(c *gin.Context) {
+	filename := c.Param("filename")
+	if filename != filepath.Base(filename) {
+		respondError(c, http.StatusBadRequest, "Invalid filename")
+		return
+	}
+
+	if !ValidateFileToken(filename, c.Query("token"), c.Query("expires")) {
+		respondError(c, http.StatusForbidden, "Invalid or expired file token")
+		return
+	}
+
+	file, err := GetFile(LocalFilePath(filename))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+		respondError(c, http.StatusNotFound, "File not found")
 		return
 	}
+	defer file.Close()
 
-	c.JSON(http.StatusOK, task)
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	c.DataFromReader(http.StatusOK, -1, contentType, file, nil)
 }
 
-// GetProfileHandler returns an industry profile
+// GetProfileHandler returns an industry profile, or 304 if it hasn't
+// changed since the ETag the client sent. Admins may pass include_deleted=true
+// to see a soft-deleted profile.
 func GetProfileHandler(c *gin.Context) {
+	includeDeleted, ok := includeDeletedParam(c)
+	if !ok {
+		return
+	}
+
+	profile, ok := loadOwnedProfile(c, c.Param("profile_id"), includeDeleted)
+	if !ok {
+		return
+	}
+
+	jsonWithETag(c, APIResponse{Data: profile})
+}
+
+// ownedProfile loads a profile the caller owns, writing an error response and
+// returning false otherwise. Other owners' profiles are reported as not found
+// so their IDs aren't confirmed to exist, as are soft-deleted ones.
+func ownedProfile(c *gin.Context, profileID string) (*IndustryProfile, bool) {
+	return loadOwnedProfile(c, profileID, false)
+}
+
+// loadOwnedProfile is ownedProfile, optionally finding soft-deleted profiles
+func loadOwnedProfile(c *gin.Context, profileID string, includeDeleted bool) (*IndustryProfile, bool) {
+	profile, err := GetProfile(profileID, includeDeleted)
+	if err == sql.ErrNoRows || (err == nil && !callerPrincipal(c).owns(profile.OwnerID)) {
+		respondError(c, http.StatusNotFound, "Profile not found")
+		return nil, false
+	}
+	if err != nil {
+		requestLogger(c).Error("Failed to get profile", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to retrieve profile")
+		return nil, false
+	}
+	return profile, true
+}
+
+// SearchProfilesHandler runs a full-text search over profiles
+func SearchProfilesHandler(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		respondError(c, http.StatusBadRequest, "Query parameter q is required")
+		return
+	}
+
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	profiles, err := SearchProfiles(q, callerPrincipal(c).ownerScope(), limit, offset)
+	if err != nil {
+		requestLogger(c).Error("Failed to search profiles", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to search profiles")
+		return
+	}
+
+	respondList(c, profiles, gin.H{
+		"query":  q,
+		"count":  len(profiles),
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// NearbyProfilesHandler lists profiles within a radius of a point or of another profile
+func NearbyProfilesHandler(c *gin.Context) {
+	var center Location
+	excludeID := c.Query("profile_id")
+
+	if excludeID != "" {
+		profile, ok := ownedProfile(c, excludeID)
+		if !ok {
+			return
+		}
+		center = profile.Location
+	} else {
+		lat, latErr := strconv.ParseFloat(c.Query("lat"), 64)
+		lng, lngErr := strconv.ParseFloat(c.Query("lng"), 64)
+		if latErr != nil || lngErr != nil {
+			respondError(c, http.StatusBadRequest, "lat and lng (or profile_id) are required")
+			return
+		}
+		center = Location{Lat: lat, Lng: lng}
+		if err := center.Validate(); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	maxRadius := float64(getEnvInt("NEARBY_MAX_RADIUS_KM", 500))
+	radius := 50.0
+	if v := c.Query("radius_km"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r <= 0 {
+			respondError(c, http.StatusBadRequest, "radius_km must be a positive number")
+			return
+		}
+		radius = r
+	}
+	if radius > maxRadius {
+		radius = maxRadius
+	}
+
+	nearby, err := findNearbyProfiles(center, radius, callerPrincipal(c).ownerScope())
+	if err != nil {
+		requestLogger(c).Error("Failed to find nearby profiles", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to find nearby profiles")
+		return
+	}
+
+	results := make([]*NearbyProfile, 0, len(nearby))
+	for _, p := range nearby {
+		if p.ID != excludeID {
+			results = append(results, p)
+		}
+	}
+
+	respondList(c, results, gin.H{
+		"center":    center,
+		"radius_km": radius,
+		"count":     len(results),
+	})
+}
+
+// UpdateProfileHandler replaces a profile's details and re-runs matching. Like
+// the single input and output edits, it takes an optional version to check.
+func UpdateProfileHandler(c *gin.Context) {
+	var req ProfileRequest
+	if !bindStrictJSON(c, &req) {
+		return
+	}
+	var lang string
+	if req.Language != "" {
+		var err error
+		if lang, err = parseLanguage(req.Language); err != nil {
+			respondFieldErrors(c, []FieldError{{Field: "language", Message: err.Error()}})
+			return
+		}
+	}
+
+	profile, ok := editableProfile(c)
+	if !ok {
+		return
+	}
+
+	moved := profile.Location != req.Location.Location()
+	profile.Name = req.Name
+	profile.Location = req.Location.Location()
+	profile.Inputs = req.inputs()
+	profile.Outputs = req.outputs()
+	if lang != "" {
+		profile.Language = lang
+	}
+	parseProfileQuantities(profile)
+	if unknown := normalizeProfileStates(profile); len(unknown) > 0 {
+		requestLogger(c).Warn("Stored unrecognised material states as other", "states", unknown)
+	}
+	canonicalizeProfile(c.Request.Context(), profile)
+	profile.UpdatedAt = time.Now()
+
+	err := SaveProfile(profile)
+	if err == ErrVersionConflict {
+		respondErrorCode(c, http.StatusConflict, ErrCodeVersionConflict, "Profile was modified concurrently; reload it and retry")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("Failed to save profile", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to update profile")
+		return
+	}
+
+	// Matching skips far-off candidates from now on; flag the existing
+	// matches the move put out of range
+	if moved {
+		if _, err := FlagDistantMatches(profile.ID, matchMaxDistanceKm); err != nil {
+			requestLogger(c).Error("Failed to flag distant matches", "error", err)
+		}
+	}
+
+	// Outputs may have changed, so regenerate matches
+	if _, err := QueueMatchGeneration(asyncContext(c), profile.ID, profile.OwnerID); err != nil {
+		requestLogger(c).Error("Failed to queue match generation", "error", err)
+	}
+
+	respondData(c, http.StatusOK, profile)
+}
+
+// DeleteProfileHandler soft-deletes a profile and removes its pending matches
+func DeleteProfileHandler(c *gin.Context) {
 	profileID := c.Param("profile_id")
+	if _, ok := ownedProfile(c, profileID); !ok {
+		return
+	}
 
-	profile, err := GetProfile(profileID)
+	err := DeleteProfile(profileID)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, "Profile not found")
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Profile not found"})
+		requestLogger(c).Error("Failed to delete profile", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to delete profile")
 		return
 	}
 
-	c.JSON(http.StatusOK, profile)
+	c.Status(http.StatusNoContent)
 }
 
-// GetMatches returns all matches for a profile
+// GetMatches returns the matches for a profile's waste streams, or 304 if the
+// page hasn't changed since the ETag the client sent
 func GetMatches(c *gin.Context) {
+	respondProfileMatches(c, GetMatchesByProfile)
+}
+
+// GetIncomingMatches returns the matches offering other profiles' waste to a
+// profile, i.e. those where it is the candidate consumer, filtered and paged
+// like GetMatches
+func GetIncomingMatches(c *gin.Context) {
+	respondProfileMatches(c, GetIncomingMatchesByProfile)
+}
+
+// respondProfileMatches responds with a page of a profile's matches from list,
+// filtered by the status, min_score, and conversion_needed query parameters
+func respondProfileMatches(c *gin.Context, list func(profileID string, filter MatchFilter, limit, offset int) ([]*MatchRecommendation, int, error)) {
 	profileID := c.Param("profile_id")
+	if _, ok := ownedProfile(c, profileID); !ok {
+		return
+	}
 
-	matches, err := GetMatchesByProfile(profileID)
+	limit, offset, err := parsePagination(c)
 	if err != nil {
-		log.Printf("Failed to get matches: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve matches"})
+		respondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	filter := MatchFilter{Status: c.Query("status")}
+	switch filter.Status {
+	case "", MatchStatusPending, MatchStatusConfirmed, MatchStatusRejected:
+	default:
+		respondError(c, http.StatusBadRequest, "status must be pending, confirmed, or rejected")
+		return
+	}
+	if v := c.Query("min_score"); v != "" {
+		minScore, err := strconv.ParseFloat(v, 64)
+		if err != nil || minScore < 0 || minScore > 1 {
+			respondError(c, http.StatusBadRequest, "min_score must be a number between 0 and 1")
+			return
+		}
+		filter.MinScore = minScore
+	}
+	if v := c.Query("conversion_needed"); v != "" {
+		conversionNeeded, err := strconv.ParseBool(v)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "conversion_needed must be true or false")
+			return
+		}
+		filter.ConversionNeeded = &conversionNeeded
+	}
+
+	matches, total, err := list(profileID, filter, limit, offset)
+	if err != nil {
+		requestLogger(c).Error("Failed to get matches", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to retrieve matches")
+		return
+	}
+
+	jsonWithETag(c, APIResponse{Data: matches, Meta: gin.H{
 		"profile_id": profileID,
-		"matches":    matches,
+		"count":      len(matches),
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+	}})
+}
+
+// EvaluateMatchHandler scores a profile's waste streams against one named
+// candidate synchronously. The resulting matches are only saved when
+// persist=true, and then only actual matches scoring at least min_save_score
+// (default MIN_SAVE_SCORE), so the endpoint doubles as a what-if tool for
+// scoring.
+func EvaluateMatchHandler(c *gin.Context) {
+	producer, ok := ownedProfile(c, c.Param("profile_id"))
+	if !ok {
+		return
+	}
+
+	candidateID := c.Query("candidate")
+	if candidateID == "" {
+		respondError(c, http.StatusBadRequest, "Query parameter candidate is required")
+		return
+	}
+	if candidateID == producer.ID {
+		respondError(c, http.StatusBadRequest, "A profile cannot be matched with itself")
+		return
+	}
+	persist, err := strconv.ParseBool(c.DefaultQuery("persist", "false"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "persist must be true or false")
+		return
+	}
+	minScore := matchMinSaveScore
+	if v := c.Query("min_save_score"); v != "" {
+		minScore, err = strconv.ParseFloat(v, 64)
+		if err != nil || minScore < 0 || minScore > 1 {
+			respondError(c, http.StatusBadRequest, "min_save_score must be a number between 0 and 1")
+			return
+		}
+	}
+
+	// Candidates may belong to anyone, as in regular matching
+	candidate, err := GetProfile(candidateID, false)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, "Candidate profile not found")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("Failed to get profile", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to retrieve candidate profile")
+		return
+	}
+
+	if maxKm := matchMaxDistanceKm; beyondMatchDistance(producer.Location, candidate.Location, maxKm) {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Candidate is %.0f km away, beyond the %.0f km matching limit",
+			calculateDistance(producer.Location, candidate.Location), maxKm))
+		return
+	}
+
+	matches, err := EvaluatePair(c.Request.Context(), producer, candidate)
+	if err != nil {
+		requestLogger(c).Error("Failed to evaluate match", "candidate_id", candidateID, "error", err)
+		status := http.StatusBadGateway
+		if errors.Is(err, ErrCircuitOpen) {
+			status = http.StatusServiceUnavailable
+		} else if requestTimedOut(c) {
+			status = http.StatusRequestTimeout
+		}
+		respondError(c, status, "Failed to evaluate match")
+		return
+	}
+
+	// Every match is returned so below-threshold ones can be inspected, but
+	// only those meeting the threshold are saved
+	saved := 0
+	if persist {
+		toSave := filterByScore(c.Request.Context(), matches, minScore)
+		if len(toSave) > 0 {
+			if err := SaveMatchResults(nil, toSave, nil, time.Time{}); err != nil {
+				requestLogger(c).Error("Failed to save matches", "error", err)
+				respondError(c, http.StatusInternalServerError, "Failed to save matches")
+				return
+			}
+		}
+		saved = len(toSave)
+	}
+
+	respondList(c, matches, gin.H{
+		"profile_id":     producer.ID,
+		"candidate_id":   candidate.ID,
+		"persisted":      persist,
+		"min_save_score": minScore,
+		"saved":          saved,
+		"count":          len(matches),
+	})
+}
+
+// GetMatchHandler returns a single match recommendation. Admins also get the
+// raw model response it was built from, if DEBUG_STORE_LLM kept one.
+func GetMatchHandler(c *gin.Context) {
+	match, ok := accessibleMatch(c, c.Param("match_id"))
+	if !ok {
+		return
+	}
+
+	if callerPrincipal(c).Admin {
+		response, err := GetMatchLLMResponse(match.ID)
+		if err != nil && err != sql.ErrNoRows {
+			requestLogger(c).Error("Failed to get LLM response", "match_id", match.ID, "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to retrieve match")
+			return
+		}
+		match.LLMResponse = response
+	}
+
+	respondData(c, http.StatusOK, match)
+}
+
+// GetMatchHistoryHandler returns who confirmed, unconfirmed, or rejected a
+// match and when, oldest first
+func GetMatchHistoryHandler(c *gin.Context) {
+	matchID := c.Param("match_id")
+	if _, ok := accessibleMatch(c, matchID); !ok {
+		return
+	}
+
+	history, err := GetMatchHistory(matchID)
+	if err != nil {
+		requestLogger(c).Error("Failed to get match history", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to retrieve match history")
+		return
+	}
+
+	respondList(c, history, gin.H{
+		"match_id": matchID,
+		"count":    len(history),
 	})
 }
 
+// accessibleMatch loads a match the caller is a party to, as owner of either
+// the producer or the candidate profile, writing an error response and
+// returning false otherwise
+func accessibleMatch(c *gin.Context, matchID string) (*MatchDetail, bool) {
+	caller := callerPrincipal(c)
+	match, err := GetMatch(matchID)
+	if err == sql.ErrNoRows || (err == nil && !caller.owns(match.producerOwnerID) && !caller.owns(match.candidateOwnerID)) {
+		respondError(c, http.StatusNotFound, "Match not found")
+		return nil, false
+	}
+	if err != nil {
+		requestLogger(c).Error("Failed to get match", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to retrieve match")
+		return nil, false
+	}
+	return match, true
+}
+
 // ConfirmMatch confirms a match recommendation
 func ConfirmMatch(c *gin.Context) {
 	matchID := c.Param("match_id")
+	if _, ok := accessibleMatch(c, matchID); !ok {
+		return
+	}
 
-	if err := UpdateMatchConfirmation(matchID); err != nil {
-		log.Printf("Failed to confirm match: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm match"})
+	err := UpdateMatchConfirmation(matchID, callerPrincipal(c).ID)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, "Match not found")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("Failed to confirm match", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to confirm match")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	respondData(c, http.StatusOK, gin.H{
 		"match_id":  matchID,
 		"confirmed": true,
 		"message":   "Match confirmed successfully",
 	})
 }
 
-// ListProfiles returns all industry profiles
+// UnconfirmMatchHandler undoes a match confirmation, returning it to pending
+func UnconfirmMatchHandler(c *gin.Context) {
+	matchID := c.Param("match_id")
+	match, ok := accessibleMatch(c, matchID)
+	if !ok {
+		return
+	}
+	if match.Status != MatchStatusConfirmed {
+		respondError(c, http.StatusConflict, "Only confirmed matches can be unconfirmed")
+		return
+	}
+
+	err := UnconfirmMatch(matchID, callerPrincipal(c).ID)
+	if err == sql.ErrNoRows {
+		// Changed by another request since we loaded it
+		respondError(c, http.StatusConflict, "Only confirmed matches can be unconfirmed")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("Failed to unconfirm match", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to unconfirm match")
+		return
+	}
+
+	respondData(c, http.StatusOK, gin.H{
+		"match_id":  matchID,
+		"confirmed": false,
+		"status":    MatchStatusPending,
+		"message":   "Match confirmation undone",
+	})
+}
+
+// RejectMatchHandler rejects a match recommendation
+func RejectMatchHandler(c *gin.Context) {
+	matchID := c.Param("match_id")
+	if _, ok := accessibleMatch(c, matchID); !ok {
+		return
+	}
+
+	err := RejectMatch(matchID, callerPrincipal(c).ID)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, "Match not found")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("Failed to reject match", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to reject match")
+		return
+	}
+
+	respondData(c, http.StatusOK, gin.H{
+		"match_id": matchID,
+		"status":   MatchStatusRejected,
+		"message":  "Match rejected successfully",
+	})
+}
+
+// DeleteMatchHandler permanently removes a match, e.g. one with a
+// hallucinated candidate, where rejecting would keep the record
+func DeleteMatchHandler(c *gin.Context) {
+	matchID := c.Param("match_id")
+	if _, ok := accessibleMatch(c, matchID); !ok {
+		return
+	}
+
+	err := DeleteMatch(matchID, callerPrincipal(c).ID)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, "Match not found")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("Failed to delete match", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to delete match")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListProfiles returns the industry profiles the caller owns, or all of them
+// for admins, who may pass include_deleted=true to list soft-deleted ones too
 func ListProfiles(c *gin.Context) {
-	profiles, err := ListAllProfiles()
+	limit, offset, err := parsePagination(c)
 	if err != nil {
-		log.Printf("Failed to list profiles: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve profiles"})
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	includeDeleted, ok := includeDeletedParam(c)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"count":    len(profiles),
-		"profiles": profiles,
+	profiles, total, err := ListAllProfiles(callerPrincipal(c).ownerScope(), includeDeleted, limit, offset)
+	if err != nil {
+		requestLogger(c).Error("Failed to list profiles", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to retrieve profiles")
+		return
+	}
+
+	respondList(c, profiles, gin.H{
+		"count":  len(profiles),
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
 	})
 }
+
+// ListReviewQueue lists the caller's profiles flagged for review, oldest
+// first, with signed links to the documents they were extracted from
+func ListReviewQueue(c *gin.Context) {
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	profiles, total, err := ListProfilesForReview(callerPrincipal(c).ownerScope(), limit, offset)
+	if err != nil {
+		requestLogger(c).Error("Failed to list profiles for review", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to retrieve review queue")
+		return
+	}
+
+	for _, profile := range profiles {
+		downloadURLs := make([]string, len(profile.DocumentURLs))
+		for i, documentURL := range profile.DocumentURLs {
+			downloadURLs[i], err = GeneratePresignedURL(documentURL)
+			if err != nil {
+				requestLogger(c).Error("Failed to generate file URL", "error", err)
+			}
+		}
+		profile.DocumentURLs = downloadURLs
+	}
+
+	respondList(c, profiles, gin.H{
+		"count":  len(profiles),
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// ApproveProfileHandler clears a profile's review flag and queues match
+// generation, which was held back while it awaited review
+func ApproveProfileHandler(c *gin.Context) {
+	profileID := c.Param("profile_id")
+	profile, ok := ownedProfile(c, profileID)
+	if !ok {
+		return
+	}
+
+	err := ApproveProfile(profileID)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusConflict, "Profile is not awaiting review")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("Failed to approve profile", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to approve profile")
+		return
+	}
+	profile.NeedsReview = false
+
+	response := gin.H{"profile": profile}
+	task, err := QueueMatchGeneration(asyncContext(c), profile.ID, profile.OwnerID)
+	if err != nil {
+		requestLogger(c).Error("Failed to queue match generation", "error", err)
+	} else {
+		response["match_task_id"] = task.ID
+	}
+
+	respondData(c, http.StatusOK, response)
+}
+
+// ListMaterialsHandler lists the input materials and output waste streams
+// named across the caller's profiles, with counts, for autocomplete and
+// filters. q restricts both lists to names starting with it.
+func ListMaterialsHandler(c *gin.Context) {
+	limit, _, err := parsePagination(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	prefix := c.Query("q")
+	ownerID := callerPrincipal(c).ownerScope()
+
+	inputs, err := ListMaterials(ownerID, false, prefix, limit)
+	if err != nil {
+		requestLogger(c).Error("Failed to list input materials", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to retrieve materials")
+		return
+	}
+	outputs, err := ListMaterials(ownerID, true, prefix, limit)
+	if err != nil {
+		requestLogger(c).Error("Failed to list output materials", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to retrieve materials")
+		return
+	}
+
+	respondList(c, gin.H{"inputs": inputs, "outputs": outputs}, gin.H{
+		"q":     prefix,
+		"limit": limit,
+	})
+}
+
+// ListConvertersHandler lists the converter registry, optionally filtered by waste_type
+func ListConvertersHandler(c *gin.Context) {
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	converters, err := ListConverters(c.Query("waste_type"), limit, offset)
+	if err != nil {
+		requestLogger(c).Error("Failed to list converters", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to retrieve converters")
+		return
+	}
+
+	respondList(c, converters, gin.H{
+		"count":  len(converters),
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// CreateConverterHandler adds a converter to the registry. The registry is
+// shared by every owner, so only admins may change it.
+func CreateConverterHandler(c *gin.Context) {
+	if !callerPrincipal(c).Admin {
+		respondError(c, http.StatusForbidden, "Only admins can register converters")
+		return
+	}
+
+	var req ConverterRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	if req.Location != nil {
+		if err := req.Location.Validate(); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	converter := NewConverter(req)
+	if len(converter.WasteTypes) == 0 {
+		respondError(c, http.StatusBadRequest, "waste_types must contain at least one entry")
+		return
+	}
+	if err := SaveConverter(converter); err != nil {
+		requestLogger(c).Error("Failed to save converter", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to save converter")
+		return
+	}
+
+	respondData(c, http.StatusCreated, converter)
+}
+
+// RematchAllHandler queues match generation for every profile, e.g. after a
+// scoring or model change, and returns how many profiles were queued. With
+// clear=true pending matches are deleted first so stale ones don't linger;
+// confirmed and rejected matches are kept either way, and rerunning matching
+// rescores them in place. With incremental=true each profile is only matched
+// against the profiles added or updated since its last complete run. Admin only.
+func RematchAllHandler(c *gin.Context) {
+	if !callerPrincipal(c).Admin {
+		respondError(c, http.StatusForbidden, "Only admins can regenerate all matches")
+		return
+	}
+
+	clearPending, err := strconv.ParseBool(c.DefaultQuery("clear", "false"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "clear must be true or false")
+		return
+	}
+	incremental, err := strconv.ParseBool(c.DefaultQuery("incremental", "false"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "incremental must be true or false")
+		return
+	}
+	// Cleared matches would only come back from candidates that changed
+	if incremental && clearPending {
+		respondError(c, http.StatusBadRequest, "clear can't be combined with incremental")
+		return
+	}
+
+	profiles, _, err := ListAllProfiles("", false, 0, 0)
+	if err != nil {
+		requestLogger(c).Error("Failed to list profiles", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to list profiles")
+		return
+	}
+
+	var cleared int64
+	if clearPending {
+		cleared, err = DeletePendingMatches()
+		if err != nil {
+			requestLogger(c).Error("Failed to clear pending matches", "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to clear pending matches")
+			return
+		}
+	}
+
+	// Each run goes through the worker pool like any other match generation,
+	// so a large rematch queues up rather than flooding Gemini
+	ctx := asyncContext(c)
+	taskIDs := make([]string, 0, len(profiles))
+	for _, profile := range profiles {
+		scope := matchScope{}
+		if incremental {
+			scope = incrementalScope(profile)
+		}
+		task, err := queueScopedMatchGeneration(ctx, profile.ID, profile.OwnerID, scope)
+		if err != nil {
+			requestLogger(c).Error("Failed to queue match generation", "profile_id", profile.ID, "error", err)
+			continue
+		}
+		taskIDs = append(taskIDs, task.ID)
+	}
+
+	requestLogger(c).Info("Queued rematch of all profiles", "profiles", len(profiles), "queued", len(taskIDs), "cleared", cleared, "incremental", incremental)
+	respondData(c, http.StatusAccepted, gin.H{
+		"profiles":        len(profiles),
+		"profiles_queued": len(taskIDs),
+		"matches_cleared": cleared,
+		"task_ids":        taskIDs,
+	})
+}
+
+// topWasteTypesInStats is how many waste types GetStats breaks matches down by
+const topWasteTypesInStats = 10
+
+// GetStats returns aggregate profile and match statistics for the dashboard,
+// covering the caller's own profiles, or everything for admins
+func GetStats(c *gin.Context) {
+	stats, err := GetSymbiosisStats(callerPrincipal(c).ownerScope(), topWasteTypesInStats)
+	if err != nil {
+		requestLogger(c).Error("Failed to compute stats", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to retrieve stats")
+		return
+	}
+
+	respondData(c, http.StatusOK, stats)
+}
+
+// GetGraphHandler returns the symbiosis network as nodes and edges for a
+// force-directed graph: the caller's profiles and those they're matched with,
+// or everything for admins. min_score drops weaker edges, and confirmed=true
+// keeps only confirmed ones.
+func GetGraphHandler(c *gin.Context) {
+	var filter MatchFilter
+	if v := c.Query("min_score"); v != "" {
+		minScore, err := strconv.ParseFloat(v, 64)
+		if err != nil || minScore < 0 || minScore > 1 {
+			respondError(c, http.StatusBadRequest, "min_score must be a number between 0 and 1")
+			return
+		}
+		filter.MinScore = minScore
+	}
+	confirmedOnly, err := strconv.ParseBool(c.DefaultQuery("confirmed", "false"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "confirmed must be true or false")
+		return
+	}
+	if confirmedOnly {
+		filter.Status = MatchStatusConfirmed
+	}
+
+	graph, err := GetSymbiosisGraph(callerPrincipal(c).ownerScope(), filter)
+	if err != nil {
+		requestLogger(c).Error("Failed to build symbiosis graph", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to retrieve graph")
+		return
+	}
+
+	respondData(c, http.StatusOK, graph)
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// parsePagination reads limit/offset query parameters, applying defaults and caps
+func parsePagination(c *gin.Context) (int, int, error) {
+	limit := defaultPageLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("limit must be a positive integer")
+		}
+		limit = n
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+
+	offset := 0
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+
+	return limit, offset, nil
+}
+
+// includeDeletedParam reads the include_deleted query parameter, which only
+// admins may set. It writes an error response and returns false if the value
+// is invalid or the caller isn't an admin.
+func includeDeletedParam(c *gin.Context) (bool, bool) {
+	includeDeleted, err := strconv.ParseBool(c.DefaultQuery("include_deleted", "false"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "include_deleted must be true or false")
+		return false, false
+	}
+	if includeDeleted && !callerPrincipal(c).Admin {
+		respondError(c, http.StatusForbidden, "Only admins can include deleted profiles")
+		return false, false
+	}
+	return includeDeleted, true
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthCheckTimeout bounds each dependency check in the readiness probe
+const healthCheckTimeout = 2 * time.Second
+
+// LivenessHandler only confirms the process is up and serving requests
+func LivenessHandler(c *gin.Context) {
+	respondData(c, http.StatusOK, gin.H{"status": "alive"})
+}
+
+// ReadinessHandler checks the database, the LLM client, and, when
+// HEALTH_CHECK_PYTHON_WORKER is set, the Python worker. It returns 503 if any
+// of them fails, so a load balancer takes the instance out of rotation. An
+// open circuit breaker reports "degraded" but keeps the instance ready, since
+// the breaker recovers on its own.
+func ReadinessHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+	defer cancel()
+
+	checks := gin.H{}
+	ready := true
+	record := func(name string, err error) {
+		if err != nil {
+			// The probe is unauthenticated, so the reason stays in the logs
+			requestLogger(c).Warn("Readiness check failed", "check", name, "error", err)
+			checks[name] = gin.H{"status": "down"}
+			ready = false
+			return
+		}
+		checks[name] = gin.H{"status": "up"}
+	}
+
+	record("database", pingDB(ctx))
+	// Heuristic matching doesn't need the LLM, so it runs fine without one
+	if mcpClient != nil || !heuristicMatching() {
+		record(llmName(), checkMCPClient())
+	}
+	if getEnvBool("HEALTH_CHECK_PYTHON_WORKER", false) {
+		record("python_worker", checkPythonWorker(ctx))
+	}
+
+	breakers := gin.H{
+		"python_worker": pythonWorkerBreaker.State(),
+	}
+	if mcpClient != nil {
+		breakers[llmName()] = mcpClient.breaker.State()
+	}
+
+	status, code := "healthy", http.StatusOK
+	for _, state := range breakers {
+		if state != breakerClosed {
+			status = "degraded"
+		}
+	}
+	if !ready {
+		status, code = "unhealthy", http.StatusServiceUnavailable
+	}
+
+	details := gin.H{"status": status, "checks": checks, "circuit_breakers": breakers}
+	if !ready {
+		// An error response carries no data; the checks go in meta
+		c.JSON(code, APIResponse{Error: &APIError{Code: ErrCodeUnavailable, Message: "A dependency is down"}, Meta: details})
+		return
+	}
+	c.JSON(code, APIResponse{Data: details})
+}
+
+// pingDB checks the database connection, failing if InitDB hasn't succeeded
+func pingDB(ctx context.Context) error {
+	if err := checkDB(); err != nil {
+		return err
+	}
+	return currentDB().PingContext(ctx)
+}
+
+// checkMCPClient reports whether the LLM client is configured
+func checkMCPClient() error {
+	if mcpClient == nil || mcpClient.provider == nil {
+		return errNoLLMClient
+	}
+	return nil
+}
+
+// llmName names the configured LLM provider in health responses
+func llmName() string {
+	if mcpClient == nil || mcpClient.provider == nil {
+		return "llm"
+	}
+	return mcpClient.provider.Name()
+}
+
+// RequireInitialized answers 503 until the database and storage are
+// initialized, rather than letting a handler dereference a nil connection
+func RequireInitialized() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := checkDB()
+		if err == nil {
+			err = checkStorage()
+		}
+		if err != nil {
+			requestLogger(c).Warn("Request before initialization finished", "error", err)
+			abortWithError(c, http.StatusServiceUnavailable, "Service is not ready: "+err.Error())
+			return
+		}
+		c.Next()
+	}
+}
+
+// checkPythonWorker calls the Python worker's own health endpoint
+func checkPythonWorker(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pythonWorkerURL+"/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := pythonWorkerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Python worker health returned status %d", resp.StatusCode)
+	}
+	return nil
+}
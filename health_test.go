@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestReadinessHandlerUnready checks that with no database the probe fails
+// with an error, and the checks go in meta rather than data
+func TestReadinessHandlerUnready(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	if currentDB() != nil {
+		t.Skip("a database is connected")
+	}
+	if pythonWorkerBreaker == nil {
+		pythonWorkerBreaker = NewCircuitBreaker("python_worker", 5, time.Minute, isPythonWorkerFailure)
+		t.Cleanup(func() { pythonWorkerBreaker = nil })
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/health/ready", nil)
+	ReadinessHandler(c)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	var resp struct {
+		Data  json.RawMessage `json:"data"`
+		Error *APIError       `json:"error"`
+		Meta  struct {
+			Status string                       `json:"status"`
+			Checks map[string]map[string]string `json:"checks"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data != nil {
+		t.Errorf("data = %s, want none alongside the error", resp.Data)
+	}
+	if resp.Error == nil || resp.Error.Code != ErrCodeUnavailable {
+		t.Errorf("error = %+v, want code %s", resp.Error, ErrCodeUnavailable)
+	}
+	if resp.Meta.Status != "unhealthy" || resp.Meta.Checks["database"]["status"] != "down" {
+		t.Errorf("meta = %+v, want unhealthy with the database down", resp.Meta)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// heuristicMatching reports whether MATCH_MODE=heuristic, in which matches
+// are found by comparing material names instead of asking the LLM, so
+// matching works offline and without an API key
+func heuristicMatching() bool {
+	return strings.EqualFold(strings.TrimSpace(os.Getenv("MATCH_MODE")), "heuristic")
+}
+
+// matchWasteStreamHeuristic is matchWasteStream without the LLM: a candidate
+// matches when one of its inputs accepts the waste's state and shares a word
+// with the waste's name. It never fails.
+func matchWasteStreamHeuristic(ctx context.Context, producer *IndustryProfile, output Output, candidates []*IndustryProfile) ([]*MatchRecommendation, error) {
+	logger := loggerFromContext(ctx).With("waste", output.Name, "producer", producer.Name)
+	logger.Info("Processing waste stream heuristically")
+
+	var matches []*MatchRecommendation
+	for _, candidate := range candidates {
+		match, ok := heuristicMatch(producer, candidate, output)
+		if !ok {
+			continue
+		}
+		matches = append(matches, match)
+		logger.Info("Found match", "match_id", match.ID, "candidate", candidate.Name, "score", match.Score)
+	}
+	return matches, nil
+}
+
+// evaluatePairHeuristic is EvaluatePair without the LLM. Every waste stream
+// is scored, matching or not, so a pair can be compared on demand; the
+// non-matches are marked so filterByScore never lets them be saved.
+func evaluatePairHeuristic(producer, candidate *IndustryProfile) []*MatchRecommendation {
+	matches := make([]*MatchRecommendation, 0, len(producer.Outputs))
+	for _, output := range producer.Outputs {
+		match, ok := heuristicMatch(producer, candidate, output)
+		match.noMatch = !ok
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// heuristicMatch scores a waste stream against the candidate input whose name
+// is most similar among those accepting the waste's state. It reports whether
+// that input shares any word with the waste; if not, the score is 0.
+// Otherwise it comes from name similarity and distance only.
+func heuristicMatch(producer, candidate *IndustryProfile, output Output) (*MatchRecommendation, bool) {
+	var best *Input
+	similarity := 0.0
+	for i := range candidate.Inputs {
+		input := &candidate.Inputs[i]
+		if !inputAcceptsState(input, output.State) {
+			continue
+		}
+		if s := nameSimilarity(output.canonicalName(), input.canonicalName()); best == nil || s > similarity {
+			best, similarity = input, s
+		}
+	}
+
+	score := 0.3 + 0.5*similarity
+	distance := "at an unknown distance"
+	if !producer.Location.IsUnknown() && !candidate.Location.IsUnknown() {
+		km := calculateDistance(producer.Location, candidate.Location)
+		if km < 100 {
+			score += 0.2
+		} else if km < 500 {
+			score += 0.1
+		}
+		distance = fmt.Sprintf("%.0f km apart", km)
+	}
+	score = min(score, 1.0)
+
+	match := NewMatchRecommendation(output.Name, producer.ID, candidate.ID)
+	match.wasteKey = output.canonicalName()
+	match.RecommendedConverter = ConverterProducer
+	match.EstimatedCost = "Unknown"
+
+	if best == nil || similarity == 0 {
+		match.ConversionNeeded = true
+		match.Reasoning = fmt.Sprintf("No heuristic match: %s has no %s input named like %s; the sites are %s.",
+			candidate.Name, defaultString(output.State, "compatible"), output.Name, distance)
+		return match, false
+	}
+
+	match.Score = score
+	match.ConversionNeeded = output.canonicalName() != best.canonicalName()
+	if match.ConversionNeeded {
+		match.ConversionDescription = fmt.Sprintf("Not assessed: %s may need processing before use as %s", output.Name, best.Name)
+	}
+	match.Reasoning = fmt.Sprintf("Heuristic match: %s from %s and the %s input of %s have names %.0f%% alike; the sites are %s.",
+		output.Name, producer.Name, best.Name, candidate.Name, similarity*100, distance)
+	match.ConsumerReasoning = fmt.Sprintf("Heuristic match: %s from %s could supply the %s input of %s; the names are %.0f%% alike and the sites are %s.",
+		output.Name, producer.Name, best.Name, candidate.Name, similarity*100, distance)
+	return match, true
+}
+
+// inputAcceptsState reports whether an input takes material in the given
+// state. Inputs, or waste, without a state are compatible with anything, as
+// is waste in an other state.
+func inputAcceptsState(input *Input, state string) bool {
+	if state == "" || state == StateOther || len(input.States) == 0 {
+		return true
+	}
+	for _, s := range input.States {
+		if strings.EqualFold(s, state) {
+			return true
+		}
+	}
+	return false
+}
+
+// nameSimilarity returns the share of distinct words two material names have
+// in common, from 0 for none to 1 for the same words. Words of two letters
+// or fewer are ignored, as in matchingInput.
+func nameSimilarity(a, b string) float64 {
+	wordsA, wordsB := nameWords(a), nameWords(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for word := range wordsA {
+		if wordsB[word] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(wordsA)+len(wordsB)-shared)
+}
+
+// nameWords splits a name into its distinct lowercase words
+func nameWords(name string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) > 2 {
+			words[word] = true
+		}
+	}
+	return words
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// Languages are BCP 47 tags such as "en" or "pt-BR". A profile's language is
+// the one its extracted names and its side of match reasoning are written
+// in; it comes from the uploader and defaults to DEFAULT_LANGUAGE.
+
+// fallbackLanguage is used when DEFAULT_LANGUAGE is unset or invalid
+const fallbackLanguage = "en"
+
+// defaultLanguage is the language of profiles that weren't given one, from
+// DEFAULT_LANGUAGE
+func defaultLanguage() string {
+	raw := strings.TrimSpace(os.Getenv("DEFAULT_LANGUAGE"))
+	if raw == "" {
+		return fallbackLanguage
+	}
+	tag, err := language.Parse(raw)
+	if err != nil {
+		return fallbackLanguage
+	}
+	return tag.String()
+}
+
+// parseLanguage canonicalizes a requested language, e.g. "pt_br" becomes
+// "pt-BR"; an empty one is the default language
+func parseLanguage(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return defaultLanguage(), nil
+	}
+	tag, err := language.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("unsupported language %q: use a BCP 47 tag such as en or pt-BR", raw)
+	}
+	return tag.String(), nil
+}
+
+// uploadLanguage is the language an upload asks for: its "lang" form field,
+// else the caller's preferred language from Accept-Language, else the default
+func uploadLanguage(c *gin.Context) (string, error) {
+	if raw := c.PostForm("lang"); strings.TrimSpace(raw) != "" {
+		return parseLanguage(raw)
+	}
+	tags, _, err := language.ParseAcceptLanguage(c.GetHeader("Accept-Language"))
+	if err == nil && len(tags) > 0 && tags[0] != language.Und {
+		return tags[0].String(), nil
+	}
+	return defaultLanguage(), nil
+}
+
+// languageName names a language in English for prompts, e.g. "Brazilian
+// Portuguese" for "pt-BR", falling back to the tag itself
+func languageName(tag string) string {
+	if tag == "" {
+		tag = defaultLanguage()
+	}
+	parsed, err := language.Parse(tag)
+	if err != nil {
+		return tag
+	}
+	if name := display.English.Tags().Name(parsed); name != "" {
+		return name
+	}
+	return tag
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newHTTPServer builds the server for handler on addr, with limits so slow or
+// idle clients can't hold connections open indefinitely: READ_HEADER_TIMEOUT
+// and READ_TIMEOUT to send the request, WRITE_TIMEOUT from the end of the
+// headers to the end of the response, IDLE_TIMEOUT between keep-alive
+// requests, and MAX_HEADER_BYTES of request headers. A timeout of 0 disables
+// it. Task event streams lift the write timeout for themselves.
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: getEnvDuration("READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       getEnvDuration("READ_TIMEOUT", 2*time.Minute),
+		WriteTimeout:      getEnvDuration("WRITE_TIMEOUT", 3*time.Minute),
+		IdleTimeout:       getEnvDuration("IDLE_TIMEOUT", 2*time.Minute),
+		MaxHeaderBytes:    getEnvInt("MAX_HEADER_BYTES", 64<<10),
+	}
+
+	// The write timeout runs while an upload is still being read, so it must
+	// leave room for the slowest allowed upload and the handler after it
+	requestTimeout := getEnvDuration("REQUEST_TIMEOUT", 60*time.Second)
+	if srv.WriteTimeout > 0 && (srv.ReadTimeout <= 0 || requestTimeout <= 0 || srv.WriteTimeout < srv.ReadTimeout+requestTimeout) {
+		slog.Warn("WRITE_TIMEOUT is shorter than READ_TIMEOUT plus REQUEST_TIMEOUT; slow uploads may be cut off before their response",
+			"write_timeout", srv.WriteTimeout.String(),
+			"read_timeout", srv.ReadTimeout.String(),
+			"request_timeout", requestTimeout.String(),
+		)
+	}
+	return srv
+}
+
+// maxBodyBytes caps non-upload request bodies, from MAX_BODY_BYTES
+var maxBodyBytes int64
+
+// BodySizeMiddleware rejects request bodies over MAX_BODY_BYTES with 413.
+// Multipart uploads are left to HandleUpload, which allows MAX_UPLOAD_BYTES.
+// Bodies without a Content-Length are cut off at the limit, which
+// bindJSON reports as 413.
+func BodySizeMiddleware() gin.HandlerFunc {
+	maxBodyBytes = getEnvInt64("MAX_BODY_BYTES", 1<<20)
+
+	return func(c *gin.Context) {
+		if maxBodyBytes <= 0 || c.Request.Body == nil || strings.HasPrefix(c.ContentType(), "multipart/") {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > maxBodyBytes {
+			abortWithError(c, http.StatusRequestEntityTooLarge, bodyTooLargeMessage())
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes)
+		c.Next()
+	}
+}
+
+// TimeoutMiddleware gives each request a deadline of REQUEST_TIMEOUT (0
+// disables it), cancelling the request context when it passes. A handler
+// that gives up without responding gets 408. Task event streams are long-lived
+// by design and exempt.
+func TimeoutMiddleware() gin.HandlerFunc {
+	timeout := getEnvDuration("REQUEST_TIMEOUT", 60*time.Second)
+	if timeout <= 0 {
+		slog.Info("REQUEST_TIMEOUT disabled; requests have no server-side deadline")
+	}
+
+	return func(c *gin.Context) {
+		if timeout <= 0 || c.FullPath() == "/api/v1/tasks/:task_id/events" {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		if requestTimedOut(c) && !c.Writer.Written() {
+			requestLogger(c).Warn("Request timed out", "timeout", timeout.String())
+			abortWithError(c, http.StatusRequestTimeout, "Request timed out")
+		}
+	}
+}
+
+// requestTimedOut reports whether the request's deadline has passed
+func requestTimedOut(c *gin.Context) bool {
+	return errors.Is(c.Request.Context().Err(), context.DeadlineExceeded)
+}
+
+// bindJSON decodes the request body into obj, responding with 413 if the body
+// is over MAX_BODY_BYTES or 400 if it is invalid. It reports whether decoding
+// succeeded.
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return true
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondError(c, http.StatusRequestEntityTooLarge, bodyTooLargeMessage())
+		return false
+	}
+	respondError(c, http.StatusBadRequest, "Invalid request body")
+	return false
+}
+
+func bodyTooLargeMessage() string {
+	return fmt.Sprintf("Request body too large. Maximum size is %d bytes", maxBodyBytes)
+}
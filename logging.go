@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// requestIDHeader carries the request ID in and out of the API
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+type loggerKey struct{}
+
+// InitLogger configures the default structured logger from LOG_LEVEL
+// (debug, info, warn, error) and LOG_FORMAT (json or text). JSON lines carry
+// level, timestamp, and message keys followed by the contextual fields.
+func InitLogger() {
+	level := slog.LevelInfo
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			defer slog.Warn("Invalid LOG_LEVEL, using info", "value", v)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		if format != "" && format != "json" {
+			defer slog.Warn("Invalid LOG_FORMAT, using json", "value", format)
+		}
+		opts.ReplaceAttr = renameJSONLogKeys
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+
+	// SetDefault also routes the standard log package (used by lib/pq)
+	// through the same handler
+	slog.SetDefault(slog.New(handler))
+
+	// Gin writes its debug output and route table straight to stdout/stderr;
+	// send them through the logger too
+	gin.DefaultWriter = ginLogWriter{level: slog.LevelDebug}
+	gin.DefaultErrorWriter = ginLogWriter{level: slog.LevelError}
+	gin.DebugPrintRouteFunc = func(method, path, handler string, handlers int) {
+		slog.Debug("Route registered", "method", method, "path", path, "handler", handler, "handlers", handlers)
+	}
+}
+
+// renameJSONLogKeys names the built-in time and message keys as log
+// aggregators expect them
+func renameJSONLogKeys(groups []string, attr slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return attr
+	}
+	switch attr.Key {
+	case slog.TimeKey:
+		attr.Key = "timestamp"
+	case slog.MessageKey:
+		attr.Key = "message"
+	}
+	return attr
+}
+
+// ginLogWriter logs each line gin writes as a message at level
+type ginLogWriter struct {
+	level slog.Level
+}
+
+func (w ginLogWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(string(p), "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(line, "[GIN-debug] "))
+		if line != "" {
+			slog.Log(context.Background(), w.level, line, "component", "gin")
+		}
+	}
+	return len(p), nil
+}
+
+// Recovery turns a panicking handler into a 500, logging the panic and stack
+// with the request's logger rather than as gin's multi-line text dump
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err interface{}) {
+		requestLogger(c).Error("Panic recovered", "error", fmt.Sprint(err), "stack", string(debug.Stack()))
+		abortWithError(c, http.StatusInternalServerError, "Internal server error")
+	})
+}
+
+// RequestLogger assigns each request an ID, taken from X-Request-ID when the
+// caller supplies a valid one, attaches a request-scoped logger to the
+// request context, and logs the request once it completes
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+		c.Header(requestIDHeader, requestID)
+
+		// Every line logged for the request carries its ID and the IDs in
+		// its path, e.g. profile_id
+		attrs := []interface{}{"request_id", requestID}
+		for _, param := range c.Params {
+			if strings.HasSuffix(param.Key, "_id") {
+				attrs = append(attrs, param.Key, param.Value)
+			}
+		}
+		ctx := context.WithValue(c.Request.Context(), requestIDKey{}, requestID)
+		ctx = withLogger(ctx, slog.Default().With(attrs...))
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		loggerFromContext(ctx).Log(ctx, level, "Request completed",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
+
+// validRequestID accepts short IDs made of URL-safe characters so a
+// client-supplied header can't inject arbitrary content into the logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// withLogger returns a context carrying the given logger
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFromContext returns the logger attached to ctx, or the default logger
+func loggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// requestIDFromContext returns the request ID attached to ctx, if any
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger returns the logger for the current request
+func requestLogger(c *gin.Context) *slog.Logger {
+	return loggerFromContext(c.Request.Context())
+}
+
+// asyncContext returns a context for background work started by a request.
+// It keeps the request ID and logger but follows the worker pool's
+// cancellation rather than the request's, so the work outlives the response.
+func asyncContext(c *gin.Context) context.Context {
+	reqCtx := c.Request.Context()
+	ctx := context.WithValue(workerPool.Context(), requestIDKey{}, requestIDFromContext(reqCtx))
+	return withLogger(ctx, loggerFromContext(reqCtx))
+}
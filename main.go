@@ -1,8 +1,14 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -10,75 +16,279 @@ import (
 
 func main() {
 	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using system environment variables")
+	envErr := godotenv.Load()
+
+	// Initialize structured logging
+	InitLogger()
+	if envErr != nil {
+		slog.Info("No .env file found, using system environment variables")
+	}
+
+	// Database migration commands: migrate [up|down [n]|status]
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(os.Args[2:]); err != nil {
+			fatal("Migration failed", err)
+		}
+		return
 	}
 
 	// Initialize database
 	if err := InitDB(); err != nil {
-		log.Fatal("Failed to initialize database:", err)
+		fatal("Failed to initialize database", err)
+	}
+
+	// Read the match generation settings
+	if err := InitMatching(); err != nil {
+		fatal("Failed to initialize matching", err)
+	}
+
+	// Give matches made before IDs were derived their derived IDs, unless
+	// MATCH_ID_MODE=random keeps IDs random
+	if !randomMatchIDs {
+		if rekeyed, err := RekeyMatchIDs(); err != nil {
+			slog.Error("Failed to re-key matches to their derived IDs", "error", err)
+		} else if rekeyed > 0 {
+			slog.Info("Re-keyed matches to their derived IDs", "matches", rekeyed)
+		}
+	}
+
+	// Flag existing matches that MAX_MATCH_DISTANCE_KM now rules out
+	if flagged, err := FlagDistantMatches("", matchMaxDistanceKm); err != nil {
+		slog.Error("Failed to flag matches beyond MAX_MATCH_DISTANCE_KM", "error", err)
+	} else if flagged > 0 {
+		slog.Info("Flagged matches beyond MAX_MATCH_DISTANCE_KM", "matches", flagged)
 	}
 
 	// Initialize storage
 	if err := InitStorage(); err != nil {
-		log.Fatal("Failed to initialize storage:", err)
+		fatal("Failed to initialize storage", err)
 	}
 
-	// Initialize MCP client
+	// Initialize MCP client. Heuristic matching runs without one, so demos
+	// and CI don't need an API key.
 	if err := InitMCPClient(); err != nil {
-		log.Fatal("Failed to initialize MCP client:", err)
+		if !heuristicMatching() {
+			fatal("Failed to initialize MCP client", err)
+		}
+		slog.Warn("MCP client unavailable, running without an LLM", "error", err)
+	}
+
+	// Initialize Python worker client
+	if err := InitPythonWorker(); err != nil {
+		fatal("Failed to initialize Python worker client", err)
+	}
+
+	// Initialize task completion webhooks
+	if err := InitWebhooks(); err != nil {
+		fatal("Failed to initialize webhooks", err)
+	}
+
+	// Load the material vocabulary used to canonicalize names
+	if err := InitVocabulary(); err != nil {
+		fatal("Failed to initialize material vocabulary", err)
+	}
+
+	// Initialize worker pool for async processing
+	if err := InitWorkerPool(); err != nil {
+		fatal("Failed to initialize worker pool", err)
+	}
+
+	// Periodically regenerate matches for profiles matched before newer ones arrived
+	if err := InitRematcher(); err != nil {
+		fatal("Failed to initialize rematch job", err)
+	}
+
+	// Delete uploads of long-failed tasks and deleted profiles
+	if err := InitUploadCleanup(); err != nil {
+		fatal("Failed to initialize upload cleanup job", err)
+	}
+
+	// Load API keys
+	if err := InitAuth(); err != nil {
+		fatal("Failed to initialize authentication", err)
 	}
 
 	// Setup router
-	r := gin.Default()
+	r := gin.New()
+	r.Use(RequestLogger(), Recovery())
 
 	// Configure CORS
-	r.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-		c.Next()
-	})
+	r.Use(CORSMiddleware())
 
-	// Health check
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "healthy"})
-	})
+	// Cap request body sizes and give each request a deadline
+	r.Use(BodySizeMiddleware(), TimeoutMiddleware())
+
+	// Health checks: /health/live only confirms the process is up;
+	// /health/ready (and /health) also check the database and dependencies
+	r.GET("/health/live", LivenessHandler)
+	r.GET("/health/ready", ReadinessHandler)
+	r.GET("/health", ReadinessHandler)
 
-	// API routes
-	api := r.Group("/api/v1")
+	// Download an uploaded file via a signed URL. The signature authorizes
+	// the request, so this sits outside the API key check.
+	r.GET("/api/v1/files/:filename", RequireInitialized(), ServeFile)
+
+	// API routes, all requiring an API key
+	api := r.Group("/api/v1", RequireInitialized(), AuthMiddleware())
 	{
 		// Upload document
 		api.POST("/upload", HandleUpload)
 
+		// List tasks
+		api.GET("/tasks", ListTasksHandler)
+
 		// Get task status
 		api.GET("/tasks/:task_id", GetTaskStatus)
 
+		// Stream task status updates as Server-Sent Events
+		api.GET("/tasks/:task_id/events", StreamTaskEvents)
+
+		// Retry a failed document processing task
+		api.POST("/tasks/:task_id/retry", RetryTask)
+
+		// Search profiles by name and materials
+		api.GET("/profiles/search", SearchProfilesHandler)
+
+		// Find profiles near a location
+		api.GET("/profiles/nearby", NearbyProfilesHandler)
+
 		// Get industry profile
 		api.GET("/profiles/:profile_id", GetProfileHandler)
 
+		// Update industry profile
+		api.PUT("/profiles/:profile_id", UpdateProfileHandler)
+
+		// Delete industry profile and its matches
+		api.DELETE("/profiles/:profile_id", DeleteProfileHandler)
+
+		// Add or remove a single waste stream or input, re-matching only
+		// what the change affects
+		api.POST("/profiles/:profile_id/outputs", AddProfileOutputHandler)
+		api.DELETE("/profiles/:profile_id/outputs/:name", RemoveProfileOutputHandler)
+		api.POST("/profiles/:profile_id/inputs", AddProfileInputHandler)
+		api.DELETE("/profiles/:profile_id/inputs/:name", RemoveProfileInputHandler)
+
 		// Get matches for a profile
 		api.GET("/profiles/:profile_id/matches", GetMatches)
 
+		// Get matches offering other profiles' waste to a profile
+		api.GET("/profiles/:profile_id/matches/incoming", GetIncomingMatches)
+
+		// Approve a profile flagged for review and generate its matches
+		api.POST("/profiles/:profile_id/approve", ApproveProfileHandler)
+
+		// Score a profile against one candidate on demand
+		api.POST("/profiles/:profile_id/evaluate", EvaluateMatchHandler)
+
+		// Get a single match
+		api.GET("/matches/:match_id", GetMatchHandler)
+
+		// Audit log of a match's confirmations and rejections
+		api.GET("/matches/:match_id/history", GetMatchHistoryHandler)
+
 		// Confirm match
 		api.POST("/matches/:match_id/confirm", ConfirmMatch)
 
+		// Undo a match confirmation
+		api.POST("/matches/:match_id/unconfirm", UnconfirmMatchHandler)
+
+		// Reject match
+		api.POST("/matches/:match_id/reject", RejectMatchHandler)
+
+		// Permanently remove a bad match
+		api.DELETE("/matches/:match_id", DeleteMatchHandler)
+
 		// List all profiles
 		api.GET("/profiles", ListProfiles)
+
+		// Profiles with low-confidence extractions awaiting review
+		api.GET("/review", ListReviewQueue)
+
+		// Distinct materials across profiles, for autocomplete
+		api.GET("/materials", ListMaterialsHandler)
+
+		// Converter registry for third-party conversions
+		api.GET("/converters", ListConvertersHandler)
+		api.POST("/converters", CreateConverterHandler)
+
+		// CSV exports for spreadsheets
+		api.GET("/export/profiles.csv", ExportProfilesCSV)
+		api.GET("/export/matches.csv", ExportMatchesCSV)
+
+		// Aggregate profile and match statistics
+		api.GET("/stats", GetStats)
+
+		// Profiles and matches as a network graph
+		api.GET("/graph", GetGraphHandler)
+
+		// Regenerate matches for every profile (admin only)
+		api.POST("/admin/rematch", RematchAllHandler)
 	}
 
+	// Unknown routes get the same error envelope as everything else
+	r.NoRoute(func(c *gin.Context) {
+		respondError(c, http.StatusNotFound, "Route not found")
+	})
+
 	// Start server
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	log.Printf("Server starting on port %s", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	// Drop clients that trickle in their requests (slow-loris) or sit idle
+	srv := newHTTPServer(":"+port, r)
+	// End task event streams so they don't hold up shutdown
+	srv.RegisterOnShutdown(taskEvents.Close)
+	// Stop queueing rematches once shutdown begins
+	srv.RegisterOnShutdown(StopRematcher)
+	// Stop deleting uploads once shutdown begins
+	srv.RegisterOnShutdown(StopUploadCleanup)
+
+	go func() {
+		slog.Info("Server starting", "port", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Failed to start server", err)
+		}
+	}()
+
+	// Wait for SIGINT/SIGTERM, then stop taking requests and let in-flight
+	// requests and background jobs finish within SHUTDOWN_TIMEOUT
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+
+	timeout := getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+	slog.Info("Shutting down", "timeout", timeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to shut down server cleanly", "error", err)
 	}
+
+	// Jobs still running when the timeout expires are cancelled and mark
+	// their tasks as failed so they can be retried
+	if err := workerPool.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Background jobs interrupted by shutdown timeout", "error", err)
+	}
+
+	// Let webhook deliveries in flight finish; their retries stopped with the
+	// worker pool
+	if err := WaitWebhooks(shutdownCtx); err != nil {
+		slog.Warn("Task webhooks interrupted by shutdown timeout", "error", err)
+	}
+
+	if err := CloseDB(); err != nil {
+		slog.Error("Failed to close database", "error", err)
+	}
+
+	slog.Info("Server stopped")
+}
+
+// fatal logs an error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// Material states of waste streams and inputs. An empty state means it
+// wasn't stated, and is compatible with any other.
+const (
+	StateSolid  = "solid"
+	StateLiquid = "liquid"
+	StateGas    = "gas"
+	StateSludge = "sludge"
+	StateOther  = "other"
+)
+
+// materialStates lists the canonical states, e.g. for LLM response schemas
+var materialStates = []string{StateSolid, StateLiquid, StateGas, StateSludge, StateOther}
+
+// stateSynonyms maps state spellings, lowercased with hyphens and underscores
+// as spaces, to their canonical form. Markers for a state that wasn't given
+// map to "".
+var stateSynonyms = map[string]string{
+	"solid": StateSolid, "solids": StateSolid, "dry": StateSolid, "powder": StateSolid, "powdered": StateSolid,
+	"granular": StateSolid, "granules": StateSolid, "pellets": StateSolid, "particulate": StateSolid, "bulk solid": StateSolid,
+	"liquid": StateLiquid, "liquids": StateLiquid, "fluid": StateLiquid, "aqueous": StateLiquid, "solution": StateLiquid, "effluent": StateLiquid,
+	"gas": StateGas, "gases": StateGas, "gaseous": StateGas, "vapor": StateGas, "vapour": StateGas, "fumes": StateGas, "exhaust": StateGas,
+	"sludge": StateSludge, "sludges": StateSludge, "slurry": StateSludge, "semi solid": StateSludge, "semisolid": StateSludge,
+	"paste": StateSludge, "mud": StateSludge, "sediment": StateSludge, "filter cake": StateSludge, "wet cake": StateSludge,
+	"other": StateOther, "mixed": StateOther, "mixture": StateOther,
+	"n/a": "", "na": "", "none": "", "unknown": "", "unspecified": "", "not specified": "", "any": "",
+}
+
+// normalizeState maps a state as extracted or submitted onto the canonical
+// states, case-insensitively. known is false for unrecognised values, which
+// become StateOther.
+func normalizeState(raw string) (state string, known bool) {
+	key := strings.Join(strings.Fields(strings.NewReplacer("-", " ", "_", " ").Replace(strings.ToLower(raw))), " ")
+	if key == "" {
+		return "", true
+	}
+	if state, ok := stateSynonyms[key]; ok {
+		return state, true
+	}
+	return StateOther, false
+}
+
+// normalizeProfileStates canonicalizes the state of each of a profile's waste
+// streams and the accepted states of each input, dropping duplicates, and
+// returns the unrecognised values that were coerced to StateOther
+func normalizeProfileStates(profile *IndustryProfile) (unknown []string) {
+	for i := range profile.Outputs {
+		state, known := normalizeState(profile.Outputs[i].State)
+		if !known {
+			unknown = append(unknown, profile.Outputs[i].State)
+		}
+		profile.Outputs[i].State = state
+	}
+
+	for i := range profile.Inputs {
+		var states []string
+		for _, raw := range profile.Inputs[i].States {
+			state, known := normalizeState(raw)
+			if !known {
+				unknown = append(unknown, raw)
+			}
+			if state != "" && !slices.Contains(states, state) {
+				states = append(states, state)
+			}
+		}
+		profile.Inputs[i].States = states
+	}
+	return unknown
+}
@@ -1,271 +1,876 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
+	"unicode/utf8"
 )
 
+// MCPClient builds the prompts for each MCP operation and sends them through
+// an LLMProvider, adding retries, rate limiting, and a circuit breaker
 type MCPClient struct {
-	apiKey  string
-	baseURL string
-	client  *http.Client
+	provider   LLMProvider
+	model      string
+	models     map[string]string           // per-operation model overrides
+	params     map[string]GenerationParams // per-operation sampling parameters
+	timeout    time.Duration               // deadline for each request attempt
+	maxRetries int
+	batchSize  int // candidates per batched conversion estimate
+	limiter    *RateLimiter
+	breaker    *CircuitBreaker
+	prompts    *template.Template // see prompts.go
+
+	// Backoff between retries: a random wait up to retryBaseDelay doubled
+	// per attempt, capped at retryMaxDelay. retryMaxElapsed, unless 0, caps
+	// the time spent on a call across all its attempts.
+	retryBaseDelay  time.Duration
+	retryMaxDelay   time.Duration
+	retryMaxElapsed time.Duration
+
+	// Estimated token budget per FindMatches prompt; 0 for no limit
+	matchPromptTokens int
+
+	// Raw conversion responses kept with matches for auditing (DEBUG_STORE_LLM)
+	storeResponses    bool
+	responseMaxLength int // in characters; 0 keeps whole responses
 }
 
-var mcpClient *MCPClient
+// LLMProvider sends a prompt to one vendor's model API. MCPClient owns the
+// prompts, so a provider only translates request and response shapes.
+type LLMProvider interface {
+	// Name identifies the provider in logs and health checks, and prefixes
+	// its environment variables, e.g. GEMINI_MODEL for "gemini"
+	Name() string
+	// DefaultModel is used when <NAME>_MODEL is unset
+	DefaultModel() string
+	// Generate returns the model's text response to prompt, sampled with
+	// params. When schema is non-nil the response must be JSON matching it.
+	Generate(ctx context.Context, model, prompt string, schema map[string]interface{}, params GenerationParams) (string, error)
+}
+
+// GenerationParams controls how a model samples its response. A zero TopK
+// leaves it to the provider; OpenAI doesn't support it.
+type GenerationParams struct {
+	Temperature     float64
+	TopK            int
+	TopP            float64
+	MaxOutputTokens int
+}
+
+// LLMAPIError is returned when a provider's API responds with a non-200 status
+type LLMAPIError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // from the Retry-After header, if present
+}
+
+func (e *LLMAPIError) Error() string {
+	return fmt.Sprintf("%s API error (status %d): %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// ErrContentBlocked is returned when the model withholds a response, e.g. for
+// safety, so retrying the same prompt won't help
+var ErrContentBlocked = errors.New("model blocked the response")
+
+var (
+	mcpClient  *MCPClient
+	mcpOnce    sync.Once
+	mcpInitErr error
+)
+
+// errNoLLMClient is returned by MCPClient methods called on a nil client,
+// i.e. before InitMCPClient has succeeded
+var errNoLLMClient = fmt.Errorf("LLM client %w", ErrNotInitialized)
+
+// MCP operations, used to select per-operation settings such as the model
+const (
+	opExtract      = "extract"
+	opClassify     = "classify"
+	opMatch        = "match"
+	opConvert      = "convert"
+	opExplain      = "explain"
+	opCanonicalize = "canonicalize"
+)
+
+var mcpOperations = []string{opExtract, opClassify, opMatch, opConvert, opExplain, opCanonicalize}
+
+// defaultGenerationParams are each operation's sampling parameters unless
+// configured otherwise. Operations whose answers feed caches and scoring run
+// at temperature 0 so the same input gets the same answer; only the
+// explanations shown to people are left some variety.
+var defaultGenerationParams = map[string]GenerationParams{
+	opExtract:      {Temperature: 0, TopK: 40, TopP: 0.95, MaxOutputTokens: 8192},
+	opClassify:     {Temperature: 0, TopK: 40, TopP: 0.95, MaxOutputTokens: 8192},
+	opMatch:        {Temperature: 0, TopK: 40, TopP: 0.95, MaxOutputTokens: 8192},
+	opCanonicalize: {Temperature: 0, TopK: 40, TopP: 0.95, MaxOutputTokens: 8192},
+	opConvert:      {Temperature: 0.2, TopK: 40, TopP: 0.95, MaxOutputTokens: 8192}, // room for batched estimates
+	opExplain:      {Temperature: 0.7, TopK: 40, TopP: 0.95, MaxOutputTokens: 8192},
+}
 
-// InitMCPClient initializes the MCP client for Gemini API
+// InitMCPClient initializes the MCP client for the provider named by
+// LLM_PROVIDER: gemini (the default) or openai. Only the first call does so;
+// later calls return its result.
 func InitMCPClient() error {
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		return fmt.Errorf("GEMINI_API_KEY not set")
+	mcpOnce.Do(func() { mcpInitErr = initMCPClient() })
+	return mcpInitErr
+}
+
+func initMCPClient() error {
+	var provider LLMProvider
+	switch name := strings.ToLower(os.Getenv("LLM_PROVIDER")); name {
+	case "", "gemini":
+		apiKey := os.Getenv("GEMINI_API_KEY")
+		if apiKey == "" {
+			return fmt.Errorf("GEMINI_API_KEY not set")
+		}
+		provider = NewGeminiProvider(apiKey, &http.Client{})
+	case "openai":
+		apiKey := os.Getenv("OPENAI_API_KEY")
+		if apiKey == "" {
+			return fmt.Errorf("OPENAI_API_KEY not set")
+		}
+		provider = NewOpenAIProvider(apiKey, &http.Client{})
+	default:
+		return fmt.Errorf("unknown LLM_PROVIDER %q", name)
 	}
 
-	mcpClient = &MCPClient{
-		apiKey:  apiKey,
-		baseURL: "https://generativelanguage.googleapis.com/v1beta",
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+	client := NewMCPClient(provider)
+
+	// Prompt wording can be overridden without a rebuild
+	prompts, err := loadPrompts(os.Getenv("PROMPTS_DIR"))
+	if err != nil {
+		return fmt.Errorf("failed to load prompts: %w", err)
 	}
+	client.prompts = prompts
 
+	mcpClient = client
 	return nil
 }
 
-// ExtractIO calls the MCP tool to extract inputs/outputs from text
-func (m *MCPClient) ExtractIO(text string) (map[string]interface{}, error) {
-	prompt := fmt.Sprintf(`Extract the following from this industrial company description:
-- Company name
-- Location (if mentioned, provide lat/lng or city name)
-- Input materials/resources (as array)
-- Output products/waste streams (as array with name, state, quantity)
+// NewMCPClient creates a client sending its requests through provider. Tests
+// can pass a provider built with their own http.Client, or a fake. The
+// remaining settings are read from environment variables prefixed with the
+// provider's name, e.g. GEMINI_TIMEOUT.
+func NewMCPClient(provider LLMProvider) *MCPClient {
+	prefix := strings.ToUpper(provider.Name())
+
+	model := os.Getenv(prefix + "_MODEL")
+	if model == "" {
+		model = provider.DefaultModel()
+	}
+
+	m := &MCPClient{
+		provider:   provider,
+		model:      model,
+		models:     make(map[string]string),
+		params:     make(map[string]GenerationParams),
+		prompts:    builtinPrompts,
+		timeout:    getEnvDuration(prefix+"_TIMEOUT", 30*time.Second),
+		maxRetries: getEnvInt(prefix+"_MAX_RETRIES", 3),
+		batchSize:  getEnvInt(prefix+"_BATCH_SIZE", 10),
+
+		retryBaseDelay:  getEnvDuration(prefix+"_RETRY_BASE_DELAY", time.Second),
+		retryMaxDelay:   getEnvDuration(prefix+"_RETRY_MAX_DELAY", 30*time.Second),
+		retryMaxElapsed: getEnvDuration(prefix+"_RETRY_MAX_ELAPSED", 2*time.Minute),
+
+		matchPromptTokens: max(getEnvInt(prefix+"_MATCH_MAX_PROMPT_TOKENS", 8000), 0),
+	}
+	if m.maxRetries < 1 {
+		m.maxRetries = 1
+	}
+	if m.retryBaseDelay <= 0 {
+		m.retryBaseDelay = time.Second
+	}
+	if m.retryMaxDelay < m.retryBaseDelay {
+		m.retryMaxDelay = m.retryBaseDelay
+	}
+	if m.retryMaxElapsed < 0 {
+		m.retryMaxElapsed = 0
+	}
+	if m.batchSize < 1 {
+		m.batchSize = 1
+	}
+
+	// Stop calling the provider after repeated transient failures
+	m.breaker = newCircuitBreakerFromEnv(provider.Name(), prefix, isRetryableError)
+
+	// Per-operation models, e.g. GEMINI_MODEL_CLASSIFY=gemini-1.5-flash, GEMINI_MODEL_EXPLAIN=gemini-1.5-pro
+	for _, op := range mcpOperations {
+		if v := os.Getenv(prefix + "_MODEL_" + strings.ToUpper(op)); v != "" {
+			m.models[op] = v
+		}
+	}
+
+	// Sampling parameters: each operation's defaults, overridden for every
+	// operation by e.g. GEMINI_TEMPERATURE, then for one by e.g.
+	// GEMINI_TEMPERATURE_EXPLAIN
+	for _, op := range mcpOperations {
+		p := defaultGenerationParams[op]
+		suffix := "_" + strings.ToUpper(op)
+		p.Temperature = getEnvFloat(prefix+"_TEMPERATURE"+suffix, getEnvFloat(prefix+"_TEMPERATURE", p.Temperature))
+		p.TopK = getEnvInt(prefix+"_TOP_K"+suffix, getEnvInt(prefix+"_TOP_K", p.TopK))
+		p.TopP = getEnvFloat(prefix+"_TOP_P"+suffix, getEnvFloat(prefix+"_TOP_P", p.TopP))
+		p.MaxOutputTokens = getEnvInt(prefix+"_MAX_OUTPUT_TOKENS"+suffix, getEnvInt(prefix+"_MAX_OUTPUT_TOKENS", p.MaxOutputTokens))
+		m.params[op] = p
+	}
+
+	// Keep the model responses each match was built from, so odd reasoning
+	// can be traced to the prompt, model, or parsing
+	m.storeResponses = getEnvBool("DEBUG_STORE_LLM", false)
+	m.responseMaxLength = max(getEnvInt("DEBUG_STORE_LLM_MAX_LENGTH", 20000), 0)
+
+	// Throttle outgoing requests; set <PREFIX>_RATE_LIMIT_RPM=0 to disable
+	if rpm := getEnvInt(prefix+"_RATE_LIMIT_RPM", 60); rpm > 0 {
+		m.limiter = NewRateLimiter(rpm, getEnvInt(prefix+"_RATE_LIMIT_BURST", 5))
+	}
+
+	return m
+}
+
+// Response schemas passed to the model to force structured JSON output
+var (
+	costRangeSchema = map[string]interface{}{
+		"type": "OBJECT",
+		"properties": map[string]interface{}{
+			"low":      map[string]interface{}{"type": "NUMBER"},
+			"high":     map[string]interface{}{"type": "NUMBER"},
+			"currency": map[string]interface{}{"type": "STRING"},
+		},
+		"required": []string{"low", "high", "currency"},
+	}
+
+	amountSchema = map[string]interface{}{
+		"type": "OBJECT",
+		"properties": map[string]interface{}{
+			"value":  map[string]interface{}{"type": "NUMBER"},
+			"unit":   map[string]interface{}{"type": "STRING"},
+			"period": map[string]interface{}{"type": "STRING", "enum": []string{PeriodHour, PeriodDay, PeriodWeek, PeriodMonth, PeriodYear}},
+		},
+		"required": []string{"value", "unit"},
+	}
+
+	extractSchema = map[string]interface{}{
+		"type": "OBJECT",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{"type": "STRING"},
+			"location": map[string]interface{}{
+				"type": "OBJECT",
+				"properties": map[string]interface{}{
+					"lat":  map[string]interface{}{"type": "NUMBER"},
+					"lng":  map[string]interface{}{"type": "NUMBER"},
+					"city": map[string]interface{}{"type": "STRING"},
+				},
+			},
+			"inputs": map[string]interface{}{
+				"type": "ARRAY",
+				"items": map[string]interface{}{
+					"type": "OBJECT",
+					"properties": map[string]interface{}{
+						"name": map[string]interface{}{"type": "STRING"},
+						"states": map[string]interface{}{
+							"type":  "ARRAY",
+							"items": map[string]interface{}{"type": "STRING", "enum": materialStates},
+						},
+						"quantity": map[string]interface{}{"type": "STRING"},
+						"amount":   amountSchema,
+					},
+					"required": []string{"name"},
+				},
+			},
+			"outputs": map[string]interface{}{
+				"type": "ARRAY",
+				"items": map[string]interface{}{
+					"type": "OBJECT",
+					"properties": map[string]interface{}{
+						"name":     map[string]interface{}{"type": "STRING"},
+						"state":    map[string]interface{}{"type": "STRING", "enum": materialStates},
+						"quantity": map[string]interface{}{"type": "STRING"},
+						"amount":   amountSchema,
+					},
+					"required": []string{"name", "state"},
+				},
+			},
+			"confidence": map[string]interface{}{"type": "NUMBER"},
+			"language":   map[string]interface{}{"type": "STRING"},
+		},
+		"required": []string{"name", "inputs", "outputs", "confidence", "language"},
+	}
+
+	canonicalizeSchema = map[string]interface{}{
+		"type": "OBJECT",
+		"properties": map[string]interface{}{
+			"canonical_name": map[string]interface{}{"type": "STRING"},
+		},
+		"required": []string{"canonical_name"},
+	}
+
+	classifySchema = map[string]interface{}{
+		"type": "OBJECT",
+		"properties": map[string]interface{}{
+			"waste_type": map[string]interface{}{"type": "STRING"},
+			"tags": map[string]interface{}{
+				"type":  "ARRAY",
+				"items": map[string]interface{}{"type": "STRING"},
+			},
+			"potential_uses": map[string]interface{}{
+				"type":  "ARRAY",
+				"items": map[string]interface{}{"type": "STRING"},
+			},
+		},
+		"required": []string{"waste_type", "tags", "potential_uses"},
+	}
+
+	conversionSchema = map[string]interface{}{
+		"type": "OBJECT",
+		"properties": map[string]interface{}{
+			"conversion_needed":     map[string]interface{}{"type": "BOOLEAN"},
+			"description":           map[string]interface{}{"type": "STRING"},
+			"recommended_converter": map[string]interface{}{"type": "STRING", "enum": []string{"producer", "consumer", "third-party"}},
+			"estimated_cost":        map[string]interface{}{"type": "STRING"},
+			"cost_range":            costRangeSchema,
+			"complexity":            map[string]interface{}{"type": "STRING", "enum": []string{"low", "medium", "high"}},
+		},
+		"required": []string{"conversion_needed", "description", "recommended_converter", "estimated_cost", "complexity"},
+	}
 
-Text: %s
+	batchConversionSchema = map[string]interface{}{
+		"type": "ARRAY",
+		"items": map[string]interface{}{
+			"type": "OBJECT",
+			"properties": map[string]interface{}{
+				"index":                 map[string]interface{}{"type": "INTEGER"},
+				"conversion_needed":     map[string]interface{}{"type": "BOOLEAN"},
+				"description":           map[string]interface{}{"type": "STRING"},
+				"recommended_converter": map[string]interface{}{"type": "STRING", "enum": []string{"producer", "consumer", "third-party"}},
+				"estimated_cost":        map[string]interface{}{"type": "STRING"},
+				"cost_range":            costRangeSchema,
+				"complexity":            map[string]interface{}{"type": "STRING", "enum": []string{"low", "medium", "high"}},
+				"reasoning":             map[string]interface{}{"type": "STRING"},
+				"consumer_reasoning":    map[string]interface{}{"type": "STRING"},
+			},
+			"required": []string{"index", "conversion_needed", "description", "recommended_converter", "estimated_cost", "complexity", "reasoning", "consumer_reasoning"},
+		},
+	}
+)
 
-Respond with valid JSON only.`, text)
+// ExtractIO calls the MCP tool to extract inputs/outputs from text, which may
+// be in any language, writing the names in lang and detecting the text's own
+func (m *MCPClient) ExtractIO(ctx context.Context, text, lang string) (*ExtractedProfile, error) {
+	prompt, err := m.renderPrompt(promptExtract, extractPrompt{Text: text, Language: languageName(lang)})
+	if err != nil {
+		return nil, err
+	}
 
-	response, err := m.callGemini(prompt)
+	response, err := m.callLLM(ctx, opExtract, prompt, extractSchema)
 	if err != nil {
 		return nil, err
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal([]byte(response), &result); err != nil {
-		// If response is not JSON, try to parse it
-		result = map[string]interface{}{
-			"raw_response": response,
-		}
+	var result ExtractedProfile
+	if err := json.Unmarshal([]byte(extractJSON(response)), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse extraction: %w", err)
 	}
+	parseInputQuantities(result.Inputs)
+	parseOutputQuantities(result.Outputs)
 
-	return result, nil
+	return &result, nil
 }
 
 // ClassifyWaste classifies waste type and adds tags
-func (m *MCPClient) ClassifyWaste(wasteName, state string) (map[string]interface{}, error) {
-	prompt := fmt.Sprintf(`Classify this waste stream and provide relevant tags:
-Waste: %s
-State: %s
-
-Provide classification, industry tags, and potential uses. Respond with JSON containing:
-{
-  "waste_type": "category",
-  "tags": ["tag1", "tag2"],
-  "potential_uses": ["use1", "use2"]
-}`, wasteName, state)
-
-	response, err := m.callGemini(prompt)
+func (m *MCPClient) ClassifyWaste(ctx context.Context, wasteName, state string) (*WasteClassification, error) {
+	prompt, err := m.renderPrompt(promptClassify, classifyPrompt{Name: wasteName, State: state})
 	if err != nil {
 		return nil, err
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal([]byte(response), &result); err != nil {
-		result = map[string]interface{}{
-			"waste_type":     "unclassified",
-			"tags":           []string{},
-			"potential_uses": []string{},
-		}
+	response, err := m.callLLM(ctx, opClassify, prompt, classifySchema)
+	if err != nil {
+		return nil, err
+	}
+
+	var result WasteClassification
+	if err := json.Unmarshal([]byte(extractJSON(response)), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse classification: %w", err)
 	}
 
-	return result, nil
+	return &result, nil
 }
 
-// FindMatches finds potential candidate industries for a waste stream
-func (m *MCPClient) FindMatches(waste Output, candidates []*IndustryProfile) ([]string, error) {
+// CanonicalizeMaterial returns the standard name of a material or waste
+// stream, so variants such as "Al dross" and "aluminium dross" agree
+func (m *MCPClient) CanonicalizeMaterial(ctx context.Context, name string) (string, error) {
+	prompt, err := m.renderPrompt(promptCanonicalize, canonicalizePrompt{Name: name})
+	if err != nil {
+		return "", err
+	}
+
+	response, err := m.callLLM(ctx, opCanonicalize, prompt, canonicalizeSchema)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		CanonicalName string `json:"canonical_name"`
+	}
+	if err := json.Unmarshal([]byte(extractJSON(response)), &result); err != nil {
+		return "", fmt.Errorf("failed to parse canonical name: %w", err)
+	}
+
+	return result.CanonicalName, nil
+}
+
+// FindMatches finds potential candidate industries for a waste stream. The
+// candidates are sent in as many batches as it takes to keep each prompt
+// within <PREFIX>_MATCH_MAX_PROMPT_TOKENS, and the names picked from each are
+// combined. If some batches fail, the names from the others are returned
+// along with the error.
+func (m *MCPClient) FindMatches(ctx context.Context, waste Output, candidates []*IndustryProfile) ([]string, error) {
 	candidateNames := make([]string, len(candidates))
 	for i, c := range candidates {
-		candidateNames[i] = fmt.Sprintf("%s (inputs: %v)", c.Name, c.Inputs)
+		candidateNames[i] = fmt.Sprintf("%s (inputs: %s)", c.Name, describeInputs(c.Inputs))
 	}
 
-	prompt := fmt.Sprintf(`Given this waste stream:
-Name: %s
-State: %s
-Quantity: %s
+	batches, err := m.matchBatches(ctx, waste, candidateNames)
+	if err != nil {
+		return nil, err
+	}
+	if len(batches) > 1 {
+		loggerFromContext(ctx).Info("Splitting match candidates to fit the prompt budget",
+			"candidates", len(candidates), "batches", len(batches), "max_prompt_tokens", m.matchPromptTokens)
+	}
 
-Find which of these industries could use it as input:
-%v
+	var matches []string
+	seen := make(map[string]bool)
+	var errs []error
+	for _, batch := range batches {
+		found, err := m.findMatchesBatch(ctx, waste, batch)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, name := range found {
+			if !seen[name] {
+				seen[name] = true
+				matches = append(matches, name)
+			}
+		}
+	}
 
-Respond with JSON array of matching industry names: ["industry1", "industry2"]`, 
-		waste.Name, waste.State, waste.Quantity, candidateNames)
+	return matches, errors.Join(errs...)
+}
 
-	response, err := m.callGemini(prompt)
+// findMatchesBatch asks the model which of one batch of candidates, given as
+// descriptions, could use a waste stream
+func (m *MCPClient) findMatchesBatch(ctx context.Context, waste Output, candidateNames []string) ([]string, error) {
+	prompt, err := m.renderPrompt(promptMatch, matchPrompt{Waste: newPromptWaste(waste), Candidates: candidateNames})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := m.callLLM(ctx, opMatch, prompt, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	var matches []string
-	if err := json.Unmarshal([]byte(response), &matches); err != nil {
+	if err := json.Unmarshal([]byte(extractJSON(response)), &matches); err != nil {
 		// Return empty if parsing fails
+		loggerFromContext(ctx).Warn("Failed to parse match response, treating as no matches", "error", err)
 		return []string{}, nil
 	}
 
 	return matches, nil
 }
 
-// EstimateConversion estimates the conversion process needed
-func (m *MCPClient) EstimateConversion(waste Output, candidateInput string) (map[string]interface{}, error) {
-	prompt := fmt.Sprintf(`Determine if conversion is needed to transform this waste into usable input:
-Waste: %s (state: %s, quantity: %s)
-Target Input: %s
-
-Respond with JSON:
-{
-  "conversion_needed": true/false,
-  "description": "conversion process description",
-  "recommended_converter": "producer/consumer/third-party",
-  "estimated_cost": "cost estimate",
-  "complexity": "low/medium/high"
-}`, waste.Name, waste.State, waste.Quantity, candidateInput)
-
-	response, err := m.callGemini(prompt)
+// matchBatches splits candidate descriptions into batches whose match prompts
+// are estimated to stay within the token budget. A candidate too large to fit
+// with any other gets a batch of its own. If the prompt's fixed text alone is
+// over budget, splitting can't help, so every candidate goes in one batch.
+func (m *MCPClient) matchBatches(ctx context.Context, waste Output, candidateNames []string) ([][]string, error) {
+	if len(candidateNames) == 0 {
+		return nil, nil
+	}
+	if m.matchPromptTokens <= 0 {
+		return [][]string{candidateNames}, nil
+	}
+
+	// The prompt's fixed text counts against every batch
+	base, err := m.renderPrompt(promptMatch, matchPrompt{Waste: newPromptWaste(waste)})
 	if err != nil {
 		return nil, err
 	}
+	budget := m.matchPromptTokens - estimateTokens(base)
+	if budget <= 0 {
+		loggerFromContext(ctx).Warn("Match prompt exceeds its token budget without candidates; sending them in one prompt",
+			"waste", waste.Name, "prompt_tokens", estimateTokens(base), "max_prompt_tokens", m.matchPromptTokens)
+		return [][]string{candidateNames}, nil
+	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal([]byte(response), &result); err != nil {
-		result = map[string]interface{}{
-			"conversion_needed":      false,
-			"description":            "Unable to determine",
-			"recommended_converter":  "unknown",
-			"estimated_cost":         "Unknown",
-			"complexity":             "unknown",
+	var batches [][]string
+	var batch []string
+	used := 0
+	for _, name := range candidateNames {
+		cost := estimateTokens(name) + 1 // and a separator
+		if len(batch) > 0 && used+cost > budget {
+			batches = append(batches, batch)
+			batch, used = nil, 0
 		}
+		batch = append(batch, name)
+		used += cost
 	}
-
-	return result, nil
+	return append(batches, batch), nil
 }
 
-// ExplainMatch generates reasoning for why a match is good
-func (m *MCPClient) ExplainMatch(waste Output, candidate *IndustryProfile, conversionInfo map[string]interface{}) (string, error) {
-	prompt := fmt.Sprintf(`Explain why this is a good industrial symbiosis match:
-Producer Waste: %s (%s, %s)
-Consumer: %s
-Consumer Inputs: %v
-Conversion: %v
+// charsPerToken approximates how many characters make up a token, so prompt
+// sizes can be estimated without the provider's tokenizer
+const charsPerToken = 4
+
+// estimateTokens roughly counts the tokens in s, erring high
+func estimateTokens(s string) int {
+	return (utf8.RuneCountInString(s) + charsPerToken - 1) / charsPerToken
+}
 
-Provide a clear, concise explanation of the symbiotic benefit.`, 
-		waste.Name, waste.State, waste.Quantity, 
-		candidate.Name, candidate.Inputs, conversionInfo)
+// EstimateConversion estimates the conversion process needed
+func (m *MCPClient) EstimateConversion(ctx context.Context, waste Output, candidateInput string) (*ConversionEstimate, error) {
+	prompt, err := m.renderPrompt(promptConvert, convertPrompt{Waste: newPromptWaste(waste), TargetInput: candidateInput})
+	if err != nil {
+		return nil, err
+	}
 
-	reasoning, err := m.callGemini(prompt)
+	response, err := m.callLLM(ctx, opConvert, prompt, conversionSchema)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return reasoning, nil
+	raw := json.RawMessage(extractJSON(response))
+	var result ConversionEstimate
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse conversion estimate: %w", err)
+	}
+	if m.storeResponses {
+		result.rawResponse = raw
+	}
+
+	return &result, nil
 }
 
-// callGemini makes an API call to Gemini
-func (m *MCPClient) callGemini(prompt string) (string, error) {
-	url := fmt.Sprintf("%s/models/gemini-pro:generateContent?key=%s", m.baseURL, m.apiKey)
+// EstimateConversions estimates conversion requirements and explains the match
+// for several candidates at once, sending batchSize candidates per call
+// instead of one EstimateConversion and ExplainMatch call each. Results are
+// keyed by candidate ID. The reasoning for the producer is written in lang,
+// and that for each consumer in the consumer's language. If some batches fail, the results of the others are
+// returned along with the error. A batch the model blocks is retried a candidate
+// at a time, so only the candidates it objects to are left out.
+func (m *MCPClient) EstimateConversions(ctx context.Context, waste Output, lang string, candidates []*IndustryProfile) (map[string]*CandidateConversion, error) {
+	if m == nil {
+		return nil, errNoLLMClient
+	}
 
-	requestBody := map[string]interface{}{
-		"contents": []map[string]interface{}{
-			{
-				"parts": []map[string]string{
-					{"text": prompt},
-				},
-			},
-		},
-		"generationConfig": map[string]interface{}{
-			"temperature": 0.7,
-			"topK":        40,
-			"topP":        0.95,
-			"maxOutputTokens": 2048,
-		},
+	results := make(map[string]*CandidateConversion)
+	var errs []error
+
+	for start := 0; start < len(candidates); start += m.batchSize {
+		end := min(start+m.batchSize, len(candidates))
+		batch := candidates[start:end]
+
+		err := m.estimateBatch(ctx, waste, lang, batch, results)
+		if errors.Is(err, ErrContentBlocked) && len(batch) > 1 {
+			for i := range batch {
+				errs = append(errs, m.estimateBatch(ctx, waste, lang, batch[i:i+1], results))
+			}
+			continue
+		}
+		errs = append(errs, err)
 	}
 
-	jsonData, err := json.Marshal(requestBody)
+	return results, errors.Join(errs...)
+}
+
+// estimateBatch makes one batched conversion estimate, adding the entries to
+// results
+func (m *MCPClient) estimateBatch(ctx context.Context, waste Output, lang string, batch []*IndustryProfile, results map[string]*CandidateConversion) error {
+	consumers := make([]promptConsumer, len(batch))
+	for i, c := range batch {
+		consumers[i] = newPromptConsumer(c)
+		consumers[i].Index = i
+	}
+
+	prompt, err := m.renderPrompt(promptConvertBatch, convertBatchPrompt{Waste: newPromptWaste(waste), Consumers: consumers, Language: languageName(lang)})
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	response, err := m.callLLM(ctx, opConvert, prompt, batchConversionSchema)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	var rawEntries []json.RawMessage
+	if err := json.Unmarshal([]byte(extractJSON(response)), &rawEntries); err != nil {
+		return fmt.Errorf("failed to parse conversion estimates: %w", err)
+	}
+	entries := make([]CandidateConversion, len(rawEntries))
+	for i, raw := range rawEntries {
+		if err := json.Unmarshal(raw, &entries[i]); err != nil {
+			return fmt.Errorf("failed to parse conversion estimates: %w", err)
+		}
+	}
 
-	resp, err := m.client.Do(req)
+	// Each candidate's match keeps only its own entry of the batch response
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Index < 0 || entry.Index >= len(batch) {
+			continue
+		}
+		if m.storeResponses {
+			entry.rawResponse = rawEntries[i]
+		}
+		results[batch[entry.Index].ID] = entry
+	}
+	return nil
+}
+
+// matchAudit is the part of each model response one match was built from
+type matchAudit struct {
+	FindMatches         string          `json:"find_matches,omitempty"`         // the candidate's name as FindMatches picked it
+	Conversion          json.RawMessage `json:"conversion,omitempty"`           // its conversion estimate, or its entry in a batched one
+	Explanation         string          `json:"explanation,omitempty"`          // ExplainMatch for the producer
+	ConsumerExplanation string          `json:"consumer_explanation,omitempty"` // ExplainMatch for the consumer
+}
+
+// auditResponse prepares the model responses a match was built from for
+// storage with it, as JSON cut to DEBUG_STORE_LLM_MAX_LENGTH characters. It
+// returns nil unless DEBUG_STORE_LLM is set.
+func (m *MCPClient) auditResponse(parts matchAudit) *LLMResponse {
+	if m == nil || !m.storeResponses {
+		return nil
+	}
+	data, err := json.Marshal(parts)
 	if err != nil {
-		return "", fmt.Errorf("failed to call Gemini API: %w", err)
+		return nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("Gemini API error (status %d): %s", resp.StatusCode, string(body))
+	response := string(data)
+	audit := &LLMResponse{Response: response}
+	if runes := []rune(response); m.responseMaxLength > 0 && len(runes) > m.responseMaxLength {
+		audit.Response = string(runes[:m.responseMaxLength])
+		audit.Truncated = true
+	}
+	return audit
+}
+
+// ExplainMatch generates reasoning for why a match is good, written for the
+// producer of the waste or for the consumer taking it, as perspective says,
+// in lang
+func (m *MCPClient) ExplainMatch(ctx context.Context, waste Output, candidate *IndustryProfile, conversion *ConversionEstimate, perspective, lang string) (string, error) {
+	data := explainPrompt{
+		Waste:       newPromptWaste(waste),
+		Consumer:    newPromptConsumer(candidate),
+		Perspective: perspective,
+		Language:    languageName(lang),
 	}
+	data.Conversion.Needed = conversion.ConversionNeeded
+	data.Conversion.Description = conversion.Description
+	data.Conversion.Complexity = conversion.Complexity
 
-	body, err := io.ReadAll(resp.Body)
+	prompt, err := m.renderPrompt(promptExplain, data)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return "", err
 	}
 
-	var response map[string]interface{}
-	if err := json.Unmarshal(body, &response); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+	reasoning, err := m.callLLM(ctx, opExplain, prompt, nil)
+	if err != nil {
+		return "", err
 	}
 
-	// Extract text from Gemini response structure
-	if candidates, ok := response["candidates"].([]interface{}); ok && len(candidates) > 0 {
-		if candidate, ok := candidates[0].(map[string]interface{}); ok {
-			if content, ok := candidate["content"].(map[string]interface{}); ok {
-				if parts, ok := content["parts"].([]interface{}); ok && len(parts) > 0 {
-					if part, ok := parts[0].(map[string]interface{}); ok {
-						if text, ok := part["text"].(string); ok {
-							return text, nil
-						}
-					}
-				}
+	return reasoning, nil
+}
+
+// describeInputs lists inputs for a prompt
+func describeInputs(inputs []Input) string {
+	descriptions := make([]string, len(inputs))
+	for i, input := range inputs {
+		descriptions[i] = input.String()
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+// extractJSON pulls the first top-level JSON object or array out of a model
+// response, dropping markdown code fences and any surrounding prose
+func extractJSON(response string) string {
+	start := strings.IndexAny(response, "{[")
+	if start < 0 {
+		return strings.TrimSpace(response)
+	}
+
+	depth := 0
+	inString := false
+	escaped := false
+	for i := start; i < len(response); i++ {
+		ch := response[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == '"':
+				inString = false
 			}
+			continue
 		}
+
+		switch ch {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return response[start : i+1]
+			}
+		}
+	}
+
+	// Unbalanced; let the JSON decoder report the error
+	return strings.TrimSpace(response[start:])
+}
+
+// modelFor returns the model configured for an operation, falling back to the default
+func (m *MCPClient) modelFor(op string) string {
+	if model, ok := m.models[op]; ok {
+		return model
 	}
+	return m.model
+}
+
+// callLLM sends an operation's prompt to the provider, retrying transient failures.
+// When schema is non-nil the response is constrained to JSON matching it. Each
+// attempt is bounded by the client's timeout and aborted if ctx is cancelled.
+func (m *MCPClient) callLLM(ctx context.Context, op, prompt string, schema map[string]interface{}) (string, error) {
+	if m == nil {
+		return "", errNoLLMClient
+	}
+	model := m.modelFor(op)
+	params := m.params[op]
+	result, err := m.CallWithRetry(ctx, func() (interface{}, error) {
+		attemptCtx, cancel := context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+
+		var text string
+		err := m.breaker.Execute(func() error {
+			if m.limiter != nil {
+				if err := m.limiter.Wait(attemptCtx); err != nil {
+					return err
+				}
+			}
 
-	return "", fmt.Errorf("unexpected response format from Gemini API")
+			var err error
+			text, err = m.provider.Generate(attemptCtx, model, prompt, schema, params)
+			return err
+		})
+		return text, err
+	}, m.maxRetries)
+	if errors.Is(err, ErrContentBlocked) {
+		loggerFromContext(ctx).Warn("Model blocked response", "provider", m.provider.Name(), "operation", op, "model", model, "error", err)
+	}
+	if err != nil {
+		return "", err
+	}
+	return result.(string), nil
 }
 
-// CallWithRetry calls an MCP tool with retry logic
-func (m *MCPClient) CallWithRetry(fn func() (interface{}, error), maxRetries int) (interface{}, error) {
+// CallWithRetry calls an MCP tool with jittered exponential backoff, retrying
+// only transient errors. It gives up as soon as ctx is cancelled, or when the
+// next wait would take the call past the client's retryMaxElapsed.
+func (m *MCPClient) CallWithRetry(ctx context.Context, fn func() (interface{}, error), maxRetries int) (interface{}, error) {
 	var lastErr error
-	
+	start := time.Now()
+
 	for i := 0; i < maxRetries; i++ {
 		result, err := fn()
 		if err == nil {
 			return result, nil
 		}
-		
+
 		lastErr = err
+		if ctx.Err() != nil || !isRetryableError(err) {
+			return nil, err
+		}
 		if i < maxRetries-1 {
-			time.Sleep(time.Duration(i+1) * time.Second)
+			delay := m.retryDelay(err, i)
+			if m.retryMaxElapsed > 0 && time.Since(start)+delay > m.retryMaxElapsed {
+				return nil, fmt.Errorf("gave up after %d attempts in %s: %w", i+1, time.Since(start).Round(time.Millisecond), lastErr)
+			}
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
 		}
 	}
-	
+
 	return nil, fmt.Errorf("failed after %d retries: %w", maxRetries, lastErr)
+}
+
+// retryDelay returns how long to wait before the next attempt, honoring a
+// server-provided Retry-After over the backoff. The backoff is "full jitter":
+// a random wait between 0 and the exponential delay, so clients that failed
+// together don't all retry together.
+func (m *MCPClient) retryDelay(err error, attempt int) time.Duration {
+	var apiErr *LLMAPIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+
+	ceiling := m.retryMaxDelay
+	if attempt < 32 {
+		if d := m.retryBaseDelay << attempt; d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// isRetryableError reports whether an error is worth retrying: rate limits,
+// server errors, and network failures are; client errors and bad responses are not
+func isRetryableError(err error) bool {
+	var apiErr *LLMAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
 }
\ No newline at end of file
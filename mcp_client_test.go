@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     string
+	}{
+		{
+			name:     "bare object",
+			response: `{"inputs": ["scrap metal"], "outputs": []}`,
+			want:     `{"inputs": ["scrap metal"], "outputs": []}`,
+		},
+		{
+			name:     "json fence",
+			response: "```json\n{\"waste_type\": \"metal\", \"tags\": [\"ferrous\"]}\n```",
+			want:     `{"waste_type": "metal", "tags": ["ferrous"]}`,
+		},
+		{
+			name:     "bare fence",
+			response: "```\n[{\"candidate_id\": \"a\", \"score\": 0.8}]\n```\n",
+			want:     `[{"candidate_id": "a", "score": 0.8}]`,
+		},
+		{
+			name:     "leading and trailing prose",
+			response: "Here is the extracted profile:\n\n```json\n{\"name\": \"Acme Steel\"}\n```\n\nLet me know if you need anything else.",
+			want:     `{"name": "Acme Steel"}`,
+		},
+		{
+			name:     "prose without a fence",
+			response: `Sure! {"conversion_needed": false} Hope that helps.`,
+			want:     `{"conversion_needed": false}`,
+		},
+		{
+			name:     "nested objects and arrays",
+			response: "```json\n{\"outputs\": [{\"name\": \"slag\", \"amount\": {\"value\": 200, \"unit\": \"t\"}}, {\"name\": \"dust\", \"tags\": [[1, 2], []]}]}\n```",
+			want:     `{"outputs": [{"name": "slag", "amount": {"value": 200, "unit": "t"}}, {"name": "dust", "tags": [[1, 2], []]}]}`,
+		},
+		{
+			name:     "top-level array of objects",
+			response: "Matches:\n[{\"a\": {\"b\": [1]}}, {\"c\": 2}]\nDone.",
+			want:     `[{"a": {"b": [1]}}, {"c": 2}]`,
+		},
+		{
+			name:     "brackets and quotes inside strings",
+			response: `{"reasoning": "uses \"slag\" {granulated} [for cement]", "score": 1}` + "\n```",
+			want:     `{"reasoning": "uses \"slag\" {granulated} [for cement]", "score": 1}`,
+		},
+		{
+			name:     "only the first value",
+			response: `{"first": 1} {"second": 2}`,
+			want:     `{"first": 1}`,
+		},
+		{
+			name:     "no JSON",
+			response: "  I can't help with that.  ",
+			want:     "I can't help with that.",
+		},
+		{
+			name:     "truncated",
+			response: "```json\n{\"outputs\": [\"slag\"",
+			want:     `{"outputs": ["slag"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractJSON(tt.response); got != tt.want {
+				t.Errorf("extractJSON(%q) = %q, want %q", tt.response, got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestMCPClient returns a client whose Gemini provider talks to a test
+// server answering every request with reply as the model's text
+func newTestMCPClient(t *testing.T, reply string) *MCPClient {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, ":generateContent") {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		body := map[string]interface{}{
+			"candidates": []interface{}{map[string]interface{}{
+				"content":      map[string]interface{}{"parts": []interface{}{map[string]interface{}{"text": reply}}},
+				"finishReason": "STOP",
+			}},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("GEMINI_RATE_LIMIT_RPM", "0")
+	t.Setenv("GEMINI_MAX_RETRIES", "1")
+	provider := NewGeminiProvider("test-key", server.Client())
+	provider.baseURL = server.URL
+	return NewMCPClient(provider)
+}
+
+func TestExtractIO(t *testing.T) {
+	const profile = `{"name": "Acme Steel", "location": {"lat": 51.5, "lng": -0.1, "city": "London"},
+		"inputs": [{"name": "scrap steel"}], "outputs": [{"name": "steel slag", "state": "solid"}],
+		"confidence": 0.9, "language": "en"}`
+
+	tests := []struct {
+		name  string
+		reply string
+		err   bool
+	}{
+		{"well-formed", profile, false},
+		{"code-fenced", "```json\n" + profile + "\n```", false},
+		{"with prose", "Here is the profile:\n" + profile + "\nAnything else?", false},
+		{"malformed", `{"name": "Acme Steel", "inputs": [`, true},
+		{"not JSON", "I could not find any inputs or outputs.", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMCPClient(t, tt.reply)
+			got, err := m.ExtractIO(context.Background(), "Acme Steel melts scrap steel and produces slag.", "en")
+			if tt.err {
+				if err == nil {
+					t.Fatalf("ExtractIO succeeded with %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != "Acme Steel" || got.Location.City != "London" || got.Confidence != 0.9 {
+				t.Errorf("ExtractIO = %+v", got)
+			}
+			if len(got.Inputs) != 1 || got.Inputs[0].Name != "scrap steel" {
+				t.Errorf("inputs = %+v, want scrap steel", got.Inputs)
+			}
+			if len(got.Outputs) != 1 || got.Outputs[0].Name != "steel slag" || got.Outputs[0].State != "solid" {
+				t.Errorf("outputs = %+v, want solid steel slag", got.Outputs)
+			}
+		})
+	}
+}
+
+func TestClassifyWaste(t *testing.T) {
+	const classification = `{"waste_type": "metal", "tags": ["ferrous", "slag"], "potential_uses": ["cement"]}`
+
+	tests := []struct {
+		name  string
+		reply string
+		err   bool
+	}{
+		{"well-formed", classification, false},
+		{"code-fenced", "```json\n" + classification + "\n```", false},
+		{"bare fence", "```\n" + classification + "\n```", false},
+		{"malformed", `{"waste_type": "metal", "tags": ["ferrous"`, true},
+		{"wrong type", `{"waste_type": ["metal"]}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMCPClient(t, tt.reply)
+			got, err := m.ClassifyWaste(context.Background(), "steel slag", "solid")
+			if tt.err {
+				if err == nil {
+					t.Fatalf("ClassifyWaste succeeded with %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.WasteType != "metal" || !slices.Equal(got.Tags, []string{"ferrous", "slag"}) || !slices.Equal(got.PotentialUses, []string{"cement"}) {
+				t.Errorf("ClassifyWaste = %+v", got)
+			}
+		})
+	}
+}
+
+func TestFindMatches(t *testing.T) {
+	candidates := []*IndustryProfile{
+		{Name: "Cement Works", Inputs: []Input{{Name: "slag"}}},
+		{Name: "Brickyard", Inputs: []Input{{Name: "clay"}}},
+	}
+
+	tests := []struct {
+		name  string
+		reply string
+		want  []string
+	}{
+		{"well-formed", `["Cement Works"]`, []string{"Cement Works"}},
+		{"code-fenced", "```json\n[\"Cement Works\", \"Brickyard\"]\n```", []string{"Cement Works", "Brickyard"}},
+		{"none", `[]`, nil},
+		// A response that can't be parsed counts as no matches, not a failure
+		{"malformed", `["Cement Works"`, nil},
+		{"not a list", `{"matches": ["Cement Works"]}`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMCPClient(t, tt.reply)
+			got, err := m.FindMatches(context.Background(), Output{Name: "steel slag", State: "solid"}, candidates)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("FindMatches = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchBatches(t *testing.T) {
+	m := newTestMCPClient(t, "")
+	waste := Output{Name: "steel slag", State: "solid"}
+	base, err := m.renderPrompt(promptMatch, matchPrompt{Waste: newPromptWaste(waste)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{strings.Repeat("a", 40), strings.Repeat("b", 40), strings.Repeat("c", 40)}
+
+	tests := []struct {
+		name   string
+		tokens int
+		want   int // batches
+	}{
+		{"no budget", 0, 1},
+		{"room for all", estimateTokens(base) + 100, 1},
+		{"room for two", estimateTokens(base) + 2*(estimateTokens(names[0])+1), 2},
+		// Splitting can't bring the fixed text under budget
+		{"prompt over budget", estimateTokens(base) / 2, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.matchPromptTokens = tt.tokens
+			batches, err := m.matchBatches(context.Background(), waste, names)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(batches) != tt.want {
+				t.Errorf("matchBatches = %d batches %q, want %d", len(batches), batches, tt.want)
+			}
+		})
+	}
+}
+
+// TestNilClient checks that calls on a client that was never initialized fail
+// with errNoLLMClient rather than panicking
+func TestNilClient(t *testing.T) {
+	var m *MCPClient
+	ctx := context.Background()
+
+	if _, err := m.ExtractIO(ctx, "Acme Steel Ltd", "en"); !errors.Is(err, errNoLLMClient) {
+		t.Errorf("ExtractIO error = %v, want errNoLLMClient", err)
+	}
+	if _, err := m.ClassifyWaste(ctx, "steel slag", "solid"); !errors.Is(err, errNoLLMClient) {
+		t.Errorf("ClassifyWaste error = %v, want errNoLLMClient", err)
+	}
+	if _, err := m.CanonicalizeMaterial(ctx, "steel slag"); !errors.Is(err, errNoLLMClient) {
+		t.Errorf("CanonicalizeMaterial error = %v, want errNoLLMClient", err)
+	}
+}
+
+// TestEstimateConversionsAudit checks that each candidate keeps only its own
+// entry of a batched response for its match's audit record
+func TestEstimateConversionsAudit(t *testing.T) {
+	t.Setenv("DEBUG_STORE_LLM", "true")
+	m := newTestMCPClient(t, "```json\n"+`[
+		{"index": 1, "conversion_needed": true, "complexity": "high", "reasoning": "Brickyard needs it milled"},
+		{"index": 0, "conversion_needed": false, "complexity": "low", "reasoning": "Cement Works takes it as is"}
+	]`+"\n```")
+
+	candidates := []*IndustryProfile{{ID: "cement", Name: "Cement Works"}, {ID: "bricks", Name: "Brickyard"}}
+	results, err := m.EstimateConversions(context.Background(), Output{Name: "steel slag"}, "en", candidates)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[string]string{"cement": "Cement Works takes it as is", "bricks": "Brickyard needs it milled"} {
+		conversion, ok := results[id]
+		if !ok {
+			t.Fatalf("no result for %s", id)
+		}
+		audit := m.auditResponse(matchAudit{FindMatches: id, Conversion: conversion.rawResponse})
+		if audit == nil {
+			t.Fatalf("%s: no audit record with DEBUG_STORE_LLM set", id)
+		}
+
+		var parts struct {
+			FindMatches string `json:"find_matches"`
+			Conversion  struct {
+				Reasoning string `json:"reasoning"`
+			} `json:"conversion"`
+		}
+		if err := json.Unmarshal([]byte(audit.Response), &parts); err != nil {
+			t.Fatalf("%s: audit record is not JSON: %v", id, err)
+		}
+		if parts.FindMatches != id || parts.Conversion.Reasoning != want {
+			t.Errorf("%s: audit record %s, want only its own entry", id, audit.Response)
+		}
+	}
+}
+
+func TestAuditResponse(t *testing.T) {
+	t.Setenv("DEBUG_STORE_LLM", "false")
+	if audit := newTestMCPClient(t, "").auditResponse(matchAudit{Explanation: "good fit"}); audit != nil {
+		t.Errorf("auditResponse = %+v without DEBUG_STORE_LLM, want nil", audit)
+	}
+
+	t.Setenv("DEBUG_STORE_LLM", "true")
+	t.Setenv("DEBUG_STORE_LLM_MAX_LENGTH", "20")
+	audit := newTestMCPClient(t, "").auditResponse(matchAudit{Explanation: strings.Repeat("é", 50)})
+	if audit == nil || !audit.Truncated || len([]rune(audit.Response)) != 20 {
+		t.Errorf("auditResponse = %+v, want 20 characters, truncated", audit)
+	}
+}
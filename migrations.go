@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// Migrations live in migrations/ as NNNN_name.up.sql and NNNN_name.down.sql
+// and are applied by golang-migrate. Add a new pair with the next number for
+// every schema change; never edit a migration that has already been released.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationsTable is where golang-migrate records the schema version. It
+// isn't golang-migrate's default, schema_migrations, because that is
+// legacyMigrationsTable: one row per migration, written by the runner used
+// before golang-migrate.
+const (
+	migrationsTable       = "schema_version"
+	legacyMigrationsTable = "schema_migrations"
+)
+
+// legacyAdoptionLockID is the Postgres advisory lock key held while adopting
+// legacyMigrationsTable
+const legacyAdoptionLockID = 7283910452
+
+// newMigrator returns a golang-migrate instance over the embedded migrations,
+// on a connection of its own that closing it releases. A database migrated
+// before golang-migrate was adopted starts from the last version it applied.
+func newMigrator() (*migrate.Migrate, error) {
+	if err := checkDB(); err != nil {
+		return nil, err
+	}
+
+	source, err := iofs.New(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	// A connection rather than the pool, since closing the driver closes
+	// whatever it was given
+	ctx := context.Background()
+	conn, err := currentDB().Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{MigrationsTable: migrationsTable})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to prepare migrations: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		driver.Close()
+		return nil, fmt.Errorf("failed to prepare migrations: %w", err)
+	}
+	m.Log = migrateLogger{}
+
+	// The driver has created migrationsTable by now
+	if err := adoptLegacyVersion(); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// adoptLegacyVersion gives a database migrated by the runner used before
+// golang-migrate the schema version of the newest migration recorded in
+// legacyMigrationsTable, then drops that table. The advisory lock keeps
+// instances starting together from racing over it.
+func adoptLegacyVersion() error {
+	tx, err := currentDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, legacyAdoptionLockID); err != nil {
+		return fmt.Errorf("failed to lock %s: %w", legacyMigrationsTable, err)
+	}
+	// A new database, or one already adopted, has no legacy table
+	var legacy bool
+	if err := tx.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, legacyMigrationsTable).Scan(&legacy); err != nil || !legacy {
+		return err
+	}
+
+	var version sql.NullInt64
+	if err := tx.QueryRow(`SELECT MAX(version) FROM ` + legacyMigrationsTable).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read %s: %w", legacyMigrationsTable, err)
+	}
+	if version.Valid {
+		slog.Info("Adopting the schema version of the previous migration runner", "version", version.Int64)
+		_, err := tx.Exec(`INSERT INTO `+migrationsTable+` (version, dirty)
+			SELECT $1, FALSE WHERE NOT EXISTS (SELECT 1 FROM `+migrationsTable+`)`, version.Int64)
+		if err != nil {
+			return fmt.Errorf("failed to record the schema version: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`DROP TABLE ` + legacyMigrationsTable); err != nil {
+		return fmt.Errorf("failed to drop %s: %w", legacyMigrationsTable, err)
+	}
+	return tx.Commit()
+}
+
+// MigrateUp applies all pending migrations in order
+func MigrateUp() error {
+	m, err := newMigrator()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("migrating up failed: %w", err)
+	}
+	return nil
+}
+
+// MigrateDown rolls back the most recently applied migrations
+func MigrateDown(steps int) error {
+	m, err := newMigrator()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	// Asking for more steps than were applied rolls back all of them
+	err = m.Steps(-steps)
+	var short migrate.ErrShortLimit
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) && !errors.As(err, &short) {
+		return fmt.Errorf("migrating down failed: %w", err)
+	}
+	return nil
+}
+
+// runMigrateCommand handles "migrate [up|down [n]|status|force <version>]"
+// from the command line
+func runMigrateCommand(args []string) error {
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	defer CloseDB()
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		return MigrateUp()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+			steps = n
+		}
+		return MigrateDown(steps)
+	case "status":
+		return printMigrationStatus()
+	case "force":
+		if len(args) < 2 {
+			return fmt.Errorf("migrate force needs a version")
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		m, err := newMigrator()
+		if err != nil {
+			return err
+		}
+		defer m.Close()
+		return m.Force(version)
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up, down, status, or force)", command)
+	}
+}
+
+// printMigrationStatus prints the schema version and whether the last
+// migration failed partway
+func printMigrationStatus() error {
+	m, err := newMigrator()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	version, dirty, err := m.Version()
+	switch {
+	case errors.Is(err, migrate.ErrNilVersion):
+		fmt.Println("no migrations applied")
+	case err != nil:
+		return err
+	case dirty:
+		fmt.Printf("version %d (dirty: it failed partway; fix the schema, then migrate force the last good version)\n", version)
+	default:
+		fmt.Printf("version %d\n", version)
+	}
+	return nil
+}
+
+// migrateLogger passes golang-migrate's progress messages to slog
+type migrateLogger struct{}
+
+func (migrateLogger) Printf(format string, v ...interface{}) {
+	slog.Info(strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+func (migrateLogger) Verbose() bool { return false }
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS tasks;
+DROP TABLE IF EXISTS match_recommendations;
+DROP TABLE IF EXISTS industry_profiles;
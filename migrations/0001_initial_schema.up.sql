@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS industry_profiles (
+	id VARCHAR(36) PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	location JSONB NOT NULL,
+	inputs JSONB NOT NULL,
+	outputs JSONB NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS match_recommendations (
+	id VARCHAR(36) PRIMARY KEY,
+	waste_id VARCHAR(255) NOT NULL,
+	producer_id VARCHAR(36) NOT NULL,
+	candidate_id VARCHAR(36) NOT NULL,
+	conversion_needed BOOLEAN NOT NULL,
+	conversion_description TEXT,
+	recommended_converter VARCHAR(50),
+	score FLOAT NOT NULL,
+	reasoning TEXT,
+	estimated_cost TEXT,
+	created_at TIMESTAMP NOT NULL,
+	confirmed BOOLEAN DEFAULT FALSE,
+	confirmed_at TIMESTAMP,
+	FOREIGN KEY (producer_id) REFERENCES industry_profiles(id),
+	FOREIGN KEY (candidate_id) REFERENCES industry_profiles(id)
+);
+
+CREATE TABLE IF NOT EXISTS tasks (
+	id VARCHAR(36) PRIMARY KEY,
+	status VARCHAR(50) NOT NULL,
+	type VARCHAR(50) NOT NULL,
+	file_url TEXT,
+	profile_id VARCHAR(36),
+	error TEXT,
+	result JSONB,
+	created_at TIMESTAMP NOT NULL,
+	completed_at TIMESTAMP,
+	FOREIGN KEY (profile_id) REFERENCES industry_profiles(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);
+CREATE INDEX IF NOT EXISTS idx_matches_producer ON match_recommendations(producer_id);
+CREATE INDEX IF NOT EXISTS idx_matches_candidate ON match_recommendations(candidate_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_matches_status;
+ALTER TABLE match_recommendations DROP COLUMN IF EXISTS status;
@@ -0,0 +1,3 @@
+ALTER TABLE match_recommendations ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'pending';
+UPDATE match_recommendations SET status = 'confirmed' WHERE confirmed = TRUE AND status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_matches_status ON match_recommendations(status);
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_profiles_outputs ON industry_profiles USING GIN (outputs jsonb_path_ops);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS waste_classifications (
+	waste_name VARCHAR(255) NOT NULL,
+	state VARCHAR(50) NOT NULL,
+	waste_type VARCHAR(255),
+	tags JSONB NOT NULL,
+	potential_uses JSONB NOT NULL,
+	classified_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (waste_name, state)
+);
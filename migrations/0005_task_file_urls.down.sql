@@ -0,0 +1 @@
+ALTER TABLE tasks DROP COLUMN IF EXISTS file_urls;
@@ -0,0 +1,4 @@
+-- Must match profileSearchVector in database.go for the planner to use it
+CREATE INDEX IF NOT EXISTS idx_profiles_search ON industry_profiles USING GIN ((setweight(to_tsvector('english', name), 'A') ||
+	jsonb_to_tsvector('english', inputs, '["string"]') ||
+	jsonb_to_tsvector('english', outputs, '["string"]')));
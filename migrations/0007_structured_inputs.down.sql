@@ -0,0 +1,10 @@
+-- Collapse input objects back to their names
+UPDATE industry_profiles
+SET inputs = (
+	SELECT COALESCE(jsonb_agg(
+		CASE WHEN jsonb_typeof(elem) = 'object' THEN elem -> 'name' ELSE elem END
+		ORDER BY ord), '[]'::jsonb)
+	FROM jsonb_array_elements(inputs) WITH ORDINALITY AS t(elem, ord)
+)
+WHERE jsonb_typeof(inputs) = 'array'
+	AND EXISTS (SELECT 1 FROM jsonb_array_elements(inputs) AS e(elem) WHERE jsonb_typeof(elem) = 'object');
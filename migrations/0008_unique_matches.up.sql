@@ -0,0 +1,13 @@
+-- Keep one match per producer/candidate/waste stream, preferring reviewed
+-- matches and then the oldest
+DELETE FROM match_recommendations m
+USING (
+	SELECT id, ROW_NUMBER() OVER (
+		PARTITION BY producer_id, candidate_id, waste_id
+		ORDER BY (status <> 'pending') DESC, created_at, id
+	) AS rn
+	FROM match_recommendations
+) ranked
+WHERE m.id = ranked.id AND ranked.rn > 1;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_matches_unique ON match_recommendations(producer_id, candidate_id, waste_id);
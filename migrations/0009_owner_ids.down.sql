@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_tasks_owner;
+ALTER TABLE tasks DROP COLUMN IF EXISTS owner_id;
+
+DROP INDEX IF EXISTS idx_profiles_owner;
+ALTER TABLE industry_profiles DROP COLUMN IF EXISTS owner_id;
@@ -0,0 +1,7 @@
+-- Profiles and tasks belong to the API principal that created them. Rows
+-- created before ownership existed have no owner and are visible to admins only.
+ALTER TABLE industry_profiles ADD COLUMN IF NOT EXISTS owner_id VARCHAR(255);
+CREATE INDEX IF NOT EXISTS idx_profiles_owner ON industry_profiles(owner_id);
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS owner_id VARCHAR(255);
+CREATE INDEX IF NOT EXISTS idx_tasks_owner ON tasks(owner_id);
@@ -0,0 +1,3 @@
+ALTER TABLE match_recommendations DROP COLUMN IF EXISTS converter_ids;
+
+DROP TABLE IF EXISTS converters;
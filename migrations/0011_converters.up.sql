@@ -0,0 +1,16 @@
+-- Directory of third-party companies that convert waste into usable inputs.
+-- waste_types holds normalized waste names, types, and tags they accept.
+CREATE TABLE IF NOT EXISTS converters (
+	id VARCHAR(36) PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	waste_types JSONB NOT NULL DEFAULT '[]',
+	conversion_types JSONB NOT NULL DEFAULT '[]',
+	location JSONB,
+	contact TEXT,
+	website TEXT,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_converters_waste_types ON converters USING GIN (waste_types);
+
+ALTER TABLE match_recommendations ADD COLUMN IF NOT EXISTS converter_ids JSONB;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_profiles_needs_review;
+ALTER TABLE industry_profiles DROP COLUMN IF EXISTS needs_review;
+ALTER TABLE industry_profiles DROP COLUMN IF EXISTS extraction_confidence;
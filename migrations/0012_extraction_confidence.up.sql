@@ -0,0 +1,5 @@
+-- How much the extractor trusted a profile's extraction, and whether a person
+-- should check it. Existing profiles are treated as trusted.
+ALTER TABLE industry_profiles ADD COLUMN IF NOT EXISTS extraction_confidence DOUBLE PRECISION NOT NULL DEFAULT 1;
+ALTER TABLE industry_profiles ADD COLUMN IF NOT EXISTS needs_review BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS idx_profiles_needs_review ON industry_profiles(created_at) WHERE needs_review;
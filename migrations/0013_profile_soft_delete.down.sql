@@ -0,0 +1,5 @@
+DELETE FROM match_recommendations WHERE producer_id IN (SELECT id FROM industry_profiles WHERE deleted_at IS NOT NULL)
+	OR candidate_id IN (SELECT id FROM industry_profiles WHERE deleted_at IS NOT NULL);
+UPDATE tasks SET profile_id = NULL WHERE profile_id IN (SELECT id FROM industry_profiles WHERE deleted_at IS NOT NULL);
+DELETE FROM industry_profiles WHERE deleted_at IS NOT NULL;
+ALTER TABLE industry_profiles DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,3 @@
+-- Deleted profiles keep their row, and their confirmed and rejected matches,
+-- for audit history; the API and matching ignore them
+ALTER TABLE industry_profiles ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
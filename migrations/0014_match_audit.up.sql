@@ -0,0 +1,11 @@
+-- Who confirmed, unconfirmed, or rejected each match, and when. Entries have
+-- no foreign key so they outlive the matches they describe.
+CREATE TABLE IF NOT EXISTS match_audit (
+	id BIGSERIAL PRIMARY KEY,
+	match_id VARCHAR(36) NOT NULL,
+	action VARCHAR(20) NOT NULL,
+	actor VARCHAR(255),
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_match_audit_match ON match_audit(match_id, created_at);
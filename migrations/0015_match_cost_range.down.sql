@@ -0,0 +1,3 @@
+ALTER TABLE match_recommendations DROP COLUMN IF EXISTS cost_currency;
+ALTER TABLE match_recommendations DROP COLUMN IF EXISTS cost_high;
+ALTER TABLE match_recommendations DROP COLUMN IF EXISTS cost_low;
@@ -0,0 +1,5 @@
+-- Numeric conversion cost estimates alongside the free-text estimated_cost,
+-- so costs can be totalled. NULL when the model gave no usable range.
+ALTER TABLE match_recommendations ADD COLUMN IF NOT EXISTS cost_low DOUBLE PRECISION;
+ALTER TABLE match_recommendations ADD COLUMN IF NOT EXISTS cost_high DOUBLE PRECISION;
+ALTER TABLE match_recommendations ADD COLUMN IF NOT EXISTS cost_currency VARCHAR(3);
@@ -0,0 +1 @@
+ALTER TABLE match_recommendations DROP COLUMN IF EXISTS consumer_reasoning;
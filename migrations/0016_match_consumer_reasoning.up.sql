@@ -0,0 +1,2 @@
+-- Match reasoning written for the candidate; reasoning is written for the producer
+ALTER TABLE match_recommendations ADD COLUMN IF NOT EXISTS consumer_reasoning TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS match_llm_responses;
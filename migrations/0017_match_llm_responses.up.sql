@@ -0,0 +1,8 @@
+-- Raw model responses matches were built from, kept for auditing when
+-- DEBUG_STORE_LLM is set
+CREATE TABLE IF NOT EXISTS match_llm_responses (
+	match_id VARCHAR(36) PRIMARY KEY REFERENCES match_recommendations(id) ON DELETE CASCADE,
+	response TEXT NOT NULL,
+	truncated BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE industry_profiles DROP COLUMN IF EXISTS version;
@@ -0,0 +1,2 @@
+-- Incremented on every profile write, so concurrent edits can be detected
+ALTER TABLE industry_profiles ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1 @@
+ALTER TABLE match_recommendations DROP COLUMN IF EXISTS beyond_max_distance;
@@ -0,0 +1,3 @@
+-- Set on matches whose profiles are further apart than MAX_MATCH_DISTANCE_KM,
+-- e.g. made before the cap was configured; they are kept, not deleted
+ALTER TABLE match_recommendations ADD COLUMN IF NOT EXISTS beyond_max_distance BOOLEAN NOT NULL DEFAULT FALSE;
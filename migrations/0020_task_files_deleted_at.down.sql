@@ -0,0 +1 @@
+ALTER TABLE tasks DROP COLUMN IF EXISTS files_deleted_at;
@@ -0,0 +1,4 @@
+-- Set once the upload cleanup job has released a task's files, i.e. the task
+-- failed or its profile was deleted more than UPLOAD_RETENTION ago. Files no
+-- other task still needs are deleted from storage at that point.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS files_deleted_at TIMESTAMP;
@@ -0,0 +1,14 @@
+-- Match watermark: when the last complete match run for the profile started.
+-- Every profile created or updated before then has been evaluated as a
+-- candidate, so later runs only need to look at newer ones.
+ALTER TABLE industry_profiles ADD COLUMN IF NOT EXISTS matched_at TIMESTAMP;
+
+-- Start existing profiles from their last whole-profile run that completed
+-- without failed waste streams
+UPDATE industry_profiles p SET matched_at = (
+	SELECT MAX(t.created_at) FROM tasks t
+	WHERE t.profile_id = p.id AND t.type = 'match_generation' AND t.status = 'completed'
+		AND NOT COALESCE(t.result ? 'scope' OR t.result ? 'needs_review', FALSE)
+		AND COALESCE((t.result->>'failed_streams')::int, 0) = 0
+)
+WHERE matched_at IS NULL;
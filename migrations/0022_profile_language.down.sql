@@ -0,0 +1,3 @@
+ALTER TABLE tasks DROP COLUMN IF EXISTS language;
+ALTER TABLE industry_profiles DROP COLUMN IF EXISTS document_language;
+ALTER TABLE industry_profiles DROP COLUMN IF EXISTS language;
@@ -0,0 +1,10 @@
+-- Language a profile's extracted names and its side of match reasoning are
+-- written in, as a BCP 47 tag; existing profiles were all in English
+ALTER TABLE industry_profiles ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT 'en';
+
+-- Language the profile's documents were detected to be written in, when the
+-- LLM extracted them
+ALTER TABLE industry_profiles ADD COLUMN IF NOT EXISTS document_language TEXT;
+
+-- Language requested for a document upload, applied to the profile it creates
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS language TEXT;
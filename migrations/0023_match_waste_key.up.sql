@@ -0,0 +1,14 @@
+-- Classification cache key of the match's waste stream (its canonical name),
+-- so stats can join waste_classifications directly. New matches get it from
+-- the application; existing rows are backfilled once from the producer's
+-- canonical name, or the whitespace-folded, lowercased stream name
+ALTER TABLE match_recommendations ADD COLUMN IF NOT EXISTS waste_key TEXT;
+
+UPDATE match_recommendations m SET waste_key = COALESCE(
+	(SELECT o->>'canonical_name'
+	 FROM industry_profiles p,
+		jsonb_array_elements(CASE WHEN jsonb_typeof(p.outputs) = 'array' THEN p.outputs ELSE '[]' END) o
+	 WHERE p.id = m.producer_id AND o->>'name' = m.waste_id AND o->>'canonical_name' <> ''
+	 LIMIT 1),
+	lower(regexp_replace(btrim(m.waste_id), '\s+', ' ', 'g')))
+WHERE waste_key IS NULL;
@@ -0,0 +1,19 @@
+-- Put back the IDs matches had before they were re-keyed, taking the latest
+-- rewrite where one derived ID replaced several
+UPDATE match_recommendations m SET id = r.old_id
+FROM (
+	SELECT DISTINCT ON (new_id) old_id, new_id FROM match_id_rekeys ORDER BY new_id, rekeyed_at DESC
+) r
+WHERE m.id = r.new_id;
+
+UPDATE match_audit a SET match_id = r.old_id
+FROM (
+	SELECT DISTINCT ON (new_id) old_id, new_id FROM match_id_rekeys ORDER BY new_id, rekeyed_at DESC
+) r
+WHERE a.match_id = r.new_id;
+
+ALTER TABLE match_llm_responses DROP CONSTRAINT IF EXISTS match_llm_responses_match_id_fkey;
+ALTER TABLE match_llm_responses ADD CONSTRAINT match_llm_responses_match_id_fkey
+	FOREIGN KEY (match_id) REFERENCES match_recommendations(id) ON DELETE CASCADE;
+
+DROP TABLE IF EXISTS match_id_rekeys;
@@ -0,0 +1,15 @@
+-- Matches made before IDs were derived from producer, candidate and waste
+-- stream are given their derived IDs by the application at startup (in
+-- Go, as Postgres has no UUIDv5), which records each rewrite here so the
+-- down migration can put the old IDs back. Stored model responses follow
+-- their match's ID through the foreign key; audit entries are moved along
+-- with it.
+CREATE TABLE IF NOT EXISTS match_id_rekeys (
+	old_id VARCHAR(36) PRIMARY KEY,
+	new_id VARCHAR(36) NOT NULL,
+	rekeyed_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE match_llm_responses DROP CONSTRAINT IF EXISTS match_llm_responses_match_id_fkey;
+ALTER TABLE match_llm_responses ADD CONSTRAINT match_llm_responses_match_id_fkey
+	FOREIGN KEY (match_id) REFERENCES match_recommendations(id) ON DELETE CASCADE ON UPDATE CASCADE;
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// TestEmbeddedMigrations checks that the embedded migrations run 1, 2, 3, ...
+// with no gaps, and that every one has a down file
+func TestEmbeddedMigrations(t *testing.T) {
+	source, err := iofs.New(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	version, err := source.First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for want := uint(1); ; want++ {
+		if version != want {
+			t.Fatalf("migration %d follows %d, want %d", version, want-1, want)
+		}
+		if _, _, err := source.ReadDown(version); err != nil {
+			t.Errorf("migration %d has no down file: %v", version, err)
+		}
+
+		version, err = source.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestAdoptLegacyVersion migrates a database whose schema the runner used
+// before golang-migrate recorded, and checks that nothing is applied twice
+func TestAdoptLegacyVersion(t *testing.T) {
+	openTestDB(t)
+	if err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	var latest int
+	if err := currentDB().QueryRow(`SELECT version FROM ` + migrationsTable).Scan(&latest); err != nil {
+		t.Fatal(err)
+	}
+
+	// Turn the database into one the old runner migrated
+	_, err := currentDB().Exec(`
+		DROP TABLE ` + migrationsTable + `;
+		CREATE TABLE ` + legacyMigrationsTable + ` (version INTEGER PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = currentDB().Exec(`INSERT INTO `+legacyMigrationsTable+` SELECT v, 'migration', NOW() FROM generate_series(1, $1) v`, latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MigrateUp(); err != nil {
+		t.Fatalf("MigrateUp over the legacy table: %v", err)
+	}
+
+	var version int
+	var dirty bool
+	if err := currentDB().QueryRow(`SELECT version, dirty FROM `+migrationsTable).Scan(&version, &dirty); err != nil {
+		t.Fatal(err)
+	}
+	if version != latest || dirty {
+		t.Errorf("schema version = %d (dirty %v), want %d", version, dirty, latest)
+	}
+	var legacy bool
+	if err := currentDB().QueryRow(`SELECT to_regclass($1) IS NOT NULL`, legacyMigrationsTable).Scan(&legacy); err != nil {
+		t.Fatal(err)
+	}
+	if legacy {
+		t.Errorf("%s is still there after adoption", legacyMigrationsTable)
+	}
+}
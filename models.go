@@ -1,6 +1,10 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,12 +16,87 @@ type Location struct {
 	Lng float64 `json:"lng"`
 }
 
+// ErrInvalidLocation is returned for coordinates outside the valid ranges
+var ErrInvalidLocation = errors.New("location must have lat within [-90, 90] and lng within [-180, 180]")
+
+// Validate checks that the coordinates are real latitudes and longitudes
+func (l Location) Validate() error {
+	if math.IsNaN(l.Lat) || math.IsNaN(l.Lng) || l.Lat < -90 || l.Lat > 90 || l.Lng < -180 || l.Lng > 180 {
+		return ErrInvalidLocation
+	}
+	return nil
+}
+
+// IsUnknown reports whether the location is unset. Profiles whose documents
+// don't mention a location get (0, 0), so distances from it are meaningless.
+func (l Location) IsUnknown() bool {
+	return l.Lat == 0 && l.Lng == 0
+}
+
 // Output represents an output stream from an industry
 type Output struct {
-	Name     string  `json:"name"`
-	State    string  `json:"state"` // solid, liquid, gas
-	Quantity string  `json:"quantity"`
-	Tags     []string `json:"tags,omitempty"`
+	Name          string    `json:"name"`
+	CanonicalName string    `json:"canonical_name,omitempty"` // Name mapped onto the material vocabulary
+	State         string    `json:"state"`                    // solid, liquid, gas, sludge, other
+	Quantity      string    `json:"quantity"`                 // raw text as extracted, for display
+	Amount        *Quantity `json:"amount,omitempty"`         // parsed from Quantity when possible
+	Tags          []string  `json:"tags,omitempty"`
+}
+
+// Input represents a material or resource an industry consumes
+type Input struct {
+	Name          string    `json:"name"`
+	CanonicalName string    `json:"canonical_name,omitempty"` // Name mapped onto the material vocabulary
+	States        []string  `json:"states,omitempty"`         // acceptable forms: solid, liquid, gas, sludge, other; empty means any
+	Quantity      string    `json:"quantity,omitempty"`       // raw text of the amount needed, for display
+	Amount        *Quantity `json:"amount,omitempty"`         // parsed from Quantity (or Name) when possible
+	Tags          []string  `json:"tags,omitempty"`
+}
+
+// UnmarshalJSON accepts either an Input object or a bare string, which older
+// profiles and the Python worker use, promoting the string to Input{Name: s}
+func (in *Input) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*in = Input{Name: name}
+		return nil
+	}
+
+	type input Input // avoids recursing into this method
+	return json.Unmarshal(data, (*input)(in))
+}
+
+// String describes an input for prompts, e.g. "fly ash [solid] (20 t/month)"
+func (in Input) String() string {
+	s := in.Name
+	if len(in.States) > 0 {
+		s += " [" + strings.Join(in.States, "/") + "]"
+	}
+	if in.Amount != nil {
+		s += " (" + in.Amount.String() + ")"
+	} else if in.Quantity != "" {
+		s += " (" + in.Quantity + ")"
+	}
+	return s
+}
+
+// String renders an output for display, e.g. "fly ash [solid] (5 t/day)"
+func (o Output) String() string {
+	s := o.Name
+	if o.State != "" {
+		s += " [" + o.State + "]"
+	}
+	if q := o.displayQuantity(); q != "" {
+		s += " (" + q + ")"
+	}
+	return s
+}
+
+// Quantity is a structured amount such as 5 t per_day
+type Quantity struct {
+	Value  float64 `json:"value"`
+	Unit   string  `json:"unit"`             // canonical unit, e.g. kg, t, l, m3
+	Period string  `json:"period,omitempty"` // per_hour, per_day, per_week, per_month, per_year
 }
 
 // IndustryProfile represents a company's I/O profile
@@ -25,10 +104,109 @@ type IndustryProfile struct {
 	ID        string    `json:"id"`
 	Name      string    `json:"name"`
 	Location  Location  `json:"location"`
-	Inputs    []string  `json:"inputs"`
+	Inputs    []Input   `json:"inputs"`
 	Outputs   []Output  `json:"outputs"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	OwnerID   string    `json:"owner_id,omitempty"` // principal that created the profile
+
+	// BCP 47 tag of the language extracted names and this profile's side of
+	// match reasoning are written in, and the language its documents were
+	// detected to be in when the LLM extracted them
+	Language         string `json:"language"`
+	DocumentLanguage string `json:"document_language,omitempty"`
+
+	// How much the extractor trusted its reading of the documents, from 0 to
+	// 1. Profiles below EXTRACTION_REVIEW_THRESHOLD need review by a person.
+	ExtractionConfidence float64 `json:"extraction_confidence"`
+	NeedsReview          bool    `json:"needs_review"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // set when soft-deleted
+	Version   int        `json:"version"`              // incremented on every save
+
+	// When the last complete match run started. Profiles created or updated
+	// since are the only candidates a later incremental run evaluates.
+	MatchedAt *time.Time `json:"matched_at,omitempty"`
+}
+
+// NearbyProfile is a profile along with its distance from a search point
+type NearbyProfile struct {
+	*IndustryProfile
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// ReviewProfile is a profile awaiting review along with the documents it was
+// extracted from
+type ReviewProfile struct {
+	*IndustryProfile
+	DocumentURLs []string `json:"document_urls"`
+}
+
+// ProfileRequest is the request body for creating or updating a profile
+type ProfileRequest struct {
+	Name     string          `json:"name" binding:"required"`
+	Location LocationRequest `json:"location"`
+	Inputs   []InputRequest  `json:"inputs" binding:"dive"`
+	Outputs  []OutputRequest `json:"outputs" binding:"required,min=1,dive"`
+	Language string          `json:"language"` // optional; the profile keeps its language if empty
+}
+
+// LocationRequest is a location in a request body
+type LocationRequest struct {
+	Lat float64 `json:"lat" binding:"gte=-90,lte=90"`
+	Lng float64 `json:"lng" binding:"gte=-180,lte=180"`
+}
+
+func (r LocationRequest) Location() Location {
+	return Location{Lat: r.Lat, Lng: r.Lng}
+}
+
+// OutputRequest is a waste stream in a request body, with the fields of
+// Output a client may set
+type OutputRequest struct {
+	Name          string    `json:"name" binding:"required"`
+	CanonicalName string    `json:"canonical_name"`
+	State         string    `json:"state"`
+	Quantity      string    `json:"quantity"`
+	Amount        *Quantity `json:"amount"`
+	Tags          []string  `json:"tags"`
+}
+
+func (r OutputRequest) Output() Output {
+	return Output{Name: r.Name, CanonicalName: r.CanonicalName, State: r.State, Quantity: r.Quantity, Amount: r.Amount, Tags: r.Tags}
+}
+
+// InputRequest is an input in a request body, with the fields of Input a
+// client may set. Unlike Input, it must be an object.
+type InputRequest struct {
+	Name          string    `json:"name" binding:"required"`
+	CanonicalName string    `json:"canonical_name"`
+	States        []string  `json:"states"`
+	Quantity      string    `json:"quantity"`
+	Amount        *Quantity `json:"amount"`
+	Tags          []string  `json:"tags"`
+}
+
+func (r InputRequest) Input() Input {
+	return Input{Name: r.Name, CanonicalName: r.CanonicalName, States: r.States, Quantity: r.Quantity, Amount: r.Amount, Tags: r.Tags}
+}
+
+// outputs converts the request's waste streams
+func (r ProfileRequest) outputs() []Output {
+	outputs := make([]Output, len(r.Outputs))
+	for i, output := range r.Outputs {
+		outputs[i] = output.Output()
+	}
+	return outputs
+}
+
+// inputs converts the request's inputs
+func (r ProfileRequest) inputs() []Input {
+	inputs := make([]Input, len(r.Inputs))
+	for i, input := range r.Inputs {
+		inputs[i] = input.Input()
+	}
+	return inputs
 }
 
 // MatchRecommendation represents a potential symbiotic match
@@ -41,26 +219,208 @@ type MatchRecommendation struct {
 	ConversionDescription  string    `json:"conversion_description,omitempty"`
 	RecommendedConverter   string    `json:"recommended_converter"` // producer, consumer, third-party
 	Score                  float64   `json:"score"`
-	Reasoning              string    `json:"reasoning"`
+	Reasoning              string    `json:"reasoning"`                    // written for the producer
+	ConsumerReasoning      string    `json:"consumer_reasoning,omitempty"` // written for the candidate; empty if unavailable
 	EstimatedCost          string    `json:"estimated_cost,omitempty"`
+	EstimatedCostRange     *CostRange `json:"estimated_cost_range,omitempty"` // numeric form of EstimatedCost, when the model gave one
 	CreatedAt              time.Time `json:"created_at"`
 	Confirmed              bool      `json:"confirmed"`
 	ConfirmedAt            *time.Time `json:"confirmed_at,omitempty"`
+	Status                 string    `json:"status"` // pending, confirmed, rejected
+	ConverterIDs           []string  `json:"converter_ids,omitempty"` // suggested third-party converters
+	BeyondMaxDistance      bool      `json:"beyond_max_distance,omitempty"` // profiles now further apart than MAX_MATCH_DISTANCE_KM
+
+	llmResponse *LLMResponse // saved alongside the match when set
+	wasteKey    string       // classification cache key of the waste stream; normalizeKey(WasteID) when empty
+	noMatch     bool         // scored for comparison only, e.g. by evaluatePairHeuristic; never saved
+}
+
+// LLMResponse holds the parts of the model responses a match was built from,
+// as a JSON matchAudit, stored for auditing when DEBUG_STORE_LLM is set
+type LLMResponse struct {
+	Response  string    `json:"response"`
+	Truncated bool      `json:"truncated"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MatchDetail is a match along with the names of the profiles involved
+type MatchDetail struct {
+	*MatchRecommendation
+	ProducerName  string       `json:"producer_name"`
+	CandidateName string       `json:"candidate_name"`
+	Converters    []*Converter `json:"converters,omitempty"`
+	LLMResponse   *LLMResponse `json:"llm_response,omitempty"` // admins only
+
+	producerOwnerID  string
+	candidateOwnerID string
+}
+
+// Recommended converter roles
+const (
+	ConverterProducer   = "producer"
+	ConverterConsumer   = "consumer"
+	ConverterThirdParty = "third-party"
+)
+
+// Perspectives a match explanation is written from
+const (
+	PerspectiveProducer = "producer"
+	PerspectiveConsumer = "consumer"
+)
+
+// Converter is a third-party company in the converter registry that turns
+// waste into usable inputs
+type Converter struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	WasteTypes      []string  `json:"waste_types"`      // waste names, types, or tags accepted
+	ConversionTypes []string  `json:"conversion_types"` // processes offered, e.g. "drying", "pelletizing"
+	Location        *Location `json:"location,omitempty"`
+	Contact         string    `json:"contact,omitempty"`
+	Website         string    `json:"website,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// ConverterRequest is the body for registering a converter
+type ConverterRequest struct {
+	Name            string    `json:"name" binding:"required"`
+	WasteTypes      []string  `json:"waste_types" binding:"required,min=1"`
+	ConversionTypes []string  `json:"conversion_types"`
+	Location        *Location `json:"location"`
+	Contact         string    `json:"contact"`
+	Website         string    `json:"website"`
+}
+
+// SymbiosisStats summarizes profiles and matches for the dashboard
+type SymbiosisStats struct {
+	Profiles      int              `json:"profiles"`
+	WasteStreams  int              `json:"waste_streams"`
+	Matches       MatchStats       `json:"matches"`
+	TopWasteTypes []WasteTypeCount `json:"top_waste_types"`
+	Diversion     DiversionStats   `json:"estimated_diversion"`
+	Investment    InvestmentStats  `json:"estimated_investment"`
+}
+
+// MatchStats counts matches by review status
+type MatchStats struct {
+	Total                 int     `json:"total"`
+	Pending               int     `json:"pending"`
+	Confirmed             int     `json:"confirmed"`
+	Rejected              int     `json:"rejected"`
+	AverageScore          float64 `json:"average_score"`
+	AverageConfirmedScore float64 `json:"average_confirmed_score"`
+}
+
+// SymbiosisGraph is the network of profiles linked by matches, shaped for a
+// force-directed graph
+type SymbiosisGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode is a profile in the symbiosis graph
+type GraphNode struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// GraphEdge is a match from a producer to a candidate, weighted by score
+type GraphEdge struct {
+	ID        string  `json:"id"`     // match ID
+	Source    string  `json:"source"` // producer profile ID
+	Target    string  `json:"target"` // candidate profile ID
+	Waste     string  `json:"waste"`
+	Weight    float64 `json:"weight"`
+	Confirmed bool    `json:"confirmed"`
 }
 
+// MaterialCount is the number of profiles naming a material
+type MaterialCount struct {
+	Name     string `json:"name"`
+	Profiles int    `json:"profiles"`
+}
+
+// WasteTypeCount is the number of matches for one classified waste type
+type WasteTypeCount struct {
+	WasteType string `json:"waste_type"`
+	Matches   int    `json:"matches"`
+	Confirmed int    `json:"confirmed"`
+}
+
+// DiversionStats estimates how much waste confirmed matches divert, from the
+// quantities of the waste streams with at least one confirmed match
+type DiversionStats struct {
+	PerDay              map[string]float64 `json:"per_day"` // by base unit: kg, l, kwh
+	WasteStreams        int                `json:"waste_streams"`
+	UnquantifiedStreams int                `json:"unquantified_streams"` // no usable amount and period
+}
+
+// Match review statuses
+const (
+	MatchStatusPending   = "pending"
+	MatchStatusConfirmed = "confirmed"
+	MatchStatusRejected  = "rejected"
+)
+
+// MatchAuditEntry records one review decision on a match
+type MatchAuditEntry struct {
+	ID        int64     `json:"id"`
+	MatchID   string    `json:"match_id"`
+	Action    string    `json:"action"`          // confirmed, unconfirmed, rejected, deleted
+	Actor     string    `json:"actor,omitempty"` // principal that made the decision; empty when auth is disabled
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Match audit actions
+const (
+	MatchAuditConfirmed   = "confirmed"
+	MatchAuditUnconfirmed = "unconfirmed"
+	MatchAuditRejected    = "rejected"
+	MatchAuditDeleted     = "deleted"
+)
+
 // Task represents an asynchronous processing task
 type Task struct {
 	ID          string    `json:"id"`
 	Status      string    `json:"status"` // pending, processing, completed, failed
 	Type        string    `json:"type"`   // document_parse, match_generation
 	FileURL     string    `json:"file_url,omitempty"`
+	FileURLs    []string  `json:"file_urls,omitempty"` // all documents when several were uploaded together
 	ProfileID   string    `json:"profile_id,omitempty"`
+	OwnerID     string    `json:"owner_id,omitempty"` // principal that created the task
+	CallbackURL string    `json:"callback_url,omitempty"` // receives the task JSON when it finishes
+	Language    string    `json:"language,omitempty"`     // requested for the profile a document_parse task creates
 	Error       string    `json:"error,omitempty"`
 	Result      interface{} `json:"result,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 	CompletedAt *time.Time `json:"completed_at,omitempty"`
 }
 
+// WasteStreamOutcome reports how matching went for one waste stream, in a
+// match_generation task's result
+type WasteStreamOutcome struct {
+	Waste      string `json:"waste"`
+	ProducerID string `json:"producer_id"`
+	Status     string `json:"status"`  // ok, partial, skipped, failed
+	Matches    int    `json:"matches"` // matches saved from this stream
+	Error      string `json:"error,omitempty"`
+}
+
+// Waste stream outcome statuses
+const (
+	StreamOK      = "ok"
+	StreamPartial = "partial" // some candidates couldn't be assessed
+	StreamSkipped = "skipped" // the model declined to evaluate the stream
+	StreamFailed  = "failed"
+)
+
+// UploadedFile is a stored document awaiting parsing
+type UploadedFile struct {
+	URL      string `json:"file_url"`
+	Filename string `json:"filename"`
+	Existed  bool   `json:"-"` // identical content was already stored
+}
+
 // MCPToolCall represents a call to an MCP tool
 type MCPToolCall struct {
 	Tool   string                 `json:"tool"`
@@ -74,17 +434,82 @@ type MCPToolResponse struct {
 	Error   string                 `json:"error,omitempty"`
 }
 
+// ExtractedProfile is the structured result of ExtractIO
+type ExtractedProfile struct {
+	Name     string `json:"name"`
+	Location struct {
+		Lat  float64 `json:"lat"`
+		Lng  float64 `json:"lng"`
+		City string  `json:"city,omitempty"`
+	} `json:"location"`
+	Inputs     []Input  `json:"inputs"`
+	Outputs    []Output `json:"outputs"`
+	Confidence float64  `json:"confidence"` // the model's own rating, 0 to 1
+	Language   string   `json:"language"`   // ISO 639-1 code of the text's language
+}
+
+// InvestmentStats totals the estimated conversion costs of confirmed matches.
+// Amounts in different currencies are kept apart rather than converted.
+type InvestmentStats struct {
+	ByCurrency      []CostTotal `json:"by_currency"`
+	UnpricedMatches int         `json:"unpriced_matches"` // confirmed matches with no numeric estimate
+}
+
+// CostTotal sums the cost ranges of matches estimated in one currency
+type CostTotal struct {
+	Currency string  `json:"currency"`
+	Low      float64 `json:"low"`
+	High     float64 `json:"high"`
+	Matches  int     `json:"matches"`
+}
+
+// WasteClassification is the structured result of ClassifyWaste
+type WasteClassification struct {
+	WasteType     string   `json:"waste_type"`
+	Tags          []string `json:"tags"`
+	PotentialUses []string `json:"potential_uses"`
+}
+
+// ConversionEstimate is the structured result of EstimateConversion
+type ConversionEstimate struct {
+	ConversionNeeded     bool   `json:"conversion_needed"`
+	Description          string `json:"description"`
+	RecommendedConverter string `json:"recommended_converter"` // producer, consumer, third-party
+	EstimatedCost        string     `json:"estimated_cost"`
+	CostRange            *CostRange `json:"cost_range,omitempty"`
+	Complexity           string     `json:"complexity"` // low, medium, high
+
+	rawResponse json.RawMessage // the model's JSON for this estimate, kept when DEBUG_STORE_LLM is on
+}
+
+// CostRange is a numeric conversion cost estimate, e.g. 10000 to 50000 USD
+type CostRange struct {
+	Low      float64 `json:"low"`
+	High     float64 `json:"high"`
+	Currency string  `json:"currency"` // ISO 4217 code
+}
+
+// CandidateConversion is one candidate's entry in the result of EstimateConversions
+type CandidateConversion struct {
+	Index int `json:"index"` // position of the candidate in the prompt's list
+	ConversionEstimate
+	Reasoning         string `json:"reasoning"`
+	ConsumerReasoning string `json:"consumer_reasoning"`
+}
+
 // NewIndustryProfile creates a new industry profile with generated ID
-func NewIndustryProfile(name string, location Location, inputs []string, outputs []Output) *IndustryProfile {
+func NewIndustryProfile(name string, location Location, inputs []Input, outputs []Output) *IndustryProfile {
 	now := time.Now()
 	return &IndustryProfile{
-		ID:        uuid.New().String(),
-		Name:      name,
-		Location:  location,
-		Inputs:    inputs,
-		Outputs:   outputs,
-		CreatedAt: now,
-		UpdatedAt: now,
+		ID:                   uuid.New().String(),
+		Name:                 name,
+		Location:             location,
+		Inputs:               inputs,
+		Outputs:              outputs,
+		ExtractionConfidence: 1,
+		Language:             defaultLanguage(),
+		CreatedAt:            now,
+		UpdatedAt:            now,
 	}
 }
 
@@ -98,14 +523,60 @@ func NewTask(taskType string) *Task {
 	}
 }
 
-// NewMatchRecommendation creates a new match recommendation
+// NewConverter creates a registry converter with generated ID, normalizing
+// its waste types so they can be matched against waste streams
+func NewConverter(req ConverterRequest) *Converter {
+	wasteTypes := make([]string, 0, len(req.WasteTypes))
+	for _, wasteType := range req.WasteTypes {
+		if key := normalizeKey(wasteType); key != "" {
+			wasteTypes = append(wasteTypes, key)
+		}
+	}
+	conversionTypes := req.ConversionTypes
+	if conversionTypes == nil {
+		conversionTypes = []string{}
+	}
+
+	return &Converter{
+		ID:              uuid.New().String(),
+		Name:            req.Name,
+		WasteTypes:      wasteTypes,
+		ConversionTypes: conversionTypes,
+		Location:        req.Location,
+		Contact:         req.Contact,
+		Website:         req.Website,
+		CreatedAt:       time.Now(),
+	}
+}
+
+// matchIDNamespace is the UUIDv5 namespace of deterministic match IDs
+var matchIDNamespace = uuid.MustParse("a0475b80-2ee5-4bf2-84c2-a8534d9fe4c3")
+
+// randomMatchIDs is set by InitMatching when MATCH_ID_MODE is random
+var randomMatchIDs bool
+
+// MatchID returns the ID of the match offering a producer's waste stream to
+// a candidate: a UUIDv5 in matchIDNamespace of
+// "<producer_id>/<candidate_id>/<waste_id>", so regenerating a match yields
+// the same ID and clients can work out match URLs. With MATCH_ID_MODE=random
+// every new match gets a random ID instead.
+func MatchID(producerID, candidateID, wasteID string) string {
+	if randomMatchIDs {
+		return uuid.New().String()
+	}
+	return uuid.NewSHA1(matchIDNamespace, []byte(producerID+"/"+candidateID+"/"+wasteID)).String()
+}
+
+// NewMatchRecommendation creates a new match recommendation, identified by
+// MatchID
 func NewMatchRecommendation(wasteID, producerID, candidateID string) *MatchRecommendation {
 	return &MatchRecommendation{
-		ID:          uuid.New().String(),
+		ID:          MatchID(producerID, candidateID, wasteID),
 		WasteID:     wasteID,
 		ProducerID:  producerID,
 		CandidateID: candidateID,
 		CreatedAt:   time.Now(),
 		Confirmed:   false,
+		Status:      MatchStatusPending,
 	}
 }
\ No newline at end of file
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// OpenAIProvider calls OpenAI's chat completions API
+type OpenAIProvider struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+// NewOpenAIProvider creates an OpenAI provider sending requests through
+// httpClient. OPENAI_BASE_URL points it at a compatible API instead.
+func NewOpenAIProvider(apiKey string, httpClient *http.Client) *OpenAIProvider {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	baseURL := os.Getenv("OPENAI_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	return &OpenAIProvider{
+		apiKey:  apiKey,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  httpClient,
+	}
+}
+
+func (o *OpenAIProvider) Name() string { return "openai" }
+
+func (o *OpenAIProvider) DefaultModel() string { return "gpt-4o-mini" }
+
+// openAIWrapKey holds array responses, since OpenAI's response schemas must
+// be objects at the top level
+const openAIWrapKey = "items"
+
+// Generate makes a single chat completion call to OpenAI. OpenAI has no
+// top-k sampling, so params.TopK is ignored.
+func (o *OpenAIProvider) Generate(ctx context.Context, model, prompt string, schema map[string]interface{}, params GenerationParams) (string, error) {
+	requestBody := map[string]interface{}{
+		"model": model,
+		"messages": []map[string]string{
+			{"role": "user", "content": prompt},
+		},
+		"temperature": params.Temperature,
+		"top_p":       params.TopP,
+		"max_tokens":  params.MaxOutputTokens,
+	}
+
+	wrapped := false
+	if schema != nil {
+		jsonSchema := openAISchema(schema)
+		if jsonSchema["type"] == "array" {
+			jsonSchema = map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{openAIWrapKey: jsonSchema},
+				"required":   []string{openAIWrapKey},
+			}
+			wrapped = true
+		}
+		requestBody["response_format"] = map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   "response",
+				"schema": jsonSchema,
+			},
+		}
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+o.apiKey)
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call OpenAI API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", &LLMAPIError{
+			Provider:   "OpenAI",
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var response struct {
+		Choices []struct {
+			Message struct {
+				Content *string `json:"content"`
+				Refusal string  `json:"refusal"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("unexpected response format from OpenAI API")
+	}
+
+	choice := response.Choices[0]
+	if choice.Message.Refusal != "" {
+		return "", fmt.Errorf("%w: refused (%s)", ErrContentBlocked, choice.Message.Refusal)
+	}
+	if choice.FinishReason == "content_filter" {
+		return "", fmt.Errorf("%w: finish reason content_filter", ErrContentBlocked)
+	}
+	if choice.Message.Content == nil {
+		return "", fmt.Errorf("unexpected response format from OpenAI API")
+	}
+
+	text := *choice.Message.Content
+	if wrapped {
+		var envelope map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(text), &envelope); err != nil {
+			return "", fmt.Errorf("failed to parse response: %w", err)
+		}
+		text = string(envelope[openAIWrapKey])
+	}
+	return text, nil
+}
+
+// openAISchema converts one of the Gemini-style response schemas, which use
+// upper-case type names, into JSON Schema
+func openAISchema(schema map[string]interface{}) map[string]interface{} {
+	converted := make(map[string]interface{}, len(schema))
+	for key, value := range schema {
+		switch v := value.(type) {
+		case string:
+			if key == "type" {
+				v = strings.ToLower(v)
+			}
+			converted[key] = v
+		case map[string]interface{}:
+			if key == "properties" {
+				properties := make(map[string]interface{}, len(v))
+				for name, property := range v {
+					properties[name] = openAISchema(property.(map[string]interface{}))
+				}
+				converted[key] = properties
+			} else {
+				converted[key] = openAISchema(v)
+			}
+		default:
+			converted[key] = value
+		}
+	}
+	return converted
+}
@@ -2,100 +2,271 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
 	"net/http"
 	"os"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 )
 
+// interruptedMessage is recorded on tasks cut short by a server shutdown
+const interruptedMessage = "Interrupted by server shutdown"
+
 // ProcessDocument handles the async document processing pipeline
-func ProcessDocument(taskID, fileURL, filename string) {
-	log.Printf("Starting document processing for task %s", taskID)
+func ProcessDocument(ctx context.Context, taskID string, files []UploadedFile) {
+	logger := loggerFromContext(ctx).With("task_id", taskID)
+	ctx = withLogger(ctx, logger)
+	logger.Info("Starting document processing", "files", len(files))
 
 	// Update task status
-	task, _ := GetTask(taskID)
+	task, err := GetTask(taskID)
+	if err != nil || task == nil {
+		logger.Error("Failed to load task, aborting document processing", "error", err)
+		return
+	}
+	if ctx.Err() != nil {
+		logger.Warn("Document processing interrupted before it started")
+		completeTask(ctx, task, "failed", interruptedMessage, nil)
+		return
+	}
 	task.Status = "processing"
 	SaveTask(task)
 
 	// Call Python worker for document parsing
-	profile, err := callPythonWorker(fileURL, filename)
+	profile, err := callPythonWorker(ctx, files)
+	if err == nil && profile == nil {
+		err = fmt.Errorf("Python worker returned no profile")
+	}
+
+	// When the worker is down, extract the text in Go and let Gemini build
+	// the profile instead; documents the worker rejected aren't retried
+	if err != nil && ctx.Err() == nil && isPythonWorkerFailure(err) && getEnvBool("LOCAL_EXTRACTION_FALLBACK", true) {
+		logger.Warn("Python worker unavailable, extracting documents locally", "error", err)
+		if profile, err = extractProfileLocally(ctx, files, task.Language); err != nil {
+			err = fmt.Errorf("Python worker unavailable and local extraction failed: %w", err)
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		logger.Warn("Document processing interrupted", "error", err)
+		completeTask(ctx, task, "failed", interruptedMessage, nil)
+		return
+	}
 	if err != nil {
-		log.Printf("Document processing failed: %v", err)
-		task.Status = "failed"
-		task.Error = err.Error()
-		now := time.Now()
-		task.CompletedAt = &now
-		SaveTask(task)
+		logger.Error("Document processing failed", "error", err)
+		completeTask(ctx, task, "failed", err.Error(), nil)
 		return
 	}
 
-	// Save profile to database
+	// Save profile to database, owned by whoever uploaded the documents and in
+	// the language they asked for, with structured amounts parsed from the raw
+	// quantities and canonical states
+	profile.OwnerID = task.OwnerID
+	profile.Language = defaultString(task.Language, defaultLanguage())
+	parseProfileQuantities(profile)
+	if unknown := normalizeProfileStates(profile); len(unknown) > 0 {
+		logger.Warn("Stored unrecognised material states as other", "states", unknown)
+	}
+	canonicalizeProfile(ctx, profile)
+
+	// Hold back extractions the extractor wasn't sure of for a person to check
+	if profile.ExtractionConfidence < getEnvFloat("EXTRACTION_REVIEW_THRESHOLD", 0.6) {
+		profile.NeedsReview = true
+		logger.Warn("Low-confidence extraction flagged for review", "confidence", profile.ExtractionConfidence)
+	}
+
+	// A garbled location shouldn't lose the rest of the extraction; keep the
+	// profile with its location unknown instead
+	if err := profile.Location.Validate(); err != nil {
+		logger.Warn("Discarding invalid extracted location", "lat", profile.Location.Lat, "lng", profile.Location.Lng)
+		profile.Location = Location{}
+	}
 	if err := SaveProfile(profile); err != nil {
-		log.Printf("Failed to save profile: %v", err)
-		task.Status = "failed"
-		task.Error = "Failed to save profile"
-		now := time.Now()
-		task.CompletedAt = &now
-		SaveTask(task)
+		logger.Error("Failed to save profile", "error", err)
+		completeTask(ctx, task, "failed", "Failed to save profile", nil)
 		return
 	}
 
-	// Generate matches asynchronously
-	go GenerateMatches(profile.ID)
+	// Generate matches asynchronously, unless the profile must be approved
+	// first
+	result := map[string]interface{}{
+		"profile_id": profile.ID,
+		"name":       profile.Name,
+	}
+	if profile.NeedsReview {
+		result["needs_review"] = true
+	} else if matchTask, err := QueueMatchGeneration(ctx, profile.ID, profile.OwnerID); err != nil {
+		logger.Error("Failed to queue match generation", "error", err)
+	} else {
+		result["match_task_id"] = matchTask.ID
+	}
 
 	// Update task as completed
-	task.Status = "completed"
 	task.ProfileID = profile.ID
-	task.Result = map[string]interface{}{
-		"profile_id": profile.ID,
-		"name":       profile.Name,
+	completeTask(ctx, task, "completed", "", result)
+
+	logger.Info("Document processing completed", "profile_id", profile.ID)
+}
+
+// completeTask records a task's final status, error, and result, then
+// notifies the task's callback URL if it has one
+func completeTask(ctx context.Context, task *Task, status, errMsg string, result interface{}) {
+	finishTask(task, status, errMsg, result)
+	if err := SaveTask(task); err != nil {
+		loggerFromContext(ctx).Error("Failed to save task", "task_id", task.ID, "error", err)
 	}
+	if task.CallbackURL != "" {
+		deliverTaskWebhook(ctx, task)
+	}
+}
+
+// finishTask sets a task's final status, error, and result without saving it
+func finishTask(task *Task, status, errMsg string, result interface{}) {
+	task.Status = status
+	task.Error = errMsg
+	task.Result = result
 	now := time.Now()
 	task.CompletedAt = &now
-	SaveTask(task)
+}
 
-	log.Printf("Document processing completed for task %s, profile %s", taskID, profile.ID)
+// PythonWorkerError is returned when the Python worker responds with a non-200 status
+type PythonWorkerError struct {
+	StatusCode int
+	Body       string
 }
 
-// callPythonWorker sends the file to Python worker for parsing
-func callPythonWorker(fileURL, filename string) (*IndustryProfile, error) {
-	workerURL := os.Getenv("PYTHON_WORKER_URL")
-	if workerURL == "" {
-		workerURL = "http://localhost:5000"
+func (e *PythonWorkerError) Error() string {
+	return fmt.Sprintf("Python worker error (status %d): %s", e.StatusCode, e.Body)
+}
+
+// Match generation settings, read once by InitMatching
+var (
+	matchStatePrefilter bool    // MATCH_STATE_PREFILTER: drop candidates that can't take the waste's state
+	matchMaxCandidates  int     // MATCH_MAX_CANDIDATES: most candidates sent to the LLM; 0 sends all
+	matchMinSaveScore   float64 // MIN_SAVE_SCORE: lowest score a generated match needs to be saved
+	matchMaxDistanceKm  float64 // MAX_MATCH_DISTANCE_KM: furthest apart matched profiles can be; 0 means no limit
+)
+
+// InitMatching reads the match generation settings
+func InitMatching() error {
+	matchStatePrefilter = getEnvBool("MATCH_STATE_PREFILTER", true)
+	matchMaxCandidates = getEnvInt("MATCH_MAX_CANDIDATES", 50)
+	matchMinSaveScore = getEnvFloat("MIN_SAVE_SCORE", 0)
+	matchMaxDistanceKm = max(getEnvFloat("MAX_MATCH_DISTANCE_KM", 0), 0)
+	randomMatchIDs = strings.EqualFold(strings.TrimSpace(os.Getenv("MATCH_ID_MODE")), "random")
+	return nil
+}
+
+var (
+	pythonWorkerURL        string
+	pythonWorkerClient     *http.Client
+	pythonWorkerTimeout    time.Duration
+	pythonWorkerMaxRetries int
+	pythonWorkerRetryDelay time.Duration
+	pythonWorkerBreaker    *CircuitBreaker
+)
+
+// InitPythonWorker configures the client used to call the Python worker
+func InitPythonWorker() error {
+	pythonWorkerURL = os.Getenv("PYTHON_WORKER_URL")
+	if pythonWorkerURL == "" {
+		pythonWorkerURL = "http://localhost:5000"
 	}
 
-	requestBody := map[string]string{
-		"file_url":  fileURL,
-		"filename":  filename,
+	// Deadlines are set per attempt on the request context
+	pythonWorkerClient = &http.Client{}
+	pythonWorkerTimeout = getEnvDuration("PYTHON_WORKER_TIMEOUT", 5*time.Minute)
+	pythonWorkerMaxRetries = max(getEnvInt("PYTHON_WORKER_MAX_RETRIES", 3), 1)
+	pythonWorkerRetryDelay = getEnvDuration("PYTHON_WORKER_RETRY_DELAY", time.Second)
+	pythonWorkerBreaker = newCircuitBreakerFromEnv("python_worker", "PYTHON_WORKER", isPythonWorkerFailure)
+
+	// Size cap for the local extraction fallback
+	if n := getEnvInt64("LOCAL_EXTRACTION_MAX_BYTES", 50<<20); n > 0 {
+		localExtractMaxBytes = n
 	}
+	return nil
+}
 
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+// isPythonWorkerFailure reports whether an error means the worker is unhealthy:
+// network failures and server errors are; rejected documents (4xx) are not
+func isPythonWorkerFailure(err error) bool {
+	var workerErr *PythonWorkerError
+	if errors.As(err, &workerErr) {
+		return workerErr.StatusCode >= 500
 	}
+	return true
+}
 
-	resp, err := http.Post(workerURL+"/parse", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to call Python worker: %w", err)
+// callPythonWorker sends the files to Python worker for parsing into a single profile
+func callPythonWorker(ctx context.Context, files []UploadedFile) (*IndustryProfile, error) {
+	// The worker can't read s3:// URLs directly, so hand it presigned ones
+	workerFiles := make([]UploadedFile, len(files))
+	for i, file := range files {
+		workerFiles[i] = file
+		if isS3URL(file.URL) {
+			presigned, err := GeneratePresignedURL(file.URL)
+			if err != nil {
+				return nil, fmt.Errorf("failed to presign file URL: %w", err)
+			}
+			workerFiles[i].URL = presigned
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("Python worker error (status %d): %s", resp.StatusCode, string(body))
+	// file_url/filename describe the first document for older workers;
+	// files carries all of them so the worker can merge them
+	requestBody := map[string]interface{}{
+		"file_url":  workerFiles[0].URL,
+		"filename":  workerFiles[0].Filename,
+		"files":     workerFiles,
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Retry connection errors and 5xx responses with a growing delay;
+	// rejected documents (4xx) won't parse on a second try
+	var body []byte
+	delay := pythonWorkerRetryDelay
+	for attempt := 1; ; attempt++ {
+		body, err = postToPythonWorker(ctx, jsonData)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, ErrCircuitOpen) {
+			return nil, fmt.Errorf("Python worker unavailable: %w", err)
+		}
+		if ctx.Err() != nil || !isPythonWorkerFailure(err) {
+			return nil, err
+		}
+		if attempt >= pythonWorkerMaxRetries {
+			return nil, fmt.Errorf("failed after %d attempts: %w", attempt, err)
+		}
+
+		loggerFromContext(ctx).Warn("Python worker call failed, retrying", "attempt", attempt, "retry_in", delay.String(), "error", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		delay *= 2
 	}
 
+	// Workers that don't rate their extractions are trusted as before
 	var result struct {
 		Profile IndustryProfile `json:"profile"`
 	}
+	result.Profile.ExtractionConfidence = 1
 
 	if err := json.Unmarshal(body, &result); err != nil {
 		return nil, fmt.Errorf("failed to parse response: %w", err)
@@ -104,119 +275,639 @@ func callPythonWorker(fileURL, filename string) (*IndustryProfile, error) {
 	return &result.Profile, nil
 }
 
-// GenerateMatches generates match recommendations for a profile
-func GenerateMatches(profileID string) {
-	log.Printf("Generating matches for profile %s", profileID)
+// profileFromText has Gemini extract a profile from document text, without
+// the Python worker, with names written in lang. fallbackName names the
+// profile if the text doesn't.
+func profileFromText(ctx context.Context, text, fallbackName, lang string) (*IndustryProfile, error) {
+	if mcpClient == nil {
+		return nil, fmt.Errorf("no LLM client configured")
+	}
+	extracted, err := mcpClient.ExtractIO(ctx, text, lang)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract profile: %w", err)
+	}
+	return profileFromExtraction(extracted, fallbackName), nil
+}
+
+// profileFromExtraction builds a new profile from ExtractIO's result,
+// tolerating partial extractions: a missing company name falls back to
+// fallbackName, missing or invalid coordinates leave the location unknown,
+// unnamed inputs and outputs are dropped, and an unrecognisable document
+// language is left out
+func profileFromExtraction(extracted *ExtractedProfile, fallbackName string) *IndustryProfile {
+	name := strings.TrimSpace(extracted.Name)
+	if name == "" {
+		name = fallbackName
+	}
+
+	location := Location{Lat: extracted.Location.Lat, Lng: extracted.Location.Lng}
+	if location.Validate() != nil {
+		location = Location{}
+	}
+
+	inputs := make([]Input, 0, len(extracted.Inputs))
+	for _, input := range extracted.Inputs {
+		input.Name = strings.TrimSpace(input.Name)
+		if input.Name != "" {
+			inputs = append(inputs, input)
+		}
+	}
 
-	profile, err := GetProfile(profileID)
+	outputs := make([]Output, 0, len(extracted.Outputs))
+	for _, output := range extracted.Outputs {
+		output.Name = strings.TrimSpace(output.Name)
+		output.State = strings.ToLower(strings.TrimSpace(output.State))
+		if output.Name != "" {
+			outputs = append(outputs, output)
+		}
+	}
+
+	profile := NewIndustryProfile(name, location, inputs, outputs)
+	profile.ExtractionConfidence = min(max(extracted.Confidence, 0), 1)
+	if extracted.Language != "" {
+		profile.DocumentLanguage, _ = parseLanguage(extracted.Language)
+	}
+	return profile
+}
+
+// postToPythonWorker makes one /parse request, bounded by
+// PYTHON_WORKER_TIMEOUT, and returns the response body
+func postToPythonWorker(ctx context.Context, payload []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, pythonWorkerTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pythonWorkerURL+"/parse", bytes.NewReader(payload))
 	if err != nil {
-		log.Printf("Failed to get profile: %v", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if requestID := requestIDFromContext(ctx); requestID != "" {
+		req.Header.Set(requestIDHeader, requestID)
+	}
+
+	// Fail fast while the breaker is open rather than waiting out the timeout
+	var body []byte
+	err = pythonWorkerBreaker.Execute(func() error {
+		resp, err := pythonWorkerClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to call Python worker: %w", err)
+		}
+		defer resp.Body.Close()
+
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return &PythonWorkerError{StatusCode: resp.StatusCode, Body: string(body)}
+		}
+		return nil
+	})
+	return body, err
+}
+
+// matchScope limits a match generation run to part of a profile, or to the
+// candidates that changed since its last run. The zero value covers the whole
+// profile against every candidate.
+type matchScope struct {
+	Output string    // only this waste stream, against other profiles' inputs
+	Inputs bool      // only other profiles' waste streams, against this profile's inputs
+	Since  time.Time // only candidates created or updated after this
+}
+
+// incrementalScope returns the scope that brings a profile's matches up to
+// date from its match watermark: the candidates created or updated since.
+// A profile never fully matched gets the whole run.
+func incrementalScope(profile *IndustryProfile) matchScope {
+	if profile.MatchedAt == nil {
+		return matchScope{}
+	}
+	return matchScope{Since: *profile.MatchedAt}
+}
+
+// complete reports whether a run in this scope evaluates the whole profile,
+// so that it can move the profile's match watermark to its start
+func (s matchScope) complete() bool {
+	return s.Output == "" && !s.Inputs
+}
+
+// QueueMatchGeneration creates a match_generation task for a profile, owned
+// by the profile's owner, and submits the matching work to the worker pool
+func QueueMatchGeneration(ctx context.Context, profileID, ownerID string) (*Task, error) {
+	return queueScopedMatchGeneration(ctx, profileID, ownerID, matchScope{})
+}
+
+// queueScopedMatchGeneration is QueueMatchGeneration for part of a profile,
+// after an edit that only affects that part
+func queueScopedMatchGeneration(ctx context.Context, profileID, ownerID string, scope matchScope) (*Task, error) {
+	task := NewTask("match_generation")
+	task.ProfileID = profileID
+	task.OwnerID = ownerID
+	if err := SaveTask(task); err != nil {
+		return nil, err
+	}
+
+	if !workerPool.Submit(func() { GenerateMatches(ctx, task.ID, profileID, scope) }) {
+		completeTask(ctx, task, "failed", interruptedMessage, nil)
+		return nil, fmt.Errorf("worker pool has stopped")
+	}
+	return task, nil
+}
+
+// GenerateMatches generates match recommendations for a profile, or the part
+// of it in scope, recording progress on its match_generation task. The
+// matches, any output tag changes, and the completed task are saved in one
+// transaction at the end, so an interrupted or failed run leaves no partial
+// match set behind.
+func GenerateMatches(ctx context.Context, taskID, profileID string, scope matchScope) {
+	logger := loggerFromContext(ctx).With("task_id", taskID, "profile_id", profileID)
+	ctx = withLogger(ctx, logger)
+	logger.Info("Generating matches")
+
+	task, err := GetTask(taskID)
+	if err != nil {
+		logger.Error("Failed to load task, aborting match generation", "error", err)
 		return
 	}
+	if ctx.Err() != nil {
+		logger.Warn("Match generation interrupted before it started")
+		completeTask(ctx, task, "failed", interruptedMessage, nil)
+		return
+	}
+	task.Status = "processing"
+	SaveTask(task)
 
-	// Get all other profiles as potential candidates
-	allProfiles, err := ListAllProfiles()
+	profile, err := GetProfile(profileID, false)
 	if err != nil {
-		log.Printf("Failed to list profiles: %v", err)
+		logger.Error("Failed to get profile", "error", err)
+		completeTask(ctx, task, "failed", "Failed to get profile", nil)
 		return
 	}
 
-	// Filter out the current profile
+	// Unreviewed extractions stay out of the matching graph until approved
+	if profile.NeedsReview {
+		logger.Info("Profile awaits review, skipping match generation")
+		completeTask(ctx, task, "completed", "", map[string]interface{}{
+			"profile_id":      profileID,
+			"needs_review":    true,
+			"matches_created": 0,
+		})
+		return
+	}
+
+	// Heuristic mode matches on names alone and never calls the LLM
+	heuristic := heuristicMatching()
+	matchStream := matchWasteStream
+	if heuristic {
+		matchStream = matchWasteStreamHeuristic
+	}
+
+	// Classification tags are persisted onto the profile's waste streams
+	// along with the matches
+	var taggedProfile *IndustryProfile
+	if !heuristic && tagOutputs(ctx, profile) {
+		taggedProfile = profile
+	}
+
+	// Taken before the candidates are listed, so a profile saved meanwhile
+	// is still newer than the watermark this run sets
+	started := time.Now()
+
+	// Get all other live profiles as potential candidates, whoever owns
+	// them: finding partners across companies is the point of matching
+	allProfiles, _, err := ListAllProfiles("", false, 0, 0)
+	if err != nil {
+		logger.Error("Failed to list profiles", "error", err)
+		completeTask(ctx, task, "failed", "Failed to list candidate profiles", nil)
+		return
+	}
+
+	// Filter out the current profile and any awaiting review, and in an
+	// incremental run those evaluated already
 	var candidates []*IndustryProfile
 	for _, p := range allProfiles {
-		if p.ID != profileID {
+		if p.ID != profileID && !p.NeedsReview && p.UpdatedAt.After(scope.Since) {
 			candidates = append(candidates, p)
 		}
 	}
 
+	// Transport makes far-off partners infeasible. Distance is symmetric, so
+	// this serves both directions of matching below.
+	candidates, tooFar := filterByDistance(candidates, profile.Location, matchMaxDistanceKm)
+	if tooFar > 0 {
+		logger.Info("Excluded candidates beyond MAX_MATCH_DISTANCE_KM", "excluded", tooFar)
+	}
+
+	var matches []*MatchRecommendation
+	// The profile's own waste streams are all reported; other producers'
+	// streams matched against its inputs only when they matched or failed
+	var outcomes, incoming []*WasteStreamOutcome
+	defer func() {
+		result := map[string]interface{}{
+			"profile_id":      profileID,
+			"candidates":      len(candidates),
+			"matches_created": 0,
+		}
+		if tooFar > 0 {
+			result["candidates_beyond_max_distance"] = tooFar
+		}
+		if scope.Output != "" {
+			result["scope"] = map[string]interface{}{"output": scope.Output}
+		} else if scope.Inputs {
+			result["scope"] = map[string]interface{}{"inputs": true}
+		}
+		if !scope.Since.IsZero() {
+			result["incremental_since"] = scope.Since
+		}
+		if ctx.Err() != nil {
+			logger.Warn("Match generation interrupted, discarding matches", "matches_found", len(matches))
+			completeTask(ctx, task, "failed", interruptedMessage, result)
+			return
+		}
+
+		// Weak matches would only clutter the review lists
+		found := len(matches)
+		matches = filterByScore(ctx, matches, matchMinSaveScore)
+		result["matches_created"] = len(matches)
+		result["matches_discarded"] = found - len(matches)
+
+		saved := make(map[[2]string]int)
+		for _, match := range matches {
+			saved[[2]string{match.ProducerID, match.WasteID}]++
+		}
+		failed := 0
+		for _, outcome := range append(outcomes, incoming...) {
+			outcome.Matches = saved[[2]string{outcome.ProducerID, outcome.Waste}]
+			if outcome.Status == StreamFailed {
+				failed++
+			}
+		}
+		result["waste_streams"] = outcomes
+		if len(incoming) > 0 {
+			result["incoming_waste_streams"] = incoming
+		}
+		result["failed_streams"] = failed
+
+		// Report a run where nothing could be processed as failed rather than
+		// as one that found no matches
+		status, errMsg := "completed", ""
+		if failed > 0 && failed == len(outcomes)+len(incoming) {
+			status, errMsg = "failed", "Matching failed for every waste stream"
+		} else if failed > 0 {
+			logger.Warn("Matching failed for some waste streams", "failed", failed)
+		}
+		finishTask(task, status, errMsg, result)
+
+		// Candidates whose streams failed get another chance next run
+		var matchedAt time.Time
+		if scope.complete() && failed == 0 {
+			matchedAt = started
+		}
+		if err := SaveMatchResults(taggedProfile, matches, task, matchedAt); err != nil {
+			logger.Error("Failed to save match results", "error", err)
+			result["matches_created"] = 0
+			completeTask(ctx, task, "failed", "Failed to save matches", result)
+			return
+		}
+		logger.Info("Match generation completed", "matches_created", len(matches))
+	}()
+
 	if len(candidates) == 0 {
-		log.Printf("No candidate profiles found for matching")
+		logger.Info("No candidate profiles found for matching")
 		return
 	}
 
-	// Process each output/waste stream
+	// Match this profile's waste streams against the other profiles' inputs
 	for _, output := range profile.Outputs {
-		log.Printf("Processing waste stream: %s", output.Name)
+		if scope.Inputs || (scope.Output != "" && output.Name != scope.Output) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		found, err := matchStream(ctx, profile, output, candidates)
+		matches = append(matches, found...)
+		outcomes = append(outcomes, streamOutcome(profile, output, found, err))
+	}
 
-		// Classify waste using MCP
-		classification, err := mcpClient.ClassifyWaste(output.Name, output.State)
-		if err != nil {
-			log.Printf("Failed to classify waste: %v", err)
+	// Match the other profiles' waste streams against this profile's inputs,
+	// so a newly added consumer surfaces as a destination for existing waste
+	if len(profile.Inputs) > 0 && scope.Output == "" {
+		consumer := []*IndustryProfile{profile}
+		for _, producer := range candidates {
+			for _, output := range producer.Outputs {
+				if ctx.Err() != nil {
+					return
+				}
+				found, err := matchStream(ctx, producer, output, consumer)
+				matches = append(matches, found...)
+				if len(found) > 0 || err != nil {
+					incoming = append(incoming, streamOutcome(producer, output, found, err))
+				}
+			}
+		}
+	}
+}
+
+// matchWasteStream evaluates one producer waste stream against candidate consumers
+// and returns a match for each suitable candidate. The caller saves them. An
+// error alongside matches means some candidates couldn't be assessed; one
+// wrapping ErrContentBlocked means the model declined to match the stream.
+func matchWasteStream(ctx context.Context, producer *IndustryProfile, output Output, candidates []*IndustryProfile) ([]*MatchRecommendation, error) {
+	logger := loggerFromContext(ctx).With("waste", output.Name, "producer", producer.Name)
+	logger.Info("Processing waste stream")
+
+	// Classify waste, reusing a cached classification where possible
+	classification, err := classifyWaste(ctx, output)
+	if err != nil {
+		logger.Error("Failed to classify waste", "error", err)
+		return nil, fmt.Errorf("failed to classify waste: %w", err)
+	}
+	output.Tags = classification.Tags
+
+	// Skip candidates that can't take the waste in its current form
+	if matchStatePrefilter {
+		candidates = filterByState(candidates, output.State)
+		if len(candidates) == 0 {
+			logger.Info("No candidates accept this waste's state")
+			return nil, nil
+		}
+	}
+
+	// Only send Gemini the most promising candidates
+	if limit := matchMaxCandidates; limit > 0 && len(candidates) > limit {
+		logger.Info("Pruning match candidates", "candidates", len(candidates), "kept", limit, "pruned", len(candidates)-limit)
+		candidates = topCandidates(candidates, producer.Location, output.State, limit)
+	}
+
+	// Find potential matches. Candidates are sent in batches, so some may be
+	// picked even if others couldn't be considered.
+	matchingNames, err := mcpClient.FindMatches(ctx, output, candidates)
+	if err != nil && len(matchingNames) == 0 {
+		if errors.Is(err, ErrContentBlocked) {
+			logger.Warn("Skipping waste stream Gemini would not match", "error", err)
+			return nil, err
+		}
+		logger.Error("Failed to find matches", "error", err)
+		return nil, fmt.Errorf("failed to find matches: %w", err)
+	}
+	var findErr error
+	if err != nil {
+		logger.Warn("Failed to find matches among some candidates", "error", err)
+		findErr = fmt.Errorf("failed to find matches among some candidates: %w", err)
+	}
+
+	// Keep the candidates Gemini picked
+	matched := make(map[string]bool, len(matchingNames))
+	for _, name := range matchingNames {
+		matched[name] = true
+	}
+	var selected []*IndustryProfile
+	for _, candidate := range candidates {
+		if matched[candidate.Name] {
+			selected = append(selected, candidate)
+		}
+	}
+	if len(selected) == 0 || ctx.Err() != nil {
+		return nil, findErr
+	}
+
+	// Estimate conversion requirements and reasoning for all of them in batches
+	conversions, estimateErr := mcpClient.EstimateConversions(ctx, output, producer.Language, selected)
+	if estimateErr != nil {
+		logger.Error("Failed to estimate some conversions", "error", estimateErr)
+	}
+
+	// Third-party conversions get suggested converters from the registry
+	converters := &converterLookup{output: output, classification: classification}
+
+	var matches []*MatchRecommendation
+	var unassessed []string
+	for _, candidate := range selected {
+		conversion, ok := conversions[candidate.ID]
+		if !ok {
+			logger.Warn("No conversion estimate for candidate", "candidate", candidate.Name)
+			unassessed = append(unassessed, candidate.Name)
 			continue
 		}
 
-		// Find potential matches
-		matchingNames, err := mcpClient.FindMatches(output, candidates)
+		reasoning := conversion.Reasoning
+		if reasoning == "" {
+			reasoning = "Match identified based on input/output compatibility"
+		}
+
+		// Calculate score based on multiple factors
+		score := calculateMatchScore(producer, candidate, output, &conversion.ConversionEstimate)
+
+		// Create match recommendation
+		match := NewMatchRecommendation(output.Name, producer.ID, candidate.ID)
+		match.wasteKey = output.canonicalName()
+		match.ConversionNeeded = conversion.ConversionNeeded
+		match.ConversionDescription = conversion.Description
+		match.RecommendedConverter = normalizeConverterRole(conversion.RecommendedConverter)
+		match.EstimatedCost = defaultString(conversion.EstimatedCost, "Unknown")
+		match.EstimatedCostRange = normalizeCostRange(conversion.CostRange)
+		match.llmResponse = mcpClient.auditResponse(matchAudit{FindMatches: candidate.Name, Conversion: conversion.rawResponse})
+		match.Score = score
+		match.Reasoning = reasoning
+		match.ConsumerReasoning = conversion.ConsumerReasoning
+		converters.attach(ctx, match, producer)
+
+		matches = append(matches, match)
+		logger.Info("Found match", "match_id", match.ID, "candidate", candidate.Name, "score", score)
+	}
+
+	if len(unassessed) > 0 {
+		err := fmt.Errorf("no conversion estimate for %s", strings.Join(unassessed, ", "))
+		if estimateErr != nil {
+			err = fmt.Errorf("%w: %w", err, estimateErr)
+		}
+		return matches, errors.Join(findErr, err)
+	}
+	return matches, findErr
+}
+
+// streamOutcome summarizes matchWasteStream's result for a task result. The
+// match count is filled in once it's known which matches are saved.
+func streamOutcome(producer *IndustryProfile, output Output, found []*MatchRecommendation, err error) *WasteStreamOutcome {
+	outcome := &WasteStreamOutcome{Waste: output.Name, ProducerID: producer.ID, Status: StreamOK}
+	if err == nil {
+		return outcome
+	}
+
+	outcome.Error = err.Error()
+	switch {
+	case len(found) > 0:
+		outcome.Status = StreamPartial
+	case errors.Is(err, ErrContentBlocked):
+		outcome.Status = StreamSkipped
+	default:
+		outcome.Status = StreamFailed
+	}
+	return outcome
+}
+
+// EvaluatePair scores each of producer's waste streams against one candidate
+// consumer, without the state prefilter or Gemini's candidate selection, so
+// any pair can be assessed on demand. The matches are returned unsaved, and
+// waste streams Gemini declines to evaluate are left out.
+func EvaluatePair(ctx context.Context, producer, candidate *IndustryProfile) ([]*MatchRecommendation, error) {
+	if heuristicMatching() {
+		return evaluatePairHeuristic(producer, candidate), nil
+	}
+
+	matches := make([]*MatchRecommendation, 0, len(producer.Outputs))
+	for _, output := range producer.Outputs {
+		logger := loggerFromContext(ctx).With("waste", output.Name, "producer", producer.Name, "candidate", candidate.Name)
+
+		classification, err := classifyWaste(ctx, output)
 		if err != nil {
-			log.Printf("Failed to find matches: %v", err)
+			return nil, fmt.Errorf("failed to classify %s: %w", output.Name, err)
+		}
+		output.Tags = classification.Tags
+
+		// Describe what the candidate would use the waste as, as precisely as we can
+		target := candidate.Name
+		if input := matchingInput(candidate, output); input != nil {
+			target = input.String()
+		} else if len(candidate.Inputs) > 0 {
+			target = describeInputs(candidate.Inputs)
+		}
+
+		conversion, err := mcpClient.EstimateConversion(ctx, output, target)
+		if errors.Is(err, ErrContentBlocked) {
+			logger.Warn("Skipping waste stream Gemini would not evaluate", "error", err)
 			continue
 		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to estimate conversion for %s: %w", output.Name, err)
+		}
 
-		// Process each matching candidate
-		for _, candidate := range candidates {
-			// Check if this candidate is in the matching list
-			isMatch := false
-			for _, name := range matchingNames {
-				if name == candidate.Name {
-					isMatch = true
-					break
-				}
-			}
+		explanation, err := mcpClient.ExplainMatch(ctx, output, candidate, conversion, PerspectiveProducer, producer.Language)
+		reasoning := explanation
+		if err != nil || reasoning == "" {
+			logger.Warn("Failed to generate reasoning", "error", err)
+			reasoning = "Match identified based on input/output compatibility"
+		}
+		consumerReasoning, err := mcpClient.ExplainMatch(ctx, output, candidate, conversion, PerspectiveConsumer, candidate.Language)
+		if err != nil {
+			logger.Warn("Failed to generate consumer reasoning", "error", err)
+		}
 
-			if !isMatch {
-				continue
-			}
+		match := NewMatchRecommendation(output.Name, producer.ID, candidate.ID)
+		match.wasteKey = output.canonicalName()
+		match.ConversionNeeded = conversion.ConversionNeeded
+		match.ConversionDescription = conversion.Description
+		match.RecommendedConverter = normalizeConverterRole(conversion.RecommendedConverter)
+		match.EstimatedCost = defaultString(conversion.EstimatedCost, "Unknown")
+		match.EstimatedCostRange = normalizeCostRange(conversion.CostRange)
+		match.llmResponse = mcpClient.auditResponse(matchAudit{
+			Conversion:          conversion.rawResponse,
+			Explanation:         explanation,
+			ConsumerExplanation: consumerReasoning,
+		})
+		match.Score = calculateMatchScore(producer, candidate, output, conversion)
+		match.Reasoning = reasoning
+		match.ConsumerReasoning = consumerReasoning
+		(&converterLookup{output: output, classification: classification}).attach(ctx, match, producer)
 
-			// Estimate conversion requirements
-			conversionInfo, err := mcpClient.EstimateConversion(output, candidate.Name)
-			if err != nil {
-				log.Printf("Failed to estimate conversion: %v", err)
-				continue
-			}
+		matches = append(matches, match)
+	}
+	return matches, nil
+}
 
-			// Generate reasoning
-			reasoning, err := mcpClient.ExplainMatch(output, candidate, conversionInfo)
-			if err != nil {
-				log.Printf("Failed to generate reasoning: %v", err)
-				reasoning = "Match identified based on input/output compatibility"
-			}
+// filterByScore returns the matches scoring at least minScore, leaving out
+// any marked as non-matches and logging the others at debug level
+func filterByScore(ctx context.Context, matches []*MatchRecommendation, minScore float64) []*MatchRecommendation {
+	var kept []*MatchRecommendation
+	for _, match := range matches {
+		if match.noMatch {
+			loggerFromContext(ctx).Debug("Discarding non-match",
+				"waste", match.WasteID, "producer_id", match.ProducerID, "candidate_id", match.CandidateID)
+			continue
+		}
+		if match.Score >= minScore {
+			kept = append(kept, match)
+			continue
+		}
+		loggerFromContext(ctx).Debug("Discarding match below minimum score",
+			"waste", match.WasteID, "producer_id", match.ProducerID, "candidate_id", match.CandidateID,
+			"score", match.Score, "min_score", minScore)
+	}
+	return kept
+}
 
-			// Calculate score based on multiple factors
-			score := calculateMatchScore(profile, candidate, output, classification, conversionInfo)
-
-			// Create match recommendation
-			match := NewMatchRecommendation(output.Name, profileID, candidate.ID)
-			match.ConversionNeeded = getBool(conversionInfo, "conversion_needed", false)
-			match.ConversionDescription = getString(conversionInfo, "description", "")
-			match.RecommendedConverter = getString(conversionInfo, "recommended_converter", "producer")
-			match.EstimatedCost = getString(conversionInfo, "estimated_cost", "Unknown")
-			match.Score = score
-			match.Reasoning = reasoning
-
-			// Save match
-			if err := SaveMatch(match); err != nil {
-				log.Printf("Failed to save match: %v", err)
-			} else {
-				log.Printf("Created match: %s -> %s (score: %.2f)", profile.Name, candidate.Name, score)
-			}
+// tagOutputs classifies each of a profile's outputs and copies the resulting
+// tags onto it. It reports whether any output's tags changed.
+func tagOutputs(ctx context.Context, profile *IndustryProfile) bool {
+	changed := false
+	for i, output := range profile.Outputs {
+		classification, err := classifyWaste(ctx, output)
+		if err != nil {
+			loggerFromContext(ctx).Error("Failed to classify waste", "waste", output.Name, "error", err)
+			continue
+		}
+		if !slices.Equal(output.Tags, classification.Tags) {
+			profile.Outputs[i].Tags = classification.Tags
+			changed = true
 		}
 	}
+	return changed
+}
+
+// classifyWaste returns the classification for a waste stream, consulting the
+// waste_classifications cache before calling Gemini. If Gemini blocks the
+// request the stream is treated as unclassified rather than failing, and
+// nothing is cached so a later run can try again.
+func classifyWaste(ctx context.Context, output Output) (*WasteClassification, error) {
+	name := output.canonicalName()
+	state := normalizeKey(output.State)
+	ttl := getEnvDuration("CLASSIFICATION_CACHE_TTL", 30*24*time.Hour)
+
+	cached, err := GetCachedClassification(name, state, ttl)
+	if err == nil {
+		return cached, nil
+	}
+	if err != sql.ErrNoRows {
+		loggerFromContext(ctx).Warn("Failed to read classification cache", "error", err)
+	}
 
-	log.Printf("Match generation completed for profile %s", profileID)
+	classification, err := mcpClient.ClassifyWaste(ctx, output.Name, output.State)
+	if errors.Is(err, ErrContentBlocked) {
+		return &WasteClassification{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := SaveClassification(name, state, classification); err != nil {
+		loggerFromContext(ctx).Warn("Failed to cache classification", "error", err)
+	}
+
+	return classification, nil
+}
+
+// normalizeKey lowercases a string and collapses whitespace for use as a lookup key
+func normalizeKey(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
 }
 
-// calculateMatchScore calculates a score for a match based on various factors
-func calculateMatchScore(producer, consumer *IndustryProfile, waste Output, classification, conversionInfo map[string]interface{}) float64 {
+// calculateMatchScore calculates a score for a match based on various factors.
+// Factors that can't be assessed, such as proximity when either location is
+// unknown or volume fit when a quantity is missing, add nothing either way.
+// The result is scaled down by each profile's extraction confidence.
+// It depends only on its arguments, so it can be exercised without a
+// database or Gemini.
+func calculateMatchScore(producer, consumer *IndustryProfile, waste Output, conversion *ConversionEstimate) float64 {
 	score := 0.5 // Base score
 
 	// Bonus for no conversion needed
-	if !getBool(conversionInfo, "conversion_needed", false) {
+	if !conversion.ConversionNeeded {
 		score += 0.2
 	}
 
 	// Bonus for low complexity conversion
-	complexity := getString(conversionInfo, "complexity", "unknown")
-	switch complexity {
+	switch conversion.Complexity {
 	case "low":
 		score += 0.15
 	case "medium":
@@ -225,12 +916,38 @@ func calculateMatchScore(producer, consumer *IndustryProfile, waste Output, clas
 		score -= 0.1
 	}
 
-	// Bonus for geographic proximity (simplified - within 100km)
-	distance := calculateDistance(producer.Location, consumer.Location)
-	if distance < 100 {
-		score += 0.15
-	} else if distance < 500 {
-		score += 0.05
+	// Bonus for geographic proximity (simplified - within 100km). Two unknown
+	// locations are both (0, 0), so they would otherwise look adjacent.
+	if !producer.Location.IsUnknown() && !consumer.Location.IsUnknown() {
+		distance := calculateDistance(producer.Location, consumer.Location)
+		if distance < 100 {
+			score += 0.15
+		} else if distance < 500 {
+			score += 0.05
+		}
+	}
+
+	if input := matchingInput(consumer, waste); input != nil {
+		// Bonus when the consumer explicitly accepts the waste's form
+		for _, state := range input.States {
+			if strings.EqualFold(state, waste.State) {
+				score += 0.05
+				break
+			}
+		}
+
+		// Reward waste volumes close to what the consumer needs and penalize
+		// badly mismatched ones; skipped when either quantity is unknown
+		if waste.Amount != nil && input.Amount != nil {
+			if ratio, ok := supplyRatio(waste.Amount, input.Amount); ok {
+				switch {
+				case ratio >= 0.5 && ratio <= 2:
+					score += 0.1
+				case ratio < 0.1 || ratio > 10:
+					score -= 0.15
+				}
+			}
+		}
 	}
 
 	// Ensure score is between 0 and 1
@@ -241,28 +958,230 @@ func calculateMatchScore(producer, consumer *IndustryProfile, waste Output, clas
 		score = 0.0
 	}
 
+	// A profile extracted with low confidence may have the wrong streams, so
+	// its matches rank lower, even once a reviewer has approved it: approval
+	// confirms the profile is usable, not that every stream is right
+	for _, profile := range []*IndustryProfile{producer, consumer} {
+		score *= 0.5 + 0.5*min(max(profile.ExtractionConfidence, 0), 1)
+	}
+
 	return score
 }
 
-// calculateDistance calculates distance between two locations (simplified)
+// beyondMatchDistance reports whether two profiles are further apart than
+// MAX_MATCH_DISTANCE_KM. Profiles with an unknown location are never
+// excluded, since their distance can't be known.
+func beyondMatchDistance(a, b Location, maxKm float64) bool {
+	if maxKm <= 0 || a.IsUnknown() || b.IsUnknown() {
+		return false
+	}
+	return calculateDistance(a, b) > maxKm
+}
+
+// filterByDistance returns the candidates within maxKm of origin, along
+// with how many were dropped
+func filterByDistance(candidates []*IndustryProfile, origin Location, maxKm float64) ([]*IndustryProfile, int) {
+	var near []*IndustryProfile
+	for _, candidate := range candidates {
+		if !beyondMatchDistance(origin, candidate.Location, maxKm) {
+			near = append(near, candidate)
+		}
+	}
+	return near, len(candidates) - len(near)
+}
+
+// filterByState returns the candidates with at least one input that accepts
+// the given waste state. Inputs that declare no states accept anything, so
+// only candidates whose every input rules the state out are dropped. Waste
+// in an unstated or other state can't be judged, so nothing is dropped.
+func filterByState(candidates []*IndustryProfile, state string) []*IndustryProfile {
+	if state == "" || state == StateOther {
+		return candidates
+	}
+
+	var compatible []*IndustryProfile
+	for _, candidate := range candidates {
+		if acceptsState(candidate, state) {
+			compatible = append(compatible, candidate)
+		}
+	}
+	return compatible
+}
+
+// topCandidates returns the limit candidates most worth evaluating for a waste
+// stream: those naming its state among an input's states first, then those
+// accepting any state, then the rest, nearest first within each group.
+// Candidates with an unknown location sort after located ones.
+func topCandidates(candidates []*IndustryProfile, origin Location, state string, limit int) []*IndustryProfile {
+	type ranked struct {
+		profile  *IndustryProfile
+		fit      int
+		distance float64
+	}
+	ranking := make([]ranked, len(candidates))
+	for i, candidate := range candidates {
+		ranking[i] = ranked{profile: candidate, fit: stateFit(candidate, state), distance: math.Inf(1)}
+		if !origin.IsUnknown() && !candidate.Location.IsUnknown() {
+			ranking[i].distance = calculateDistance(origin, candidate.Location)
+		}
+	}
+
+	sort.SliceStable(ranking, func(i, j int) bool {
+		if ranking[i].fit != ranking[j].fit {
+			return ranking[i].fit > ranking[j].fit
+		}
+		return ranking[i].distance < ranking[j].distance
+	})
+
+	top := make([]*IndustryProfile, min(limit, len(ranking)))
+	for i := range top {
+		top[i] = ranking[i].profile
+	}
+	return top
+}
+
+// stateFit grades how well a profile's inputs fit a waste state: 2 when an
+// input names the state, 1 when an input accepts any state, 0 otherwise
+func stateFit(profile *IndustryProfile, state string) int {
+	fit := 0
+	if len(profile.Inputs) == 0 {
+		fit = 1
+	}
+	for _, input := range profile.Inputs {
+		if len(input.States) == 0 {
+			fit = 1
+			continue
+		}
+		for _, s := range input.States {
+			if state != "" && strings.EqualFold(s, state) {
+				return 2
+			}
+		}
+	}
+	return fit
+}
+
+// acceptsState reports whether any of a profile's inputs accepts the state
+func acceptsState(profile *IndustryProfile, state string) bool {
+	if len(profile.Inputs) == 0 {
+		return true
+	}
+	for _, input := range profile.Inputs {
+		if len(input.States) == 0 {
+			return true
+		}
+		for _, s := range input.States {
+			if strings.EqualFold(s, state) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchingInput returns the consumer input a waste stream would feed: the one
+// sharing a word with the waste's name or tags, or else the consumer's only
+// input. It returns nil when no input can be singled out.
+func matchingInput(consumer *IndustryProfile, waste Output) *Input {
+	wasteWords := make(map[string]bool)
+	for _, word := range strings.Fields(normalizeKey(strings.Join(append([]string{waste.Name, waste.canonicalName()}, waste.Tags...), " "))) {
+		if len(word) > 2 {
+			wasteWords[word] = true
+		}
+	}
+
+	for i := range consumer.Inputs {
+		input := &consumer.Inputs[i]
+		for _, word := range strings.Fields(normalizeKey(strings.Join(append([]string{input.Name, input.canonicalName()}, input.Tags...), " "))) {
+			if wasteWords[word] {
+				return input
+			}
+		}
+	}
+
+	if len(consumer.Inputs) == 1 {
+		return &consumer.Inputs[0]
+	}
+	return nil
+}
+
+// earthRadiusKm is the mean Earth radius used for great-circle distances
+const earthRadiusKm = 6371.0
+
+// calculateDistance returns the great-circle distance in km between two locations using the Haversine formula
 func calculateDistance(loc1, loc2 Location) float64 {
-	// Haversine formula (simplified for demo)
-	dlat := loc2.Lat - loc1.Lat
-	dlng := loc2.Lng - loc1.Lng
-	return (dlat*dlat + dlng*dlng) * 111.0 // Very rough approximation in km
+	lat1 := loc1.Lat * math.Pi / 180
+	lat2 := loc2.Lat * math.Pi / 180
+	dlat := (loc2.Lat - loc1.Lat) * math.Pi / 180
+	dlng := (loc2.Lng - loc1.Lng) * math.Pi / 180
+
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dlng/2)*math.Sin(dlng/2)
+	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+
+	return earthRadiusKm * c
 }
 
-// Helper functions to extract values from maps
-func getBool(m map[string]interface{}, key string, defaultVal bool) bool {
-	if val, ok := m[key].(bool); ok {
-		return val
+// findNearbyProfiles returns profiles within radiusKm of center, nearest first.
+// A bounding box query narrows the candidates before exact Haversine filtering.
+// A non-empty ownerID restricts the results to that owner's profiles.
+func findNearbyProfiles(center Location, radiusKm float64, ownerID string) ([]*NearbyProfile, error) {
+	dLat := radiusKm / 111.0
+	minLat := math.Max(center.Lat-dLat, -90)
+	maxLat := math.Min(center.Lat+dLat, 90)
+
+	// Longitude degrees shrink towards the poles; near them, or across the antimeridian, skip the lng bound
+	wrapLng := true
+	var minLng, maxLng float64
+	if cosLat := math.Cos(center.Lat * math.Pi / 180); cosLat > 0.01 {
+		dLng := radiusKm / (111.0 * cosLat)
+		minLng, maxLng = center.Lng-dLng, center.Lng+dLng
+		wrapLng = minLng < -180 || maxLng > 180
+	}
+
+	profiles, err := ListProfilesInBounds(ownerID, minLat, maxLat, minLng, maxLng, wrapLng)
+	if err != nil {
+		return nil, err
+	}
+
+	var nearby []*NearbyProfile
+	for _, p := range profiles {
+		distance := calculateDistance(center, p.Location)
+		if distance <= radiusKm {
+			nearby = append(nearby, &NearbyProfile{IndustryProfile: p, DistanceKm: distance})
+		}
+	}
+
+	sort.Slice(nearby, func(i, j int) bool {
+		return nearby[i].DistanceKm < nearby[j].DistanceKm
+	})
+
+	return nearby, nil
+}
+
+// normalizeCostRange validates a cost range from the model, upper-casing the
+// currency and swapping a reversed range. It returns nil for a missing or
+// unusable range: negative amounts or a currency that isn't a 3-letter code.
+func normalizeCostRange(r *CostRange) *CostRange {
+	if r == nil || r.Low < 0 || r.High < 0 {
+		return nil
+	}
+	currency := strings.ToUpper(strings.TrimSpace(r.Currency))
+	if len(currency) != 3 || strings.IndexFunc(currency, func(c rune) bool { return c < 'A' || c > 'Z' }) >= 0 {
+		return nil
+	}
+
+	normalized := &CostRange{Low: r.Low, High: r.High, Currency: currency}
+	if normalized.High < normalized.Low {
+		normalized.Low, normalized.High = normalized.High, normalized.Low
 	}
-	return defaultVal
+	return normalized
 }
 
-func getString(m map[string]interface{}, key string, defaultVal string) string {
-	if val, ok := m[key].(string); ok {
-		return val
+// defaultString returns val, or defaultVal when val is empty
+func defaultString(val, defaultVal string) string {
+	if val == "" {
+		return defaultVal
 	}
-	return defaultVal
+	return val
 }
\ No newline at end of file
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestCalculateDistance(t *testing.T) {
+	london := Location{Lat: 51.5074, Lng: -0.1278}
+	paris := Location{Lat: 48.8566, Lng: 2.3522}
+
+	tests := []struct {
+		name        string
+		from, to    Location
+		want        float64
+		toleranceKm float64
+	}{
+		{"identical points", london, london, 0, 0.001},
+		{"antipodes on the equator", Location{Lat: 0, Lng: 0}, Location{Lat: 0, Lng: 180}, math.Pi * earthRadiusKm, 0.001},
+		{"antipodes through the poles", Location{Lat: 90, Lng: 0}, Location{Lat: -90, Lng: 0}, math.Pi * earthRadiusKm, 0.001},
+		{"London to Paris", london, paris, 344, 2},
+		{"Paris to London", paris, london, 344, 2},
+		{"across the antimeridian", Location{Lat: 0, Lng: 179.5}, Location{Lat: 0, Lng: -179.5}, 111.19, 0.1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := calculateDistance(tt.from, tt.to)
+			if math.Abs(got-tt.want) > tt.toleranceKm {
+				t.Errorf("calculateDistance = %.3f km, want %.3f ± %.3f", got, tt.want, tt.toleranceKm)
+			}
+		})
+	}
+}
+
+func TestCalculateMatchScore(t *testing.T) {
+	london := Location{Lat: 51.5074, Lng: -0.1278}
+	nearby := Location{Lat: 51.52, Lng: -0.1}
+	paris := Location{Lat: 48.8566, Lng: 2.3522}
+	newYork := Location{Lat: 40.7128, Lng: -74.0060}
+
+	perDay := func(tonnes float64) *Quantity { return &Quantity{Value: tonnes, Unit: "t", Period: PeriodDay} }
+	slag := Output{Name: "steel slag", State: "solid", Amount: perDay(10)}
+
+	tests := []struct {
+		name       string
+		consumerAt Location
+		input      *Input // the consumer's only input, if any
+		conversion ConversionEstimate
+		want       float64
+	}{
+		{
+			name:       "no conversion, low complexity, near",
+			consumerAt: nearby,
+			conversion: ConversionEstimate{ConversionNeeded: false, Complexity: "low"},
+			want:       1.0,
+		},
+		{
+			name:       "conversion, medium complexity, within 500 km",
+			consumerAt: paris,
+			conversion: ConversionEstimate{ConversionNeeded: true, Complexity: "medium"},
+			want:       0.6,
+		},
+		{
+			name:       "conversion, high complexity, far",
+			consumerAt: newYork,
+			conversion: ConversionEstimate{ConversionNeeded: true, Complexity: "high"},
+			want:       0.4,
+		},
+		{
+			name:       "clamped to 1",
+			consumerAt: nearby,
+			input:      &Input{Name: "slag", States: []string{"solid"}, Amount: perDay(12)},
+			conversion: ConversionEstimate{ConversionNeeded: false, Complexity: "low"},
+			want:       1.0,
+		},
+		{
+			name:       "every penalty",
+			consumerAt: newYork,
+			input:      &Input{Name: "slag", States: []string{"liquid"}, Amount: perDay(500)},
+			conversion: ConversionEstimate{ConversionNeeded: true, Complexity: "high"},
+			want:       0.25,
+		},
+		{
+			name:       "accepted state and volume fit",
+			consumerAt: newYork,
+			input:      &Input{Name: "slag", States: []string{"Solid"}, Amount: perDay(8)},
+			conversion: ConversionEstimate{ConversionNeeded: true},
+			want:       0.65,
+		},
+		{
+			name:       "incomparable volumes",
+			consumerAt: newYork,
+			input:      &Input{Name: "slag", Amount: &Quantity{Value: 500, Unit: "l", Period: PeriodDay}},
+			conversion: ConversionEstimate{ConversionNeeded: true},
+			want:       0.5,
+		},
+		{
+			name:       "missing location",
+			consumerAt: Location{},
+			conversion: ConversionEstimate{ConversionNeeded: false, Complexity: "low"},
+			want:       0.85,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer := &IndustryProfile{Name: "Acme Steel", Location: london, ExtractionConfidence: 1}
+			consumer := &IndustryProfile{Name: "Cement Works", Location: tt.consumerAt, ExtractionConfidence: 1}
+			if tt.input != nil {
+				consumer.Inputs = []Input{*tt.input}
+			}
+
+			got := calculateMatchScore(producer, consumer, slag, &tt.conversion)
+			if got < 0 || got > 1 {
+				t.Fatalf("calculateMatchScore = %v, outside [0, 1]", got)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("calculateMatchScore = %.3f, want %.3f", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCalculateMatchScoreUnknownLocation checks that a missing location adds
+// nothing, where two (0, 0) locations would otherwise score as adjacent
+func TestCalculateMatchScoreUnknownLocation(t *testing.T) {
+	known := Location{Lat: 51.5074, Lng: -0.1278}
+	nearby := Location{Lat: 51.52, Lng: -0.1}
+	conversion := &ConversionEstimate{ConversionNeeded: true}
+	waste := Output{Name: "steel slag"}
+
+	tests := []struct {
+		name               string
+		producer, consumer Location
+		want               float64
+	}{
+		{"both known and close", known, nearby, 0.65},
+		{"producer unknown", Location{}, nearby, 0.5},
+		{"consumer unknown", known, Location{}, 0.5},
+		{"both unknown", Location{}, Location{}, 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer := &IndustryProfile{Name: "Producer", Location: tt.producer, ExtractionConfidence: 1}
+			consumer := &IndustryProfile{Name: "Consumer", Location: tt.consumer, ExtractionConfidence: 1}
+			if got := calculateMatchScore(producer, consumer, waste, conversion); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("calculateMatchScore = %.3f, want %.3f", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCalculateMatchScoreConfidence checks that a pair extracted with low
+// confidence scores below the same pair extracted with high confidence,
+// whether or not the profiles still await review
+func TestCalculateMatchScoreConfidence(t *testing.T) {
+	london := Location{Lat: 51.5074, Lng: -0.1278}
+	conversion := &ConversionEstimate{ConversionNeeded: false, Complexity: "low"}
+	waste := Output{Name: "steel slag"}
+
+	score := func(confidence float64, needsReview bool) float64 {
+		producer := &IndustryProfile{Name: "Acme Steel", Location: london, ExtractionConfidence: confidence, NeedsReview: needsReview}
+		consumer := &IndustryProfile{Name: "Cement Works", Location: london, ExtractionConfidence: confidence, NeedsReview: needsReview}
+		return calculateMatchScore(producer, consumer, waste, conversion)
+	}
+
+	high, low := score(1, false), score(0.4, false)
+	if math.Abs(high-1) > 1e-9 {
+		t.Errorf("high-confidence score = %.3f, want 1", high)
+	}
+	// Each profile scales the score by 0.5 + 0.5*0.4 = 0.7
+	if math.Abs(low-0.49) > 1e-9 {
+		t.Errorf("low-confidence score = %.3f, want 0.49", low)
+	}
+	if approved := score(0.4, false); approved != score(0.4, true) {
+		t.Errorf("approved low-confidence score = %.3f, want the same as under review, %.3f", approved, score(0.4, true))
+	}
+}
+
+// TestFilterByScoreSkipsNonMatches checks that the streams heuristic
+// evaluation scores without matching are never kept for saving, even with no
+// minimum score
+func TestFilterByScoreSkipsNonMatches(t *testing.T) {
+	producer := &IndustryProfile{ID: "producer", Name: "Acme Steel",
+		Outputs: []Output{{Name: "steel slag", State: "solid"}, {Name: "waste heat", State: "gas"}}}
+	candidate := &IndustryProfile{ID: "candidate", Name: "Cement Works",
+		Inputs: []Input{{Name: "granulated slag", States: []string{"solid"}}}}
+
+	matches := evaluatePairHeuristic(producer, candidate)
+	if len(matches) != 2 {
+		t.Fatalf("evaluatePairHeuristic returned %d matches, want one per waste stream", len(matches))
+	}
+
+	kept := filterByScore(context.Background(), matches, 0)
+	if len(kept) != 1 || kept[0].WasteID != "steel slag" {
+		var names []string
+		for _, match := range kept {
+			names = append(names, match.WasteID)
+		}
+		t.Errorf("filterByScore kept %q, want only steel slag", names)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AddProfileOutputHandler appends one waste stream to a profile and matches
+// just that stream, instead of re-running matching for the whole profile
+func AddProfileOutputHandler(c *gin.Context) {
+	var req OutputRequest
+	if !bindStrictJSON(c, &req) {
+		return
+	}
+	output := req.Output()
+	output.Name = strings.TrimSpace(output.Name)
+	if output.Name == "" {
+		respondError(c, http.StatusBadRequest, "Output name is required")
+		return
+	}
+
+	profile, ok := editableProfile(c)
+	if !ok {
+		return
+	}
+	if slices.ContainsFunc(profile.Outputs, func(o Output) bool { return o.Name == output.Name }) {
+		respondError(c, http.StatusConflict, "Profile already has an output named "+output.Name)
+		return
+	}
+
+	profile.Outputs = append(profile.Outputs, output)
+	saveProfileEdit(c, profile, matchScope{}, &matchScope{Output: output.Name}, http.StatusCreated)
+}
+
+// RemoveProfileOutputHandler removes one waste stream from a profile along
+// with its pending matches. Nothing else needs re-matching.
+func RemoveProfileOutputHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	profile, ok := editableProfile(c)
+	if !ok {
+		return
+	}
+	i := slices.IndexFunc(profile.Outputs, func(o Output) bool { return o.Name == name })
+	if i < 0 {
+		respondError(c, http.StatusNotFound, "Output not found")
+		return
+	}
+
+	profile.Outputs = slices.Delete(profile.Outputs, i, i+1)
+	saveProfileEdit(c, profile, matchScope{Output: name}, nil, http.StatusOK)
+}
+
+// AddProfileInputHandler appends one input to a profile and matches other
+// profiles' waste streams against the profile's inputs only
+func AddProfileInputHandler(c *gin.Context) {
+	var req InputRequest
+	if !bindStrictJSON(c, &req) {
+		return
+	}
+	input := req.Input()
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		respondError(c, http.StatusBadRequest, "Input name is required")
+		return
+	}
+
+	profile, ok := editableProfile(c)
+	if !ok {
+		return
+	}
+	if slices.ContainsFunc(profile.Inputs, func(in Input) bool { return in.Name == input.Name }) {
+		respondError(c, http.StatusConflict, "Profile already has an input named "+input.Name)
+		return
+	}
+
+	profile.Inputs = append(profile.Inputs, input)
+	saveProfileEdit(c, profile, matchScope{}, &matchScope{Inputs: true}, http.StatusCreated)
+}
+
+// RemoveProfileInputHandler removes one input from a profile. Pending matches
+// offering the profile waste may have relied on it, so they are cleared and
+// regenerated against the remaining inputs.
+func RemoveProfileInputHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	profile, ok := editableProfile(c)
+	if !ok {
+		return
+	}
+	i := slices.IndexFunc(profile.Inputs, func(in Input) bool { return in.Name == name })
+	if i < 0 {
+		respondError(c, http.StatusNotFound, "Input not found")
+		return
+	}
+
+	profile.Inputs = slices.Delete(profile.Inputs, i, i+1)
+	var rematch *matchScope
+	if len(profile.Inputs) > 0 {
+		rematch = &matchScope{Inputs: true}
+	}
+	saveProfileEdit(c, profile, matchScope{Inputs: true}, rematch, http.StatusOK)
+}
+
+// editableProfile loads the caller's profile named in the path for an edit.
+// If the version query parameter is given, the profile must still be at that
+// version, so an edit based on a stale copy gets 409 instead of undoing
+// someone else's change.
+func editableProfile(c *gin.Context) (*IndustryProfile, bool) {
+	expected := 0
+	if v := c.Query("version"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			respondError(c, http.StatusBadRequest, "version must be a positive integer")
+			return nil, false
+		}
+		expected = n
+	}
+
+	profile, ok := ownedProfile(c, c.Param("profile_id"))
+	if !ok {
+		return nil, false
+	}
+	if expected != 0 && profile.Version != expected {
+		// Tell the client the version to reload at
+		c.JSON(http.StatusConflict, APIResponse{
+			Error: &APIError{Code: ErrCodeVersionConflict, Message: "Profile has changed since that version"},
+			Meta:  gin.H{"version": profile.Version},
+		})
+		return nil, false
+	}
+	return profile, true
+}
+
+// saveProfileEdit saves an edited profile, deleting the pending matches in
+// stale, queues match generation for rematch unless it is nil, and responds
+// with the profile. A concurrent edit since the profile was loaded gets 409.
+func saveProfileEdit(c *gin.Context, profile *IndustryProfile, stale matchScope, rematch *matchScope, status int) {
+	parseProfileQuantities(profile)
+	if unknown := normalizeProfileStates(profile); len(unknown) > 0 {
+		requestLogger(c).Warn("Stored unrecognised material states as other", "states", unknown)
+	}
+	canonicalizeProfile(c.Request.Context(), profile)
+	profile.UpdatedAt = time.Now()
+
+	err := SaveProfileEdit(profile, stale)
+	if err == ErrVersionConflict {
+		respondErrorCode(c, http.StatusConflict, ErrCodeVersionConflict, "Profile was modified concurrently; reload it and retry")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("Failed to save profile", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to update profile")
+		return
+	}
+
+	if rematch != nil {
+		if _, err := queueScopedMatchGeneration(asyncContext(c), profile.ID, profile.OwnerID, *rematch); err != nil {
+			requestLogger(c).Error("Failed to queue match generation", "error", err)
+		}
+	}
+
+	respondData(c, status, profile)
+}
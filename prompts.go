@@ -0,0 +1,159 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// The prompt for each MCP operation is a text/template in prompts/, named
+// after its file, e.g. prompts/extract.tmpl is "extract". The defaults are
+// built in; PROMPTS_DIR can point at a directory of .tmpl files replacing any
+// of them, so prompt wording can be tuned without a rebuild.
+//
+//go:embed prompts/*.tmpl
+var promptFiles embed.FS
+
+// Prompt template names
+const (
+	promptExtract      = "extract"
+	promptClassify     = "classify"
+	promptCanonicalize = "canonicalize"
+	promptMatch        = "match"
+	promptConvert      = "convert"
+	promptConvertBatch = "convert_batch"
+	promptExplain      = "explain"
+)
+
+// promptWaste describes a waste stream to a prompt template
+type promptWaste struct {
+	Name, State, Quantity string
+}
+
+// promptConsumer describes a candidate consumer to a prompt template
+type promptConsumer struct {
+	Index    int // position in a batch; unused elsewhere
+	Name     string
+	Inputs   string // as listed by describeInputs
+	Language string // name of the consumer's language
+}
+
+// Template data for each prompt
+type (
+	extractPrompt      struct{ Text, Language string }
+	classifyPrompt     struct{ Name, State string }
+	canonicalizePrompt struct{ Name string }
+	matchPrompt        struct {
+		Waste      promptWaste
+		Candidates []string
+	}
+	convertPrompt struct {
+		Waste       promptWaste
+		TargetInput string
+	}
+	convertBatchPrompt struct {
+		Waste     promptWaste
+		Consumers []promptConsumer
+		Language  string // name of the producer's language
+	}
+	explainPrompt struct {
+		Waste      promptWaste
+		Consumer   promptConsumer
+		Conversion struct {
+			Needed                  bool
+			Description, Complexity string
+		}
+		Perspective string
+		Language    string // name of the language to write in
+	}
+)
+
+// promptData holds an empty value of each prompt's data, against which
+// templates are test-rendered when loaded so a reference to a field that
+// doesn't exist fails at startup rather than on the first call
+var promptData = map[string]interface{}{
+	promptExtract:      extractPrompt{},
+	promptClassify:     classifyPrompt{},
+	promptCanonicalize: canonicalizePrompt{},
+	promptMatch:        matchPrompt{},
+	promptConvert:      convertPrompt{},
+	promptConvertBatch: convertBatchPrompt{},
+	promptExplain:      explainPrompt{},
+}
+
+// builtinPrompts are the prompt templates shipped in prompts/
+var builtinPrompts = template.Must(parsePrompts(template.New("prompts"), promptFiles, "prompts"))
+
+// loadPrompts returns the prompt templates, with those in dir, if not empty,
+// replacing the built-in ones of the same name
+func loadPrompts(dir string) (*template.Template, error) {
+	if dir == "" {
+		return builtinPrompts, nil
+	}
+	// A mistyped directory would otherwise silently leave the defaults in use
+	if info, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	prompts, err := builtinPrompts.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return parsePrompts(prompts, os.DirFS(dir), ".")
+}
+
+// parsePrompts adds the .tmpl files in dir of fsys to prompts, each named
+// after its file, and checks that each renders
+func parsePrompts(prompts *template.Template, fsys fs.FS, dir string) (*template.Template, error) {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".tmpl")
+		data, ok := promptData[name]
+		if !ok {
+			return nil, fmt.Errorf("prompt template %s doesn't match any prompt", file)
+		}
+
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := prompts.New(name).Parse(string(content)); err != nil {
+			return nil, fmt.Errorf("invalid prompt template %s: %w", file, err)
+		}
+		if err := prompts.ExecuteTemplate(new(strings.Builder), name, data); err != nil {
+			return nil, fmt.Errorf("invalid prompt template %s: %w", file, err)
+		}
+	}
+	return prompts, nil
+}
+
+// renderPrompt renders the named prompt template with data
+func (m *MCPClient) renderPrompt(name string, data interface{}) (string, error) {
+	if m == nil {
+		return "", errNoLLMClient
+	}
+	var prompt strings.Builder
+	if err := m.prompts.ExecuteTemplate(&prompt, name, data); err != nil {
+		return "", fmt.Errorf("failed to render %s prompt: %w", name, err)
+	}
+	return strings.TrimSpace(prompt.String()), nil
+}
+
+// newPromptConsumer describes a candidate consumer for prompt templates
+func newPromptConsumer(candidate *IndustryProfile) promptConsumer {
+	return promptConsumer{Name: candidate.Name, Inputs: describeInputs(candidate.Inputs), Language: languageName(candidate.Language)}
+}
+
+// newPromptWaste describes a waste stream for prompt templates
+func newPromptWaste(waste Output) promptWaste {
+	return promptWaste{Name: waste.Name, State: waste.State, Quantity: waste.displayQuantity()}
+}
@@ -14,24 +14,30 @@ def health():
 
 @app.route('/parse', methods=['POST'])
 def parse_document():
-    """Parse uploaded document and extract industry profile"""
+    """Parse uploaded document(s) and extract a single industry profile"""
     try:
         data = request.json
-        file_url = data.get('file_url')
-        filename = data.get('filename')
+        files = data.get('files')
+        if not files:
+            files = [{"file_url": data.get('file_url'), "filename": data.get('filename')}]
         
-        if not file_url or not filename:
+        if any(not f.get('file_url') or not f.get('filename') for f in files):
             return jsonify({"error": "Missing file_url or filename"}), 400
         
-        # Download file
-        local_path = download_file(file_url, filename)
+        parsed = []
+        for f in files:
+            # Download file
+            local_path = download_file(f['file_url'], f['filename'])
+            
+            try:
+                # Parse document
+                parsed.append(parser.parse(local_path))
+            finally:
+                # Clean up
+                if os.path.exists(local_path):
+                    os.remove(local_path)
         
-        # Parse document
-        profile_data = parser.parse(local_path)
-        
-        # Clean up
-        if os.path.exists(local_path):
-            os.remove(local_path)
+        profile_data = merge_profiles(parsed)
         
         # Create industry profile structure
         profile = {
@@ -40,6 +46,7 @@ def parse_document():
             "location": profile_data.get("location", {"lat": 0.0, "lng": 0.0}),
             "inputs": profile_data.get("inputs", []),
             "outputs": profile_data.get("outputs", []),
+            "extraction_confidence": extraction_confidence(profile_data),
             "created_at": datetime.utcnow().isoformat(),
             "updated_at": datetime.utcnow().isoformat()
         }
@@ -49,6 +56,45 @@ def parse_document():
     except Exception as e:
         return jsonify({"error": str(e)}), 500
 
+def extraction_confidence(profile_data):
+    """Rate an extraction by how much of the profile the parser found"""
+    name = profile_data.get("name")
+    location = profile_data.get("location") or {}
+    found = [
+        bool(name) and name != "Unknown Company",
+        bool(location.get("lat") or location.get("lng")),
+        bool(profile_data.get("inputs")),
+        bool(profile_data.get("outputs")),
+    ]
+    return sum(found) / len(found)
+
+def merge_profiles(parsed):
+    """Merge profiles parsed from several documents describing one company"""
+    if len(parsed) == 1:
+        return parsed[0]
+    
+    merged = {"inputs": [], "outputs": []}
+    seen_outputs = set()
+    for data in parsed:
+        # First meaningful name and location win
+        name = data.get("name")
+        if name and name != "Unknown Company" and "name" not in merged:
+            merged["name"] = name
+        location = data.get("location")
+        if location and (location.get("lat") or location.get("lng")) and "location" not in merged:
+            merged["location"] = location
+        
+        for item in data.get("inputs", []):
+            if item not in merged["inputs"]:
+                merged["inputs"].append(item)
+        for output in data.get("outputs", []):
+            key = output.get("name", "").lower()
+            if key not in seen_outputs:
+                seen_outputs.add(key)
+                merged["outputs"].append(output)
+    
+    return merged
+
 def download_file(url, filename):
     """Download file from URL to local temp directory"""
     temp_dir = "/tmp/industrial_symbiosis"
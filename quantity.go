@@ -0,0 +1,193 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Quantity periods
+const (
+	PeriodHour  = "per_hour"
+	PeriodDay   = "per_day"
+	PeriodWeek  = "per_week"
+	PeriodMonth = "per_month"
+	PeriodYear  = "per_year"
+)
+
+// quantityUnits maps unit spellings to their canonical form
+var quantityUnits = map[string]string{
+	"g": "g", "gram": "g", "grams": "g",
+	"kg": "kg", "kgs": "kg", "kilo": "kg", "kilos": "kg", "kilogram": "kg", "kilograms": "kg",
+	"t": "t", "ton": "t", "tons": "t", "tonne": "t", "tonnes": "t", "mt": "t",
+	"lb": "lb", "lbs": "lb", "pound": "lb", "pounds": "lb",
+	"l": "l", "liter": "l", "liters": "l", "litre": "l", "litres": "l",
+	"m3": "m3", "m³": "m3", "cubic": "m3", "cubic meter": "m3", "cubic meters": "m3", "cubic metre": "m3", "cubic metres": "m3",
+	"gal": "gal", "gallon": "gal", "gallons": "gal",
+	"kwh": "kwh", "mwh": "mwh",
+}
+
+// quantityPeriods maps period spellings to their canonical form
+var quantityPeriods = map[string]string{
+	"h": PeriodHour, "hr": PeriodHour, "hour": PeriodHour, "hourly": PeriodHour,
+	"d": PeriodDay, "day": PeriodDay, "daily": PeriodDay,
+	"wk": PeriodWeek, "week": PeriodWeek, "weekly": PeriodWeek,
+	"mo": PeriodMonth, "month": PeriodMonth, "monthly": PeriodMonth,
+	"y": PeriodYear, "yr": PeriodYear, "year": PeriodYear, "yearly": PeriodYear, "annual": PeriodYear, "annually": PeriodYear, "annum": PeriodYear,
+	PeriodHour: PeriodHour, PeriodDay: PeriodDay, PeriodWeek: PeriodWeek, PeriodMonth: PeriodMonth, PeriodYear: PeriodYear,
+}
+
+// quantityPattern matches "5 tons/day", "1,200 kg per week", "3.5 m3 daily", etc.
+// Groups: value, unit, period after a separator, trailing period adverb.
+var quantityPattern = regexp.MustCompile(`(?i)(\d[\d,]*(?:\.\d+)?|\.\d+)\s*((?:cubic\s+)?[a-z]+[3³]?|m³)(?:\s*(?:/|per\s|a\s|an\s|each\s)\s*([a-z_]+)|\s+([a-z]+))?`)
+
+// unitScales maps each canonical unit to its dimension and its size in that
+// dimension's base unit (kg for mass, l for volume, kWh for energy)
+var unitScales = map[string]struct {
+	dimension string
+	scale     float64
+}{
+	"g":   {"mass", 0.001},
+	"kg":  {"mass", 1},
+	"t":   {"mass", 1000},
+	"lb":  {"mass", 0.453592},
+	"l":   {"volume", 1},
+	"m3":  {"volume", 1000},
+	"gal": {"volume", 3.78541},
+	"kwh": {"energy", 1},
+	"mwh": {"energy", 1000},
+}
+
+// baseUnits names the base unit of each dimension in unitScales
+var baseUnits = map[string]string{
+	"mass":   "kg",
+	"volume": "l",
+	"energy": "kwh",
+}
+
+// periodDays is the length of each period in days
+var periodDays = map[string]float64{
+	PeriodHour:  1.0 / 24,
+	PeriodDay:   1,
+	PeriodWeek:  7,
+	PeriodMonth: 365.25 / 12,
+	PeriodYear:  365.25,
+}
+
+// ParseQuantity extracts a structured quantity from free text such as
+// "about 5 tons/day". It returns nil if no amount with a known unit is found.
+func ParseQuantity(raw string) *Quantity {
+	for _, m := range quantityPattern.FindAllStringSubmatch(raw, -1) {
+		unit, ok := quantityUnits[strings.ToLower(m[2])]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.ReplaceAll(m[1], ",", ""), 64)
+		if err != nil {
+			continue
+		}
+
+		q := &Quantity{Value: value, Unit: unit}
+		if period, ok := quantityPeriods[strings.ToLower(m[3])]; ok {
+			q.Period = period
+		} else if period, ok := quantityPeriods[strings.ToLower(m[4])]; ok && len(m[4]) > 2 {
+			// Only whole words like "daily" count without a separator
+			q.Period = period
+		}
+		return q
+	}
+	return nil
+}
+
+// normalizeQuantity canonicalizes the unit and period of a quantity supplied
+// in structured form, e.g. by Gemini or an API client
+func normalizeQuantity(q *Quantity) {
+	if unit, ok := quantityUnits[strings.ToLower(strings.TrimSpace(q.Unit))]; ok {
+		q.Unit = unit
+	} else {
+		q.Unit = strings.ToLower(strings.TrimSpace(q.Unit))
+	}
+	if period, ok := quantityPeriods[strings.ToLower(strings.TrimSpace(q.Period))]; ok {
+		q.Period = period
+	} else {
+		q.Period = ""
+	}
+}
+
+// parseOutputQuantities fills in each output's structured amount from its
+// raw quantity string where possible
+func parseOutputQuantities(outputs []Output) {
+	for i := range outputs {
+		if outputs[i].Amount != nil {
+			normalizeQuantity(outputs[i].Amount)
+			continue
+		}
+		outputs[i].Amount = ParseQuantity(outputs[i].Quantity)
+	}
+}
+
+// dailyRate converts a quantity to its dimension's base unit per day, e.g.
+// 2 t/month becomes about 65.7 kg per day. ok is false when the unit is
+// unknown or the quantity has no period.
+func (q *Quantity) dailyRate() (rate float64, dimension string, ok bool) {
+	unit, known := unitScales[q.Unit]
+	days, hasPeriod := periodDays[q.Period]
+	if !known || !hasPeriod {
+		return 0, "", false
+	}
+	return q.Value * unit.scale / days, unit.dimension, true
+}
+
+// supplyRatio compares a supplied quantity to a demanded one, returning
+// supply divided by demand. ok is false when the two can't be compared:
+// different dimensions (mass vs volume), a missing period, or zero demand.
+func supplyRatio(supply, demand *Quantity) (ratio float64, ok bool) {
+	supplyRate, supplyDim, ok := supply.dailyRate()
+	if !ok {
+		return 0, false
+	}
+	demandRate, demandDim, ok := demand.dailyRate()
+	if !ok || supplyDim != demandDim || demandRate <= 0 {
+		return 0, false
+	}
+	return supplyRate / demandRate, true
+}
+
+// parseInputQuantities fills in each input's structured amount from its raw
+// quantity, or from its name for inputs like "scrap steel 20 tons/month"
+func parseInputQuantities(inputs []Input) {
+	for i := range inputs {
+		if inputs[i].Amount != nil {
+			normalizeQuantity(inputs[i].Amount)
+			continue
+		}
+		raw := inputs[i].Quantity
+		if raw == "" {
+			raw = inputs[i].Name
+		}
+		inputs[i].Amount = ParseQuantity(raw)
+	}
+}
+
+// parseProfileQuantities fills in structured amounts on a profile's inputs and outputs
+func parseProfileQuantities(profile *IndustryProfile) {
+	parseInputQuantities(profile.Inputs)
+	parseOutputQuantities(profile.Outputs)
+}
+
+// String formats a quantity for display and prompts, e.g. "5 t/day"
+func (q *Quantity) String() string {
+	s := strconv.FormatFloat(q.Value, 'f', -1, 64) + " " + q.Unit
+	if q.Period != "" {
+		s += "/" + strings.TrimPrefix(q.Period, "per_")
+	}
+	return s
+}
+
+// displayQuantity returns the structured amount when known, else the raw string
+func (o Output) displayQuantity() string {
+	if o.Amount != nil {
+		return o.Amount.String()
+	}
+	return o.Quantity
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token-bucket limiter that blocks callers until a token is available
+type RateLimiter struct {
+	mu       sync.Mutex
+	tokens   float64
+	capacity float64
+	rate     float64 // tokens added per second
+	last     time.Time
+}
+
+// NewRateLimiter creates a limiter allowing perMinute requests with bursts up to burst
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		tokens:   float64(burst),
+		capacity: float64(burst),
+		rate:     float64(perMinute) / 60.0,
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until a request may proceed or ctx is cancelled
+func (r *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		r.mu.Lock()
+		now := time.Now()
+		r.tokens += now.Sub(r.last).Seconds() * r.rate
+		if r.tokens > r.capacity {
+			r.tokens = r.capacity
+		}
+		r.last = now
+
+		if r.tokens >= 1 {
+			r.tokens--
+			r.mu.Unlock()
+			return nil
+		}
+
+		wait := time.Duration((1 - r.tokens) / r.rate * float64(time.Second))
+		r.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
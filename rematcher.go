@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// rematcher periodically regenerates matches for profiles matched before
+// newer profiles arrived, including those that found none, so a profile
+// isn't stuck with the candidates that existed when it was uploaded. Each run
+// only evaluates the candidates added or updated since the profile's match
+// watermark.
+type rematcher struct {
+	interval   time.Duration
+	staleAfter time.Duration
+	batchSize  int
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+var profileRematcher *rematcher
+
+// InitRematcher starts the rematch job if REMATCH_ENABLED is set. Every
+// REMATCH_INTERVAL it queues match generation for up to REMATCH_BATCH_SIZE
+// profiles whose last run is older than REMATCH_STALE_AFTER and predates
+// another profile's upload or update.
+func InitRematcher() error {
+	if !getEnvBool("REMATCH_ENABLED", false) {
+		return nil
+	}
+
+	r := &rematcher{
+		interval:   getEnvDuration("REMATCH_INTERVAL", time.Hour),
+		staleAfter: getEnvDuration("REMATCH_STALE_AFTER", 24*time.Hour),
+		batchSize:  getEnvInt("REMATCH_BATCH_SIZE", 20),
+		stop:       make(chan struct{}),
+	}
+	if r.interval <= 0 {
+		return fmt.Errorf("REMATCH_INTERVAL must be positive")
+	}
+	if r.batchSize < 1 {
+		return fmt.Errorf("REMATCH_BATCH_SIZE must be at least 1")
+	}
+
+	profileRematcher = r
+	go r.run()
+	slog.Info("Rematch job started",
+		"interval", r.interval.String(),
+		"stale_after", r.staleAfter.String(),
+		"batch_size", r.batchSize,
+	)
+	return nil
+}
+
+// StopRematcher stops the rematch job, if it is running. Runs already
+// queued are left to the worker pool.
+func StopRematcher() {
+	if r := profileRematcher; r != nil {
+		r.stopOnce.Do(func() { close(r.stop) })
+	}
+}
+
+func (r *rematcher) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.queueDue()
+		}
+	}
+}
+
+// queueDue queues match generation for the profiles due a rematch. It waits
+// for a later tick while the worker pool has a backlog, so uploads go first.
+// Profiles with a run already queued are skipped; should an upload queue one
+// in the meantime, the second run just rescores the same matches.
+func (r *rematcher) queueDue() {
+	logger := slog.With("job", "rematch")
+	if pending := workerPool.Pending(); pending > 0 {
+		logger.Info("Worker pool busy, postponing rematch", "pending_jobs", pending)
+		return
+	}
+
+	profiles, err := ListProfilesDueForRematch(time.Now().Add(-r.staleAfter), r.batchSize)
+	if err != nil {
+		logger.Error("Failed to list profiles due for rematch", "error", err)
+		return
+	}
+	if len(profiles) == 0 {
+		return
+	}
+
+	ctx := withLogger(workerPool.Context(), logger)
+	queued := 0
+	for _, profile := range profiles {
+		if _, err := queueScopedMatchGeneration(ctx, profile.ID, profile.OwnerID, incrementalScope(profile)); err != nil {
+			logger.Error("Failed to queue match generation", "profile_id", profile.ID, "error", err)
+			continue
+		}
+		queued++
+	}
+	logger.Info("Queued rematch of stale profiles", "profiles", len(profiles), "queued", queued)
+}
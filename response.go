@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIResponse is the envelope of every JSON response. Successful responses
+// carry data and failed ones error; meta describes the response as a whole,
+// e.g. paging for a list in data.
+type APIResponse struct {
+	Data  interface{} `json:"data,omitempty"`
+	Error *APIError   `json:"error,omitempty"`
+	Meta  gin.H       `json:"meta,omitempty"`
+}
+
+// APIError describes why a request failed. Code is stable for clients to
+// branch on; Message is for people and may change.
+type APIError struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"` // for ErrCodeInvalidBody
+}
+
+// Error codes. Most follow from the HTTP status; the rest narrow one down.
+const (
+	ErrCodeBadRequest      = "bad_request"
+	ErrCodeInvalidBody     = "invalid_request_body" // 400 with the offending fields
+	ErrCodeUnauthorized    = "unauthorized"
+	ErrCodeForbidden       = "forbidden"
+	ErrCodeNotFound        = "not_found"
+	ErrCodeTimeout         = "request_timeout"
+	ErrCodeConflict        = "conflict"
+	ErrCodeVersionConflict = "version_conflict" // 409 for a stale or concurrent profile edit
+	ErrCodeGone            = "gone"
+	ErrCodeTooLarge        = "payload_too_large"
+	ErrCodeInternal        = "internal_error"
+	ErrCodeUnavailable     = "service_unavailable"
+)
+
+// errorCodeForStatus returns the error code for an HTTP status
+func errorCodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrCodeBadRequest
+	case http.StatusUnauthorized:
+		return ErrCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrCodeForbidden
+	case http.StatusNotFound:
+		return ErrCodeNotFound
+	case http.StatusRequestTimeout:
+		return ErrCodeTimeout
+	case http.StatusConflict:
+		return ErrCodeConflict
+	case http.StatusGone:
+		return ErrCodeGone
+	case http.StatusRequestEntityTooLarge:
+		return ErrCodeTooLarge
+	case http.StatusServiceUnavailable:
+		return ErrCodeUnavailable
+	}
+	if status >= http.StatusInternalServerError {
+		return ErrCodeInternal
+	}
+	return ErrCodeBadRequest
+}
+
+// respondData responds with data in the envelope
+func respondData(c *gin.Context, status int, data interface{}) {
+	c.JSON(status, APIResponse{Data: data})
+}
+
+// respondList responds 200 with a list, or other data, and meta describing
+// it, e.g. count, total, limit, and offset
+func respondList(c *gin.Context, data interface{}, meta gin.H) {
+	c.JSON(http.StatusOK, APIResponse{Data: data, Meta: meta})
+}
+
+// respondError responds with an error whose code follows from status
+func respondError(c *gin.Context, status int, message string) {
+	respondErrorCode(c, status, errorCodeForStatus(status), message)
+}
+
+// respondErrorCode responds with an error with a specific code
+func respondErrorCode(c *gin.Context, status int, code, message string) {
+	c.JSON(status, APIResponse{Error: &APIError{Code: code, Message: message}})
+}
+
+// abortWithError responds as respondError and stops the handler chain, for
+// middleware
+func abortWithError(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, APIResponse{Error: &APIError{Code: errorCodeForStatus(status), Message: message}})
+}
+
+// respondFieldErrors responds 400 listing the fields of a request body that
+// failed validation
+func respondFieldErrors(c *gin.Context, fields []FieldError) {
+	c.JSON(http.StatusBadRequest, APIResponse{Error: &APIError{Code: ErrCodeInvalidBody, Message: "Invalid request body", Fields: fields}})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3RequestTimeout bounds each S3 call, uploads included
+const s3RequestTimeout = 5 * time.Minute
+
+// S3Storage stores files in an S3-compatible bucket through the AWS SDK
+type S3Storage struct {
+	bucket   string
+	client   *s3.Client
+	uploader *manager.Uploader
+	presign  *s3.PresignClient
+}
+
+// NewS3Storage creates an S3 storage backend from environment variables.
+// Credentials come from the SDK's default chain: AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY, a shared profile, or an instance or task role.
+func NewS3Storage() (*S3Storage, error) {
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("S3_BUCKET not set")
+	}
+
+	region := os.Getenv("S3_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	// A custom endpoint (MinIO, R2, etc.) uses path-style addressing unless
+	// S3_FORCE_PATH_STYLE says otherwise
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid S3_ENDPOINT %q", endpoint)
+		}
+	}
+	pathStyle := getEnvBool("S3_FORCE_PATH_STYLE", endpoint != "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(region),
+		config.WithHTTPClient(awshttp.NewBuildableClient().WithTimeout(s3RequestTimeout)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	// Fail at startup rather than on the first upload
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		return nil, fmt.Errorf("no AWS credentials for S3 storage: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = pathStyle
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			// Not every S3-compatible store accepts the checksums the SDK
+			// now sends by default
+			o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+			o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+		}
+	})
+
+	return &S3Storage{
+		bucket:   bucket,
+		client:   client,
+		uploader: manager.NewUploader(client),
+		presign:  s3.NewPresignClient(client),
+	}, nil
+}
+
+// Put streams the reader to the bucket under the given key, in parts if it is large
+func (s *S3Storage) Put(reader io.Reader, key, contentType string) error {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   reader,
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+
+	if _, err := s.uploader.Upload(context.Background(), input); err != nil {
+		return fmt.Errorf("failed to upload to S3: %w", err)
+	}
+	return nil
+}
+
+// Get fetches an object from the bucket
+func (s *S3Storage) Get(key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch from S3: %w", err)
+	}
+	return out.Body, nil
+}
+
+// Exists reports whether an object is present in the bucket
+func (s *S3Storage) Exists(key string) (bool, error) {
+	_, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return true, nil
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check S3 object: %w", err)
+}
+
+// Delete removes an object from the bucket. Deleting a missing object succeeds.
+func (s *S3Storage) Delete(key string) error {
+	_, err := s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete from S3: %w", err)
+	}
+	return nil
+}
+
+// Presign returns a time-limited GET URL for an object
+func (s *S3Storage) Presign(key string, expiry time.Duration) (string, error) {
+	if expiry <= 0 || expiry > 7*24*time.Hour {
+		return "", fmt.Errorf("presign expiry must be between 1s and 7 days")
+	}
+
+	req, err := s.presign.PresignGetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign S3 URL: %w", err)
+	}
+	return req.URL, nil
+}
+
+// parseS3URL splits an s3://bucket/key URL into bucket and key
+func parseS3URL(fileURL string) (string, string, error) {
+	trimmed := strings.TrimPrefix(fileURL, "s3://")
+	bucket, key, ok := strings.Cut(trimmed, "/")
+	if !ok || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid S3 URL %q", fileURL)
+	}
+	return bucket, key, nil
+}
@@ -1,51 +1,217 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-var uploadDir string
+var (
+	uploadDir         string
+	storageBackend    string
+	s3Storage         *S3Storage
+	fileSigningSecret []byte
+	maxUploadBytes    int64
 
-// InitStorage initializes local file storage
+	storageOnce  sync.Once
+	storageErr   error
+	storageReady atomic.Bool // set once initStorage succeeds; guards the settings above
+)
+
+// ErrFileTooLarge is returned when an upload exceeds MAX_UPLOAD_BYTES
+var ErrFileTooLarge = errors.New("file exceeds maximum upload size")
+
+// InitStorage initializes the configured storage backend (local or s3). Only
+// the first call does so; later calls return its result.
 func InitStorage() error {
-	uploadDir = os.Getenv("UPLOAD_DIR")
-	if uploadDir == "" {
-		uploadDir = "./uploads"
+	storageOnce.Do(func() {
+		// Published last, so checkStorage only passes once every setting is written
+		if storageErr = initStorage(); storageErr == nil {
+			storageReady.Store(true)
+		}
+	})
+	return storageErr
+}
+
+func initStorage() error {
+	maxUploadBytes = getEnvInt64("MAX_UPLOAD_BYTES", 50<<20)
+
+	storageBackend = os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = "local"
+	}
+
+	switch storageBackend {
+	case "local":
+		return initLocalStorage()
+	case "s3":
+		var err error
+		s3Storage, err = NewS3Storage()
+		if err != nil {
+			return fmt.Errorf("failed to initialize S3 storage: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported STORAGE_BACKEND %q (use local or s3)", storageBackend)
+	}
+}
+
+// initLocalStorage initializes local file storage
+func initLocalStorage() error {
+	dir := os.Getenv("UPLOAD_DIR")
+	if dir == "" {
+		dir = "./uploads"
 	}
 
 	// Create upload directory if it doesn't exist
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("failed to create upload directory: %w", err)
 	}
 
+	// Secret used to sign file download URLs
+	if secret := os.Getenv("FILE_SIGNING_SECRET"); secret != "" {
+		fileSigningSecret = []byte(secret)
+	} else {
+		slog.Warn("FILE_SIGNING_SECRET not set, using a random secret; file URLs won't survive restarts")
+		fileSigningSecret = make([]byte, 32)
+		if _, err := rand.Read(fileSigningSecret); err != nil {
+			return fmt.Errorf("failed to generate file signing secret: %w", err)
+		}
+	}
+
+	uploadDir = dir
+	return nil
+}
+
+// checkStorage returns an error wrapping ErrNotInitialized until InitStorage
+// has succeeded
+func checkStorage() error {
+	if !storageReady.Load() {
+		return fmt.Errorf("storage %w", ErrNotInitialized)
+	}
 	return nil
 }
 
-// UploadFile saves a file to local storage and returns the path
-func UploadFile(reader io.Reader, filename string, contentType string, size int64) (string, error) {
-	filePath := filepath.Join(uploadDir, filename)
+// UploadFile saves a file to storage under the SHA-256 of its content plus
+// ext, and returns its path or s3:// URL. If identical content is already
+// stored, nothing is written and the existing file's URL is returned, with
+// existed set.
+func UploadFile(reader io.Reader, ext string, contentType string, size int64) (fileURL string, existed bool, err error) {
+	if err := checkStorage(); err != nil {
+		return "", false, err
+	}
+	if size > maxUploadBytes {
+		return "", false, ErrFileTooLarge
+	}
+
+	if storageBackend == "s3" {
+		return uploadS3File(reader, ext, contentType, size)
+	}
 
-	file, err := os.Create(filePath)
+	// Write to a temporary file, hashing as it streams, then move it to its
+	// content address
+	file, err := os.CreateTemp(uploadDir, ".upload-*")
 	if err != nil {
-		return "", fmt.Errorf("failed to create file: %w", err)
+		return "", false, fmt.Errorf("failed to create file: %w", err)
+	}
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath) // no-op once renamed
+	// CreateTemp makes the file private; keep it readable like other uploads
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		return "", false, fmt.Errorf("failed to create file: %w", err)
 	}
-	defer file.Close()
 
-	_, err = io.Copy(file, reader)
+	hash := sha256.New()
+	// Read one byte past the limit so an oversized stream can be detected
+	written, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(reader, maxUploadBytes+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to write file: %w", err)
+		return "", false, fmt.Errorf("failed to write file: %w", err)
+	}
+	if written > maxUploadBytes {
+		return "", false, ErrFileTooLarge
 	}
 
-	// Return absolute path
+	filePath := filepath.Join(uploadDir, hex.EncodeToString(hash.Sum(nil))+ext)
 	absPath, _ := filepath.Abs(filePath)
-	return absPath, nil
+	if _, err := os.Stat(filePath); err == nil {
+		return absPath, true, nil
+	}
+	// A concurrent upload of the same content may rename first; the
+	// content, and so the result, is the same
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return "", false, fmt.Errorf("failed to store file: %w", err)
+	}
+	return absPath, false, nil
 }
 
-// GetFile retrieves a file from local storage
+// uploadS3File is UploadFile for S3. The object key has to be known before
+// the upload starts, so the content is hashed in a first pass and rewound;
+// reader must therefore be an io.ReadSeeker, as multipart files are.
+func uploadS3File(reader io.Reader, ext, contentType string, size int64) (string, bool, error) {
+	seeker, ok := reader.(io.ReadSeeker)
+	if !ok {
+		return "", false, fmt.Errorf("S3 uploads need a seekable reader")
+	}
+
+	hash := sha256.New()
+	read, err := io.Copy(hash, io.LimitReader(seeker, maxUploadBytes+1))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read file: %w", err)
+	}
+	if read > maxUploadBytes {
+		return "", false, ErrFileTooLarge
+	}
+
+	contentHash := hex.EncodeToString(hash.Sum(nil))
+	key := contentHash + ext
+	fileURL := fmt.Sprintf("s3://%s/%s", s3Storage.bucket, key)
+	exists, err := s3Storage.Exists(key)
+	if err != nil {
+		return "", false, err
+	}
+	if exists {
+		return fileURL, true, nil
+	}
+
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return "", false, fmt.Errorf("failed to rewind file: %w", err)
+	}
+	if err := s3Storage.Put(seeker, key, contentType); err != nil {
+		return "", false, err
+	}
+	return fileURL, false, nil
+}
+
+// GetFile retrieves a file from storage
 func GetFile(filePath string) (io.ReadCloser, error) {
+	if isS3URL(filePath) {
+		if s3Storage == nil {
+			return nil, fmt.Errorf("S3 storage not configured")
+		}
+		_, key, err := parseS3URL(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return s3Storage.Get(key)
+	}
+
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
@@ -53,12 +219,126 @@ func GetFile(filePath string) (io.ReadCloser, error) {
 	return file, nil
 }
 
-// GeneratePresignedURL returns the file path (not used for local storage)
+// FileExists reports whether a stored file is still available
+func FileExists(filePath string) (bool, error) {
+	if isS3URL(filePath) {
+		if s3Storage == nil {
+			return false, fmt.Errorf("S3 storage not configured")
+		}
+		_, key, err := parseS3URL(filePath)
+		if err != nil {
+			return false, err
+		}
+		return s3Storage.Exists(key)
+	}
+
+	_, err := os.Stat(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeleteFile removes a stored file. A file that is already gone is not an error.
+func DeleteFile(filePath string) error {
+	if isS3URL(filePath) {
+		if s3Storage == nil {
+			return fmt.Errorf("S3 storage not configured")
+		}
+		_, key, err := parseS3URL(filePath)
+		if err != nil {
+			return err
+		}
+		return s3Storage.Delete(key)
+	}
+
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+// uploadSize returns the size of a stored local file in bytes, or 0 for S3
+// objects and files that can't be read
+func uploadSize(filePath string) int64 {
+	if isS3URL(filePath) {
+		return 0
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// GeneratePresignedURL returns a time-limited URL for downloading a stored file
 func GeneratePresignedURL(filePath string) (string, error) {
-	return filePath, nil
+	expiry, err := presignExpiry()
+	if err != nil {
+		return "", err
+	}
+
+	if !isS3URL(filePath) {
+		filename := filepath.Base(filePath)
+		expires := time.Now().Add(expiry).Unix()
+		return fmt.Sprintf("/api/v1/files/%s?token=%s&expires=%d",
+			url.PathEscape(filename), signFileToken(filename, expires), expires), nil
+	}
+
+	if s3Storage == nil {
+		return "", fmt.Errorf("S3 storage not configured")
+	}
+
+	_, key, err := parseS3URL(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	return s3Storage.Presign(key, expiry)
+}
+
+// ValidateFileToken checks a signed local file URL's token and expiry
+func ValidateFileToken(filename, token, expiresParam string) bool {
+	expires, err := strconv.ParseInt(expiresParam, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	expected := signFileToken(filename, expires)
+	return hmac.Equal([]byte(expected), []byte(token))
+}
+
+// LocalFilePath returns the path of an uploaded file in the local upload directory
+func LocalFilePath(filename string) string {
+	return filepath.Join(uploadDir, filename)
+}
+
+// signFileToken computes the HMAC token for a filename and expiry
+func signFileToken(filename string, expires int64) string {
+	mac := hmac.New(sha256.New, fileSigningSecret)
+	mac.Write([]byte(fmt.Sprintf("%s:%d", filename, expires)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// presignExpiry returns how long generated file URLs stay valid
+func presignExpiry() (time.Duration, error) {
+	v := os.Getenv("PRESIGN_EXPIRY")
+	if v == "" {
+		return 15 * time.Minute, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid PRESIGN_EXPIRY: %w", err)
+	}
+	return d, nil
 }
 
 // GetFileExtension returns the file extension from filename
 func GetFileExtension(filename string) string {
 	return filepath.Ext(filename)
+}
+
+// isS3URL reports whether a stored file URL points at an S3 object
+func isS3URL(fileURL string) bool {
+	return strings.HasPrefix(fileURL, "s3://")
 }
\ No newline at end of file
@@ -0,0 +1,81 @@
+package main
+
+import "sync"
+
+// TaskEvents is an in-process pub/sub of task updates, so clients can follow
+// a task without polling. Every saved task is published; subscribers only see
+// the latest state, older unread updates being replaced.
+type TaskEvents struct {
+	mu     sync.Mutex
+	subs   map[string]map[chan *Task]struct{}
+	closed bool
+}
+
+// taskEvents receives an update each time a task is saved
+var taskEvents = NewTaskEvents()
+
+// NewTaskEvents creates an empty TaskEvents
+func NewTaskEvents() *TaskEvents {
+	return &TaskEvents{subs: make(map[string]map[chan *Task]struct{})}
+}
+
+// Subscribe returns a channel receiving updates to a task, and a function to
+// unsubscribe. The channel is closed when the TaskEvents is closed.
+func (e *TaskEvents) Subscribe(taskID string) (<-chan *Task, func()) {
+	ch := make(chan *Task, 1)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if e.subs[taskID] == nil {
+		e.subs[taskID] = make(map[chan *Task]struct{})
+	}
+	e.subs[taskID][ch] = struct{}{}
+
+	return ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if _, ok := e.subs[taskID][ch]; !ok {
+			return
+		}
+		delete(e.subs[taskID], ch)
+		if len(e.subs[taskID]) == 0 {
+			delete(e.subs, taskID)
+		}
+		close(ch)
+	}
+}
+
+// Publish sends a copy of task to its subscribers without blocking, replacing
+// any update they haven't read yet
+func (e *TaskEvents) Publish(task *Task) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for ch := range e.subs[task.ID] {
+		update := *task
+		update.FileURLs = append([]string(nil), task.FileURLs...)
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- &update
+	}
+}
+
+// Close ends every subscription, e.g. so open streams don't hold up shutdown
+func (e *TaskEvents) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+	for taskID, subs := range e.subs {
+		for ch := range subs {
+			close(ch)
+		}
+		delete(e.subs, taskID)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxLocalExtractChars caps the document text sent to Gemini by the local
+// extractor, keeping the prompt within the model's input limits
+const maxLocalExtractChars = 100000
+
+// localExtractMaxBytes caps how much the local extractor reads of a stored
+// document, and how much its DOCX text part or PDF streams may decompress
+// to, from LOCAL_EXTRACTION_MAX_BYTES. Compressed content can expand by a
+// factor of a thousand, so this guards against decompression bombs.
+var localExtractMaxBytes int64 = 50 << 20
+
+// errDocumentTooLarge is returned when a document exceeds localExtractMaxBytes
+var errDocumentTooLarge = errors.New("document exceeds LOCAL_EXTRACTION_MAX_BYTES")
+
+// readLimited reads r to the end, failing with errDocumentTooLarge once it
+// has read more than localExtractMaxBytes
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, localExtractMaxBytes+1))
+	if int64(len(data)) > localExtractMaxBytes {
+		return nil, errDocumentTooLarge
+	}
+	return data, err
+}
+
+// extractProfileLocally builds a profile without the Python worker: the text
+// of each document is extracted in Go and handed to Gemini to pick out the
+// company, location, inputs, and outputs. It handles .txt, .docx, and PDFs
+// with plain text content; scanned or CID-font PDFs yield no text. Names are
+// written in lang.
+func extractProfileLocally(ctx context.Context, files []UploadedFile, lang string) (*IndustryProfile, error) {
+	texts := make([]string, 0, len(files))
+	for _, file := range files {
+		text, err := extractDocumentText(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract text from %s: %w", file.Filename, err)
+		}
+		texts = append(texts, text)
+	}
+
+	text := strings.TrimSpace(strings.Join(texts, "\n\n"))
+	if text == "" {
+		return nil, fmt.Errorf("no text could be extracted from the documents")
+	}
+	if runes := []rune(text); len(runes) > maxLocalExtractChars {
+		text = string(runes[:maxLocalExtractChars])
+	}
+
+	fallbackName := strings.TrimSuffix(files[0].Filename, GetFileExtension(files[0].Filename))
+	return profileFromText(ctx, text, fallbackName, lang)
+}
+
+// extractDocumentText reads a stored document and returns its plain text
+func extractDocumentText(file UploadedFile) (string, error) {
+	reader, err := GetFile(file.URL)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	data, err := readLimited(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	switch ext := strings.ToLower(GetFileExtension(file.Filename)); ext {
+	case ".txt":
+		return string(data), nil
+	case ".docx":
+		return extractDOCXText(data)
+	case ".pdf":
+		return extractPDFText(data)
+	default:
+		return "", fmt.Errorf("unsupported file type %q", ext)
+	}
+}
+
+// extractDOCXText returns the text of a DOCX file's main document part, one
+// line per paragraph
+func extractDOCXText(data []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("invalid DOCX file: %w", err)
+	}
+
+	part, err := archive.Open("word/document.xml")
+	if err != nil {
+		return "", fmt.Errorf("invalid DOCX file: %w", err)
+	}
+	defer part.Close()
+
+	document, err := readLimited(part)
+	if err != nil {
+		return "", fmt.Errorf("failed to read DOCX document: %w", err)
+	}
+
+	var text strings.Builder
+	inText := false
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid DOCX document: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				text.WriteByte('\t')
+			case "br", "cr":
+				text.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				text.Write(t)
+			}
+		}
+	}
+
+	return text.String(), nil
+}
+
+// pdfStream matches a PDF stream with the dictionary that precedes it
+var pdfStream = regexp.MustCompile(`(?s)<<((?:[^<>]|<<(?:[^<>]|<[^<>]*>)*>>|<[^<>]*>)*)>>\s*stream\r?\n`)
+
+// extractPDFText pulls the text shown by a PDF's page content streams. It
+// understands uncompressed and Flate-compressed streams and simple font
+// encodings, which covers PDFs exported from word processors; anything it
+// can't decode is skipped. It fails with errDocumentTooLarge if the streams
+// decompress to more than localExtractMaxBytes in all.
+func extractPDFText(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return "", fmt.Errorf("invalid PDF file")
+	}
+
+	var text strings.Builder
+	var inflatedBytes int64
+	for _, loc := range pdfStream.FindAllSubmatchIndex(data, -1) {
+		dict := data[loc[2]:loc[3]]
+		body := data[loc[1]:]
+		end := bytes.Index(body, []byte("endstream"))
+		if end < 0 {
+			continue
+		}
+		body = body[:end]
+
+		// Page content streams carry no /Type; fonts, images, and object
+		// streams do, or have font program lengths
+		if bytes.Contains(dict, []byte("/Type")) || bytes.Contains(dict, []byte("/Length1")) || bytes.Contains(dict, []byte("/Subtype")) {
+			continue
+		}
+		if bytes.Contains(dict, []byte("/Filter")) {
+			if !bytes.Contains(dict, []byte("/FlateDecode")) || bytes.Contains(dict, []byte("/DecodeParms")) {
+				continue
+			}
+			inflated, err := inflate(body)
+			if errors.Is(err, errDocumentTooLarge) {
+				return "", err
+			}
+			if err != nil {
+				continue
+			}
+			if inflatedBytes += int64(len(inflated)); inflatedBytes > localExtractMaxBytes {
+				return "", errDocumentTooLarge
+			}
+			body = inflated
+		}
+
+		writePDFContentText(&text, body)
+	}
+
+	return text.String(), nil
+}
+
+// inflate decompresses zlib data, keeping whatever decoded before an error
+// so a stream with trailing garbage still yields its text. Data inflating to
+// more than localExtractMaxBytes fails with errDocumentTooLarge.
+func inflate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	inflated, err := readLimited(reader)
+	if errors.Is(err, errDocumentTooLarge) {
+		return nil, err
+	}
+	if len(inflated) > 0 {
+		return inflated, nil
+	}
+	return nil, err
+}
+
+// writePDFContentText writes the strings shown by a content stream's text
+// operators, starting a new line when the text position moves to a new line
+func writePDFContentText(text *strings.Builder, content []byte) {
+	var operands []string
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, next := readPDFLiteral(content, i)
+			operands = append(operands, s)
+			i = next
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return
+			}
+			operands = append(operands, decodePDFHex(content[i+1:i+end]))
+			i += end + 1
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case isPDFRegular(c) && !isPDFNumberByte(c):
+			start := i
+			for i < len(content) && isPDFRegular(content[i]) {
+				i++
+			}
+			switch string(content[start:i]) {
+			case "Tj", "TJ":
+				text.WriteString(strings.Join(operands, ""))
+			case "'", `"`:
+				text.WriteByte('\n')
+				text.WriteString(strings.Join(operands, ""))
+			case "T*", "Td", "TD", "ET":
+				text.WriteByte('\n')
+			}
+			operands = operands[:0]
+		case c == '-' && i+1 < len(content) && isPDFDigit(content[i+1]) && len(operands) > 0:
+			// A large negative adjustment inside a TJ array separates words
+			start := i
+			for i++; i < len(content) && isPDFNumberByte(content[i]); i++ {
+			}
+			if n, err := strconv.ParseFloat(string(content[start:i]), 64); err == nil && n < -200 {
+				operands = append(operands, " ")
+			}
+		default:
+			i++
+		}
+	}
+}
+
+// readPDFLiteral decodes the literal string starting at content[start], which
+// is '(', returning it and the index just past its closing parenthesis
+func readPDFLiteral(content []byte, start int) (string, int) {
+	var s []byte
+	depth := 0
+	i := start
+	for ; i < len(content); i++ {
+		c := content[i]
+		switch c {
+		case '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfBytesToText(s), i + 1
+			}
+		case '\\':
+			i++
+			if i >= len(content) {
+				return pdfBytesToText(s), i
+			}
+			switch e := content[i]; e {
+			case 'n':
+				s = append(s, '\n')
+			case 'r', '\r', '\n':
+				// A line break in the source or an escaped one adds nothing
+			case 't':
+				s = append(s, '\t')
+			case 'b', 'f':
+			default:
+				if e >= '0' && e <= '7' {
+					n := 0
+					for j := 0; j < 3 && i < len(content) && content[i] >= '0' && content[i] <= '7'; j++ {
+						n = n*8 + int(content[i]-'0')
+						i++
+					}
+					i--
+					s = append(s, byte(n))
+				} else {
+					s = append(s, e)
+				}
+			}
+			continue
+		}
+		s = append(s, c)
+	}
+	return pdfBytesToText(s), i
+}
+
+// decodePDFHex decodes a hex string's digits, ignoring whitespace and padding
+// an odd final digit with zero as the PDF spec requires
+func decodePDFHex(digits []byte) string {
+	clean := bytes.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, digits)
+	if len(clean)%2 == 1 {
+		clean = append(clean, '0')
+	}
+	decoded, err := hex.DecodeString(string(clean))
+	if err != nil {
+		return ""
+	}
+	return pdfBytesToText(decoded)
+}
+
+// pdfBytesToText interprets string bytes as Latin-1, the common case for
+// simple fonts, dropping control characters
+func pdfBytesToText(s []byte) string {
+	var text strings.Builder
+	for _, b := range s {
+		r := rune(b)
+		if r == '\n' || r == '\t' || !unicode.IsControl(r) {
+			text.WriteRune(r)
+		}
+	}
+	return text.String()
+}
+
+// isPDFRegular reports whether c belongs to a PDF token rather than being
+// whitespace or a delimiter
+func isPDFRegular(c byte) bool {
+	return !strings.ContainsRune(" \t\r\n\f\x00()<>[]{}/%", rune(c))
+}
+
+func isPDFDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isPDFNumberByte(c byte) bool {
+	return isPDFDigit(c) || c == '.' || c == '-' || c == '+'
+}
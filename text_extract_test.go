@@ -0,0 +1,238 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// pdfObject is one object of a test PDF: a stream when content is set, with
+// dict holding the entries besides /Length
+type pdfObject struct {
+	dict    string
+	content []byte
+}
+
+// buildPDF writes a minimal PDF with the given objects, numbered from 1
+func buildPDF(objects ...pdfObject) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	for i, obj := range objects {
+		fmt.Fprintf(&b, "%d 0 obj\n", i+1)
+		if obj.content == nil {
+			fmt.Fprintf(&b, "<<%s>>\nendobj\n", obj.dict)
+			continue
+		}
+		fmt.Fprintf(&b, "<<%s /Length %d>>\nstream\n", obj.dict, len(obj.content))
+		b.Write(obj.content)
+		b.WriteString("\nendstream\nendobj\n")
+	}
+	b.WriteString("trailer\n<</Root 1 0 R>>\n%%EOF\n")
+	return b.Bytes()
+}
+
+// deflate zlib-compresses data as a /FlateDecode stream
+func deflate(data []byte) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write(data)
+	w.Close()
+	return b.Bytes()
+}
+
+// pageObjects returns the catalog, page tree, page, and font objects a
+// one-page test PDF needs, with the page's content stream as object 5
+func pageObjects() []pdfObject {
+	return []pdfObject{
+		{dict: "/Type /Catalog /Pages 2 0 R"},
+		{dict: "/Type /Pages /Kids [3 0 R] /Count 1"},
+		{dict: "/Type /Page /Parent 2 0 R /Resources <</Font <</F1 4 0 R>>>> /Contents 5 0 R"},
+		{dict: "/Type /Font /Subtype /Type1 /BaseFont /Helvetica"},
+	}
+}
+
+func TestExtractPDFText(t *testing.T) {
+	const content = "BT /F1 12 Tf 72 720 Td (Acme Steel Ltd) Tj 0 -14 Td (Outputs: steel slag) Tj ET"
+
+	tests := []struct {
+		name    string
+		objects []pdfObject
+		want    string
+	}{
+		{
+			name:    "uncompressed content",
+			objects: append(pageObjects(), pdfObject{content: []byte(content)}),
+			want:    "Acme Steel Ltd\nOutputs: steel slag",
+		},
+		{
+			name:    "Flate-compressed content",
+			objects: append(pageObjects(), pdfObject{dict: "/Filter /FlateDecode", content: deflate([]byte(content))}),
+			want:    "Acme Steel Ltd\nOutputs: steel slag",
+		},
+		{
+			name: "TJ arrays, word gaps, and hex strings",
+			objects: append(pageObjects(), pdfObject{content: []byte(
+				"BT /F1 12 Tf [(Scrap)-250(steel)] TJ T* <4D696C6C207363616C65> Tj ET")}),
+			want: "Scrap steel\nMill scale",
+		},
+		{
+			name: "escapes and nested parentheses",
+			objects: append(pageObjects(), pdfObject{content: []byte(
+				`BT (Fly ash \(PFA\) \050grade A\051) Tj T* (Caf\351 \\ line\nbreak) Tj ET`)}),
+			want: "Fly ash (PFA) (grade A)\nCafé \\ line\nbreak",
+		},
+		{
+			name: "font and image streams skipped",
+			objects: append(pageObjects(),
+				pdfObject{content: []byte("BT (Visible) Tj ET")},
+				pdfObject{dict: "/Length1 9", content: []byte("BT (FontProgram) Tj ET")},
+				pdfObject{dict: "/Type /XObject /Subtype /Image", content: []byte("BT (Pixels) Tj ET")},
+			),
+			want: "Visible",
+		},
+		{
+			name: "unsupported filters skipped",
+			objects: append(pageObjects(),
+				pdfObject{dict: "/Filter /DCTDecode", content: []byte("BT (JPEG) Tj ET")},
+				pdfObject{dict: "/Filter /FlateDecode /DecodeParms <</Predictor 12>>", content: deflate([]byte("BT (Predicted) Tj ET"))},
+				pdfObject{dict: "/Filter /FlateDecode", content: []byte("not zlib data")},
+			),
+			want: "",
+		},
+		{
+			name:    "no text",
+			objects: append(pageObjects(), pdfObject{content: []byte("q 1 0 0 1 0 0 cm 0 0 100 100 re f Q")}),
+			want:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractPDFText(buildPDF(tt.objects...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got = strings.TrimSpace(got); got != tt.want {
+				t.Errorf("extractPDFText = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractPDFTextInvalid(t *testing.T) {
+	if _, err := extractPDFText([]byte("PK\x03\x04 not a PDF")); err == nil {
+		t.Error("extractPDFText accepted a file without the %PDF header")
+	}
+}
+
+func TestExtractPDFTextTooLarge(t *testing.T) {
+	localExtractMaxBytes = 1 << 10
+	t.Cleanup(func() { localExtractMaxBytes = 50 << 20 })
+
+	padding := "BT (" + strings.Repeat("x", 600) + ") Tj ET"
+	tests := []struct {
+		name    string
+		objects []pdfObject
+	}{
+		{
+			name:    "one stream inflating past the cap",
+			objects: append(pageObjects(), pdfObject{dict: "/Filter /FlateDecode", content: deflate(make([]byte, 1<<20))}),
+		},
+		{
+			name: "streams inflating past the cap together",
+			objects: append(pageObjects(),
+				pdfObject{dict: "/Filter /FlateDecode", content: deflate([]byte(padding))},
+				pdfObject{dict: "/Filter /FlateDecode", content: deflate([]byte(padding))},
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := extractPDFText(buildPDF(tt.objects...)); !errors.Is(err, errDocumentTooLarge) {
+				t.Errorf("extractPDFText error = %v, want errDocumentTooLarge", err)
+			}
+		})
+	}
+}
+
+// buildDOCX writes a zip holding the given parts
+func buildDOCX(t *testing.T, parts map[string]string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for name, content := range parts {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestExtractDOCXText(t *testing.T) {
+	const document = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:body>
+<w:p><w:r><w:t>Acme Steel Ltd</w:t></w:r></w:p>
+<w:p><w:r><w:t xml:space="preserve">Outputs: </w:t></w:r><w:r><w:t>steel slag</w:t></w:r><w:r><w:tab/><w:t>200 t/month</w:t></w:r></w:p>
+<w:p><w:r><w:t>Inputs:</w:t><w:br/><w:t>scrap &amp; pig iron</w:t></w:r></w:p>
+<w:sectPr><w:pgSz w:w="11906" w:h="16838"/></w:sectPr>
+</w:body>
+</w:document>`
+
+	data := buildDOCX(t, map[string]string{
+		"[Content_Types].xml": `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"/>`,
+		"word/document.xml":   document,
+	})
+	got, err := extractDOCXText(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Acme Steel Ltd\nOutputs: steel slag\t200 t/month\nInputs:\nscrap & pig iron\n"
+	if got != want {
+		t.Errorf("extractDOCXText = %q, want %q", got, want)
+	}
+}
+
+func TestExtractDOCXTextInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not a zip", []byte("%PDF-1.4")},
+		{"no document part", buildDOCX(t, map[string]string{"word/styles.xml": "<w:styles/>"})},
+		{"malformed XML", buildDOCX(t, map[string]string{"word/document.xml": "<w:document><w:body>"})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := extractDOCXText(tt.data); err == nil {
+				t.Error("extractDOCXText succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestExtractDOCXTextTooLarge(t *testing.T) {
+	localExtractMaxBytes = 1 << 10
+	t.Cleanup(func() { localExtractMaxBytes = 50 << 20 })
+
+	document := "<w:document><w:body><w:p><w:r><w:t>" + strings.Repeat("slag ", 1<<12) + "</w:t></w:r></w:p></w:body></w:document>"
+	data := buildDOCX(t, map[string]string{"word/document.xml": document})
+	if len(data) > int(localExtractMaxBytes) {
+		t.Fatalf("test DOCX is %d bytes; it should compress below the cap", len(data))
+	}
+
+	if _, err := extractDOCXText(data); !errors.Is(err, errDocumentTooLarge) {
+		t.Errorf("extractDOCXText error = %v, want errDocumentTooLarge", err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// uploadCleaner periodically deletes uploaded documents nobody needs any
+// more: those of tasks that failed, or whose profiles were deleted, longer
+// than the retention window ago. Failed tasks can be retried until then.
+type uploadCleaner struct {
+	interval  time.Duration
+	retention time.Duration
+	batchSize int
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+var orphanCleaner *uploadCleaner
+
+// InitUploadCleanup starts the upload cleanup job unless UPLOAD_CLEANUP_ENABLED
+// is false. At startup and then every UPLOAD_CLEANUP_INTERVAL it releases the
+// files of up to UPLOAD_CLEANUP_BATCH_SIZE tasks that failed, or whose
+// profiles were deleted, more than UPLOAD_RETENTION ago.
+func InitUploadCleanup() error {
+	if !getEnvBool("UPLOAD_CLEANUP_ENABLED", true) {
+		return nil
+	}
+
+	u := &uploadCleaner{
+		interval:  getEnvDuration("UPLOAD_CLEANUP_INTERVAL", 6*time.Hour),
+		retention: getEnvDuration("UPLOAD_RETENTION", 7*24*time.Hour),
+		batchSize: getEnvInt("UPLOAD_CLEANUP_BATCH_SIZE", 100),
+		stop:      make(chan struct{}),
+	}
+	if u.interval <= 0 {
+		return fmt.Errorf("UPLOAD_CLEANUP_INTERVAL must be positive")
+	}
+	if u.retention < 0 {
+		return fmt.Errorf("UPLOAD_RETENTION must not be negative")
+	}
+	if u.batchSize < 1 {
+		return fmt.Errorf("UPLOAD_CLEANUP_BATCH_SIZE must be at least 1")
+	}
+
+	orphanCleaner = u
+	go u.run()
+	slog.Info("Upload cleanup job started",
+		"interval", u.interval.String(),
+		"retention", u.retention.String(),
+		"batch_size", u.batchSize,
+	)
+	return nil
+}
+
+// StopUploadCleanup stops the upload cleanup job, if it is running
+func StopUploadCleanup() {
+	if u := orphanCleaner; u != nil {
+		u.stopOnce.Do(func() { close(u.stop) })
+	}
+}
+
+func (u *uploadCleaner) run() {
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+
+	for {
+		u.cleanup()
+		select {
+		case <-u.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanup releases the files of one batch of tasks. A file is deleted only if
+// no task still in use shares it; tasks whose files couldn't all be checked
+// or deleted, or were being claimed by a new task, are left for the next run.
+func (u *uploadCleaner) cleanup() {
+	logger := slog.With("job", "upload_cleanup")
+	releasedBefore := time.Now().Add(-u.retention)
+
+	tasks, err := ListReleasableUploadTasks(releasedBefore, u.batchSize)
+	if err != nil {
+		logger.Error("Failed to list tasks with releasable uploads", "error", err)
+		return
+	}
+	if len(tasks) == 0 {
+		return
+	}
+
+	released, deleted, shared := 0, 0, 0
+	var reclaimed int64
+	for _, task := range tasks {
+		select {
+		case <-u.stop:
+			return
+		default:
+		}
+
+		fileURLs := task.FileURLs
+		if len(fileURLs) == 0 && task.FileURL != "" {
+			fileURLs = []string{task.FileURL}
+		}
+
+		ok := true
+		for _, fileURL := range fileURLs {
+			size := uploadSize(fileURL)
+			inUse, err := DeleteUnusedUpload(fileURL, releasedBefore)
+			if errors.Is(err, errUploadLocked) {
+				logger.Info("Upload is being claimed by a new task, leaving it for the next run", "task_id", task.ID, "file", fileURL)
+				ok = false
+				continue
+			}
+			if err != nil {
+				logger.Error("Failed to delete upload", "task_id", task.ID, "file", fileURL, "error", err)
+				ok = false
+				continue
+			}
+			if inUse {
+				shared++
+				continue
+			}
+			deleted++
+			reclaimed += size
+			logger.Info("Deleted orphaned upload", "task_id", task.ID, "task_status", task.Status, "file", fileURL, "bytes", size)
+		}
+		if !ok {
+			continue
+		}
+
+		if err := MarkTaskFilesDeleted(task.ID); err != nil {
+			logger.Error("Failed to mark task files deleted", "task_id", task.ID, "error", err)
+			continue
+		}
+		released++
+	}
+
+	logger.Info("Cleaned up orphaned uploads",
+		"tasks", len(tasks),
+		"tasks_released", released,
+		"files_deleted", deleted,
+		"files_still_shared", shared,
+		"bytes_reclaimed", reclaimed,
+	)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError describes one problem with a field of a request body, named by
+// its JSON path, e.g. outputs[0].name
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// requestValidator checks request DTOs' binding tags. It is separate from
+// gin's validator so naming fields by their JSON keys doesn't change errors
+// from anything else bound through gin.
+var requestValidator = newRequestValidator()
+
+func newRequestValidator() *validator.Validate {
+	v := validator.New()
+	v.SetTagName("binding")
+	// Name fields in errors as they appear in JSON
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	return v
+}
+
+// bindStrictJSON is bindJSON for bodies that must match obj exactly: fields
+// obj doesn't have are rejected rather than ignored, so a typo like
+// "latitude" doesn't silently leave a zero value, and obj's binding tags are
+// checked. On failure it responds with 400 naming the offending field(s), or
+// 413 if the body is over MAX_BODY_BYTES, and returns false.
+func bindStrictJSON(c *gin.Context, obj interface{}) bool {
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(obj)
+	if err == nil && decoder.More() {
+		respondError(c, http.StatusBadRequest, "Request body is not valid JSON: unexpected data after the top-level value")
+		return false
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case err == nil:
+	case errors.As(err, &maxBytesErr):
+		respondError(c, http.StatusRequestEntityTooLarge, bodyTooLargeMessage())
+		return false
+	case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		respondError(c, http.StatusBadRequest, "Request body is not valid JSON: "+err.Error())
+		return false
+	default:
+		respondFieldErrors(c, []FieldError{decodeFieldError(err)})
+		return false
+	}
+
+	if fields := validationFieldErrors(obj); len(fields) > 0 {
+		respondFieldErrors(c, fields)
+		return false
+	}
+	return true
+}
+
+// decodeFieldError describes an error from decoding well-formed JSON: an
+// unknown field or a value of the wrong type
+func decodeFieldError(err error) FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return FieldError{Field: typeErr.Field, Message: fmt.Sprintf("must be %s, not %s", jsonTypeName(typeErr.Type), typeErr.Value)}
+	}
+	// encoding/json has no error type for these, only the message
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return FieldError{Field: strings.Trim(field, `"`), Message: "is not a known field"}
+	}
+	return FieldError{Message: err.Error()}
+}
+
+// jsonTypeName names the JSON form of a Go type, e.g. "a number" for float64
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// validationFieldErrors checks obj's binding tags, returning an error for
+// each field that fails
+func validationFieldErrors(obj interface{}) []FieldError {
+	err := requestValidator.Struct(obj)
+	if err == nil {
+		return nil
+	}
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return []FieldError{{Message: err.Error()}}
+	}
+
+	errs := make([]FieldError, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		// The namespace starts with the type's name, e.g. ProfileRequest.location.lat
+		_, field, _ := strings.Cut(fe.Namespace(), ".")
+		errs = append(errs, FieldError{Field: field, Message: validationMessage(fe)})
+	}
+	return errs
+}
+
+// validationMessage words a failed binding tag
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		if fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("must have at least %s item(s)", fe.Param())
+		}
+		return "must be at least " + fe.Param()
+	case "gte":
+		return "must be at least " + fe.Param()
+	case "lte":
+		return "must be at most " + fe.Param()
+	default:
+		return fmt.Sprintf("failed the %s check", fe.Tag())
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBindStrictJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int // 0 when the body binds
+		wantFields []FieldError
+	}{
+		{
+			name: "valid",
+			body: `{"name": "Acme", "location": {"lat": 51.5, "lng": -0.1}, "outputs": [{"name": "slag"}], "inputs": [{"name": "scrap"}]}`,
+		},
+		{
+			name:       "unknown field",
+			body:       `{"name": "Acme", "location": {"latitude": 51.5}, "outputs": [{"name": "slag"}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantFields: []FieldError{{Field: "latitude", Message: "is not a known field"}},
+		},
+		{
+			name:       "wrong type",
+			body:       `{"name": 7, "outputs": [{"name": "slag"}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantFields: []FieldError{{Field: "name", Message: "must be a string, not number"}},
+		},
+		{
+			name:       "failed checks",
+			body:       `{"name": "Acme", "location": {"lat": 91, "lng": 0}, "outputs": [{"name": "slag"}, {"state": "solid"}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantFields: []FieldError{
+				{Field: "location.lat", Message: "must be at most 90"},
+				{Field: "outputs[1].name", Message: "is required"},
+			},
+		},
+		{
+			name:       "missing outputs",
+			body:       `{"name": "Acme"}`,
+			wantStatus: http.StatusBadRequest,
+			wantFields: []FieldError{{Field: "outputs", Message: "is required"}},
+		},
+		{
+			name:       "not JSON",
+			body:       `{"name": `,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "trailing data",
+			body:       `{"name": "Acme", "outputs": [{"name": "slag"}]} {}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPut, "/", strings.NewReader(tt.body))
+
+			var req ProfileRequest
+			ok := bindStrictJSON(c, &req)
+			if ok != (tt.wantStatus == 0) {
+				t.Fatalf("bindStrictJSON = %v, response %d %s", ok, w.Code, w.Body)
+			}
+			if ok {
+				return
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			var resp struct {
+				Error APIError `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantFields != nil && !reflect.DeepEqual(resp.Error.Fields, tt.wantFields) {
+				t.Errorf("fields = %+v, want %+v", resp.Error.Fields, tt.wantFields)
+			}
+		})
+	}
+}
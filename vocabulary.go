@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// defaultMaterialSynonyms folds common spelling variants and abbreviations
+// onto one form. Entries match whole words or phrases of a normalized name.
+var defaultMaterialSynonyms = map[string]string{
+	"aluminium": "aluminum",
+	"al":        "aluminum",
+	"sulphur":   "sulfur",
+	"sulphate":  "sulfate",
+	"sulphuric": "sulfuric",
+	"fibre":     "fiber",
+	"fibres":    "fibers",
+	"colour":    "color",
+	"tyre":      "tire",
+	"tyres":     "tires",
+	"hdpe":      "high-density polyethylene",
+	"ldpe":      "low-density polyethylene",
+	"pvc":       "polyvinyl chloride",
+	"co2":       "carbon dioxide",
+}
+
+// MaterialVocabulary maps the many names a material goes by onto one
+// canonical name, so the same waste stream matches and caches the same way
+// whatever a document calls it
+type MaterialVocabulary struct {
+	mu       sync.RWMutex
+	synonyms map[string]string
+	maxWords int               // longest synonym key, in words
+	learned  map[string]string // canonical names Gemini suggested, by normalized name
+	useAI    bool
+}
+
+var vocabulary = NewMaterialVocabulary(defaultMaterialSynonyms, false)
+
+// InitVocabulary loads the material vocabulary: the built-in synonyms plus
+// any in the JSON object of variant to canonical names at
+// MATERIAL_SYNONYMS_FILE. MATERIAL_CANONICALIZE_WITH_GEMINI asks Gemini for
+// names the dictionary leaves unchanged.
+func InitVocabulary() error {
+	synonyms := make(map[string]string, len(defaultMaterialSynonyms))
+	for variant, canonical := range defaultMaterialSynonyms {
+		synonyms[variant] = canonical
+	}
+
+	if path := os.Getenv("MATERIAL_SYNONYMS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read MATERIAL_SYNONYMS_FILE: %w", err)
+		}
+		var custom map[string]string
+		if err := json.Unmarshal(data, &custom); err != nil {
+			return fmt.Errorf("invalid MATERIAL_SYNONYMS_FILE: %w", err)
+		}
+		for variant, canonical := range custom {
+			synonyms[variant] = canonical
+		}
+	}
+
+	vocabulary = NewMaterialVocabulary(synonyms, getEnvBool("MATERIAL_CANONICALIZE_WITH_GEMINI", false))
+	slog.Info("Material vocabulary loaded", "synonyms", len(synonyms), "gemini", vocabulary.useAI)
+	return nil
+}
+
+// NewMaterialVocabulary creates a vocabulary from variant to canonical names
+func NewMaterialVocabulary(synonyms map[string]string, useAI bool) *MaterialVocabulary {
+	v := &MaterialVocabulary{
+		synonyms: make(map[string]string, len(synonyms)),
+		learned:  make(map[string]string),
+		useAI:    useAI,
+	}
+	for variant, canonical := range synonyms {
+		key := normalizeKey(variant)
+		if key == "" {
+			continue
+		}
+		v.synonyms[key] = normalizeKey(canonical)
+		v.maxWords = max(v.maxWords, len(strings.Fields(key)))
+	}
+	return v
+}
+
+// Canonical returns a material's canonical name using the dictionary and any
+// names Gemini suggested earlier: lowercased, whitespace collapsed, and
+// synonyms replaced, longest phrase first
+func (v *MaterialVocabulary) Canonical(name string) string {
+	key := normalizeKey(name)
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if learned, ok := v.learned[key]; ok {
+		return learned
+	}
+
+	words := strings.Fields(key)
+	canonical := make([]string, 0, len(words))
+	for i := 0; i < len(words); {
+		n := min(v.maxWords, len(words)-i)
+		for ; n > 0; n-- {
+			if synonym, ok := v.synonyms[strings.Join(words[i:i+n], " ")]; ok {
+				canonical = append(canonical, synonym)
+				break
+			}
+		}
+		if n == 0 {
+			canonical = append(canonical, words[i])
+			n = 1
+		}
+		i += n
+	}
+	return strings.Join(canonical, " ")
+}
+
+// Canonicalize is Canonical, but when Gemini canonicalization is enabled it
+// also asks Gemini about names the dictionary leaves unchanged, remembering
+// the answer. Failures fall back to the dictionary result.
+func (v *MaterialVocabulary) Canonicalize(ctx context.Context, name string) string {
+	canonical := v.Canonical(name)
+	key := normalizeKey(name)
+	if !v.useAI || mcpClient == nil || canonical != key || key == "" {
+		return canonical
+	}
+
+	suggested, err := mcpClient.CanonicalizeMaterial(ctx, name)
+	if err != nil {
+		loggerFromContext(ctx).Warn("Failed to canonicalize material name", "name", name, "error", err)
+		return canonical
+	}
+	suggested = v.Canonical(suggested)
+	if suggested == "" {
+		return canonical
+	}
+
+	v.mu.Lock()
+	v.learned[key] = suggested
+	v.mu.Unlock()
+	return suggested
+}
+
+// canonicalName returns the output's canonical name, deriving it from the
+// dictionary if it hasn't been set
+func (o Output) canonicalName() string {
+	if o.CanonicalName != "" {
+		return o.CanonicalName
+	}
+	return vocabulary.Canonical(o.Name)
+}
+
+// canonicalName returns the input's canonical name, deriving it from the
+// dictionary if it hasn't been set
+func (in Input) canonicalName() string {
+	if in.CanonicalName != "" {
+		return in.CanonicalName
+	}
+	return vocabulary.Canonical(in.Name)
+}
+
+// canonicalizeProfile sets the canonical name of each of a profile's inputs
+// and outputs, consulting Gemini if enabled. The names as extracted are kept.
+func canonicalizeProfile(ctx context.Context, profile *IndustryProfile) {
+	for i := range profile.Inputs {
+		profile.Inputs[i].CanonicalName = vocabulary.Canonicalize(ctx, profile.Inputs[i].Name)
+	}
+	for i := range profile.Outputs {
+		profile.Outputs[i].CanonicalName = vocabulary.Canonicalize(ctx, profile.Outputs[i].Name)
+	}
+}
+
+// fillCanonicalNames sets missing canonical names from the dictionary alone,
+// for profiles saved before names were canonicalized
+func fillCanonicalNames(profile *IndustryProfile) {
+	for i := range profile.Inputs {
+		if profile.Inputs[i].CanonicalName == "" {
+			profile.Inputs[i].CanonicalName = vocabulary.Canonical(profile.Inputs[i].Name)
+		}
+	}
+	for i := range profile.Outputs {
+		if profile.Outputs[i].CanonicalName == "" {
+			profile.Outputs[i].CanonicalName = vocabulary.Canonical(profile.Outputs[i].Name)
+		}
+	}
+}
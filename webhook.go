@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// webhookSignatureHeader carries the hex HMAC-SHA256 of the request body,
+// keyed with WEBHOOK_SECRET, as "sha256=<hex>"
+const webhookSignatureHeader = "X-Signature-256"
+
+var (
+	webhookClient       *http.Client
+	webhookSecret       []byte
+	webhookMaxAttempts  int
+	webhookRetryDelay   time.Duration
+	webhookAllowPrivate bool
+)
+
+// webhookDeliveries tracks deliveries in progress, so shutdown can let them
+// finish
+var webhookDeliveries sync.WaitGroup
+
+// errInternalCallback is returned for callbacks to loopback, private,
+// link-local, or unspecified addresses
+var errInternalCallback = errors.New("callback_url must not point at a private or local address")
+
+// InitWebhooks configures delivery of task completion callbacks. Without
+// WEBHOOK_SECRET uploads can't ask for one, since deliveries couldn't be
+// signed.
+func InitWebhooks() error {
+	webhookMaxAttempts = max(getEnvInt("WEBHOOK_MAX_ATTEMPTS", 3), 1)
+	webhookRetryDelay = getEnvDuration("WEBHOOK_RETRY_DELAY", 2*time.Second)
+	webhookAllowPrivate = getEnvBool("WEBHOOK_ALLOW_PRIVATE_HOSTS", false)
+
+	// Connect directly, never through a proxy, so checkWebhookDial sees the
+	// address actually dialed
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: checkWebhookDial}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	webhookClient = &http.Client{
+		Timeout:   getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		Transport: transport,
+	}
+
+	webhookSecret = []byte(os.Getenv("WEBHOOK_SECRET"))
+	if len(webhookSecret) == 0 {
+		slog.Info("WEBHOOK_SECRET not set; uploads with a callback_url will be refused")
+	}
+	if webhookAllowPrivate {
+		slog.Warn("WEBHOOK_ALLOW_PRIVATE_HOSTS is set; task callbacks may reach internal addresses")
+	}
+	return nil
+}
+
+// validateCallbackURL checks that a callback URL is an absolute http(s) URL
+// whose host resolves only to public addresses, and that deliveries can be
+// signed. The resolved addresses are checked again on every connection.
+func validateCallbackURL(ctx context.Context, raw string) error {
+	if len(webhookSecret) == 0 {
+		return fmt.Errorf("callback_url is not available: the server has no WEBHOOK_SECRET to sign deliveries with")
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callback_url must be an absolute http or https URL")
+	}
+	if webhookAllowPrivate {
+		return nil
+	}
+
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("callback_url host %q could not be resolved", u.Hostname())
+	}
+	for _, ip := range ips {
+		if isInternalAddr(ip) {
+			return errInternalCallback
+		}
+	}
+	return nil
+}
+
+// checkWebhookDial is the webhook dialer's Control hook. It sees the resolved
+// address of every connection, redirects included, so a host that resolves
+// to an internal address by delivery time (DNS rebinding) is still refused.
+func checkWebhookDial(network, address string, _ syscall.RawConn) error {
+	if webhookAllowPrivate {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if isInternalAddr(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", errInternalCallback, addrPort.Addr())
+	}
+	return nil
+}
+
+// isInternalAddr reports whether ip is loopback, private, link-local (which
+// includes cloud metadata at 169.254.169.254), multicast, or unspecified
+func isInternalAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// signWebhook returns the signature header value for a payload
+func signWebhook(payload []byte) string {
+	mac := hmac.New(sha256.New, webhookSecret)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverTaskWebhook POSTs a finished task's JSON to its callback URL in the
+// background, so retries don't hold up a worker. Network errors and non-2xx
+// responses are retried with a growing delay. The first attempt is made even
+// if ctx is cancelled, so clients still hear about tasks interrupted by
+// shutdown, but retries stop once ctx is done.
+func deliverTaskWebhook(ctx context.Context, task *Task) {
+	logger := loggerFromContext(ctx).With("task_id", task.ID)
+	if len(webhookSecret) == 0 {
+		logger.Error("Not delivering task webhook: WEBHOOK_SECRET is not set")
+		return
+	}
+
+	// Send download URLs rather than storage paths, without touching the caller's task
+	payloadTask := *task
+	payloadTask.FileURLs = append([]string(nil), task.FileURLs...)
+	presignTaskFiles(logger, &payloadTask)
+
+	payload, err := json.Marshal(&payloadTask)
+	if err != nil {
+		logger.Error("Failed to encode task webhook", "error", err)
+		return
+	}
+
+	webhookDeliveries.Add(1)
+	go func() {
+		defer webhookDeliveries.Done()
+		sendTaskWebhook(ctx, logger, task.CallbackURL, payload)
+	}()
+}
+
+// sendTaskWebhook delivers a task webhook payload, retrying as described for
+// deliverTaskWebhook
+func sendTaskWebhook(ctx context.Context, logger *slog.Logger, callbackURL string, payload []byte) {
+	sendCtx := context.WithoutCancel(ctx)
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := postWebhook(sendCtx, callbackURL, payload)
+		if err == nil {
+			logger.Info("Delivered task webhook", "attempt", attempt)
+			return
+		}
+		if attempt >= webhookMaxAttempts || ctx.Err() != nil {
+			logger.Error("Giving up on task webhook", "attempts", attempt, "error", err)
+			return
+		}
+		logger.Warn("Task webhook failed, retrying", "attempt", attempt, "retry_in", delay.String(), "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Error("Giving up on task webhook", "attempts", attempt, "error", ctx.Err())
+			return
+		}
+		delay *= 2
+	}
+}
+
+// WaitWebhooks waits for webhook deliveries in progress to finish, or for ctx
+// to be done, in which case it returns ctx's error
+func WaitWebhooks(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		webhookDeliveries.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// postWebhook makes a single signed delivery attempt
+func postWebhook(ctx context.Context, callbackURL string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookSignatureHeader, signWebhook(payload))
+	if requestID := requestIDFromContext(ctx); requestID != "" {
+		req.Header.Set(requestIDHeader, requestID)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("callback returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// initTestWebhooks configures webhooks from the given WEBHOOK_* settings
+func initTestWebhooks(t *testing.T, env map[string]string) {
+	t.Helper()
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+	if err := InitWebhooks(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsInternalAddr(t *testing.T) {
+	tests := []struct {
+		addr     string
+		internal bool
+	}{
+		{"127.0.0.1", true},
+		{"127.8.9.10", true},
+		{"::1", true},
+		{"10.0.0.5", true},
+		{"172.16.3.4", true},
+		{"192.168.1.1", true},
+		{"fd00::1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"0.0.0.0", true},
+		{"::", true},
+		{"224.0.0.1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.1.2.3", true},
+		{"93.184.216.34", false},
+		{"172.32.0.1", false},
+		{"2606:4700::1111", false},
+		{"::ffff:8.8.8.8", false},
+	}
+
+	for _, tt := range tests {
+		if got := isInternalAddr(netip.MustParseAddr(tt.addr)); got != tt.internal {
+			t.Errorf("isInternalAddr(%s) = %v, want %v", tt.addr, got, tt.internal)
+		}
+	}
+}
+
+func TestValidateCallbackURL(t *testing.T) {
+	initTestWebhooks(t, map[string]string{"WEBHOOK_SECRET": "s3cret", "WEBHOOK_ALLOW_PRIVATE_HOSTS": "false"})
+
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://93.184.216.34/hooks", true},
+		{"http://[2606:4700::1111]:8080/hooks", true},
+		{"ftp://93.184.216.34/hooks", false},
+		{"/hooks/tasks", false},
+		{"http://127.0.0.1:8080/hooks", false},
+		{"http://localhost/hooks", false},
+		{"http://10.0.0.5/hooks", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://[::1]/hooks", false},
+		{"http://0.0.0.0/hooks", false},
+	}
+
+	for _, tt := range tests {
+		err := validateCallbackURL(context.Background(), tt.url)
+		if (err == nil) != tt.ok {
+			t.Errorf("validateCallbackURL(%q) error = %v, want ok = %v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestValidateCallbackURLNeedsSecret(t *testing.T) {
+	initTestWebhooks(t, map[string]string{"WEBHOOK_SECRET": ""})
+
+	if err := validateCallbackURL(context.Background(), "https://93.184.216.34/hooks"); err == nil {
+		t.Error("validateCallbackURL succeeded without WEBHOOK_SECRET")
+	}
+}
+
+// TestWebhookDialRefusesInternal checks the dial-time guard on its own, as it
+// would catch a public host re-resolving to loopback after validation
+func TestWebhookDialRefusesInternal(t *testing.T) {
+	initTestWebhooks(t, map[string]string{"WEBHOOK_SECRET": "s3cret", "WEBHOOK_ALLOW_PRIVATE_HOSTS": "false"})
+
+	hit := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hit = true }))
+	defer server.Close()
+
+	err := postWebhook(context.Background(), server.URL, []byte(`{}`))
+	if !errors.Is(err, errInternalCallback) {
+		t.Errorf("postWebhook to %s error = %v, want errInternalCallback", server.URL, err)
+	}
+	if hit {
+		t.Error("the internal server received the webhook")
+	}
+}
+
+func TestDeliverTaskWebhook(t *testing.T) {
+	initTestWebhooks(t, map[string]string{
+		"WEBHOOK_SECRET":              "s3cret",
+		"WEBHOOK_ALLOW_PRIVATE_HOSTS": "true",
+		"WEBHOOK_RETRY_DELAY":         "1ms",
+	})
+
+	type delivery struct {
+		body      []byte
+		signature string
+	}
+	deliveries := make(chan delivery, 3)
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := io.ReadAll(r.Body)
+		if attempts == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		deliveries <- delivery{body, r.Header.Get(webhookSignatureHeader)}
+	}))
+	defer server.Close()
+
+	task := &Task{ID: "task-1", Status: "completed", CallbackURL: server.URL}
+	deliverTaskWebhook(context.Background(), task)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := WaitWebhooks(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case d := <-deliveries:
+		if want := signWebhook(d.body); d.signature != want {
+			t.Errorf("signature = %q, want %q", d.signature, want)
+		}
+	default:
+		t.Fatal("webhook was not delivered")
+	}
+	if attempts != 2 {
+		t.Errorf("%d attempts, want 2 (one failure, one retry)", attempts)
+	}
+}
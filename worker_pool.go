@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// WorkerPool runs submitted jobs on a fixed number of goroutines. Jobs queue
+// in order when every worker is busy, so bursts don't fan out unbounded work.
+// Jobs should observe Context, which is cancelled when a shutdown runs out of
+// time.
+type WorkerPool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []func()
+	closed  bool
+	stopped bool
+	wg      sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+var workerPool *WorkerPool
+
+// InitWorkerPool starts the shared pool used for document processing and matching
+func InitWorkerPool() error {
+	size := getEnvInt("WORKER_POOL_SIZE", 4)
+	if size < 1 {
+		size = 1
+	}
+
+	workerPool = NewWorkerPool(size)
+	slog.Info("Worker pool started", "workers", size)
+	return nil
+}
+
+// NewWorkerPool creates a pool with the given number of workers
+func NewWorkerPool(size int) *WorkerPool {
+	p := &WorkerPool{}
+	p.cond = sync.NewCond(&p.mu)
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+
+	for i := 0; i < size; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+
+	return p
+}
+
+// Submit queues a job. It never blocks, so jobs may safely submit follow-up
+// jobs, including while the pool drains during shutdown. It returns false
+// once the pool has stopped.
+func (p *WorkerPool) Submit(job func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return false
+	}
+	p.queue = append(p.queue, job)
+	p.cond.Signal()
+	return true
+}
+
+// Pending returns the number of queued jobs not yet started
+func (p *WorkerPool) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue)
+}
+
+// Context returns the context background jobs should run under
+func (p *WorkerPool) Context() context.Context {
+	return p.ctx
+}
+
+// Shutdown waits for queued and running jobs to finish. If ctx expires first
+// it cancels the pool's context so remaining jobs wind down early, waits for
+// them, and returns ctx's error.
+func (p *WorkerPool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		p.cancel()
+		<-done
+	}
+
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+	p.cancel()
+
+	return err
+}
+
+func (p *WorkerPool) worker() {
+	defer p.wg.Done()
+
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if len(p.queue) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		job := p.queue[0]
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+
+		p.run(job)
+	}
+}
+
+// run executes a job, recovering from panics so the worker survives
+func (p *WorkerPool) run(job func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Worker job panicked", "panic", r)
+		}
+	}()
+	job()
+}